@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/luizcdc/redirectory/redirector/records"
+)
+
+// ENV_API_KEY_ID identifies the key set in API_KEY, which has every scope, in the redirects it
+// creates.
+const ENV_API_KEY_ID = "env"
+
+// AUTH_DISABLED serves the requests without an Authorization header with ANONYMOUS_SCOPES, for
+// local development.
+var AUTH_DISABLED bool
+
+// ANONYMOUS_SCOPES are the scopes of the requests served without an API key if AUTH_DISABLED is
+// set, which can't manage the API keys.
+var ANONYMOUS_SCOPES = []string{records.SCOPE_LINKS_READ, records.SCOPE_LINKS_WRITE, records.SCOPE_LINKS_DELETE, records.SCOPE_STATS_READ}
+
+// apiKeyContextKey is the key of the authenticated API key in contexts.
+type apiKeyContextKey struct{}
+
+// withAPIKey returns a context carrying the API key that authenticated a request.
+func withAPIKey(ctx context.Context, key records.APIKey) context.Context {
+	return context.WithValue(ctx, apiKeyContextKey{}, key)
+}
+
+// apiKeyFromContext returns the API key that authenticated the request of ctx, which has no ID
+// if the request wasn't authenticated.
+func apiKeyFromContext(ctx context.Context) records.APIKey {
+	key, _ := ctx.Value(apiKeyContextKey{}).(records.APIKey)
+	return key
+}
+
+// authenticate returns the API key in the Authorization header of a request: the one set in
+// API_KEY or one created through CreateAPIKey or loaded from API_KEYS_FILE. Requests without an
+// Authorization header are only given ANONYMOUS_SCOPES if AUTH_DISABLED is set.
+func authenticate(r *http.Request) (records.APIKey, error) {
+	authorization := r.Header.Get("Authorization")
+	if authorization == "" && AUTH_DISABLED {
+		return records.APIKey{Scopes: ANONYMOUS_SCOPES}, nil
+	}
+	token, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok || token == "" {
+		return records.APIKey{}, records.ErrInvalidAPIKey
+	}
+	if API_KEY != "" && subtle.ConstantTimeCompare([]byte(token), []byte(API_KEY)) == 1 {
+		return records.APIKey{ID: ENV_API_KEY_ID, Name: "API_KEY", Scopes: records.SCOPES}, nil
+	}
+	return records.AuthenticateAPIKey(r.Context(), token)
+}
+
+// requireScope wraps the handle of a route so that it replies with a 403 status to requests
+// whose API key wasn't granted scope.
+func requireScope(scope string, handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if !apiKeyFromContext(r.Context()).HasScope(scope) {
+			w.Header().Add("Content-Type", APPLICATION_JSON)
+			replyAPIKeyError(w, http.StatusForbidden, fmt.Sprintf("the API key lacks the '%v' scope", scope))
+			return
+		}
+		handle(w, r, ps)
+	}
+}
+
+// createAPIKeyBody is the JSON body expected by CreateAPIKey.
+type createAPIKeyBody struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// apiKeyReply is the JSON reply of the endpoints that manage API keys.
+type apiKeyReply struct {
+	Error interface{} `json:"error"`
+	// Key is the API key itself, only returned when it is created.
+	Key    string          `json:"key,omitempty"`
+	APIKey *records.APIKey `json:"api_key,omitempty"`
+}
+
+// replyAPIKeyError replies with the specified status code and error message in the "error"
+// field.
+func replyAPIKeyError(w http.ResponseWriter, status int, err string) {
+	w.WriteHeader(status)
+	resp, _ := json.Marshal(apiKeyReply{Error: err})
+	w.Write(resp)
+}
+
+// CreateAPIKey creates an API key. It expects a JSON payload in the request body with the
+// following structure:
+//
+//	{
+//	  "name": "ci",
+//	  "scopes": ["links:read", "links:write"]
+//	}
+//
+// where the scopes are any of "links:read", "links:write", "links:delete", "stats:read" and
+// "keys:admin". If the key is created successfully, the response will be:
+//
+//	{
+//	  "error": null,
+//	  "key": "rdk_0123456789ab_...",
+//	  "api_key": {"id": "0123456789ab", "name": "ci", "scopes": [...], "created_at": "...", ...}
+//	}
+//
+// where "key" is to be sent in the Authorization header as "Bearer <key>". It can't be retrieved
+// again, as only the hash of its secret is stored.
+func CreateAPIKey(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	replyError := func(status int, err string) { replyAPIKeyError(w, status, err) }
+	w.Header().Add("Content-Type", APPLICATION_JSON)
+
+	buffer, sizeRead, err := readJSONIntoBuffer(r, replyError)
+	if err != nil {
+		slog.DebugContext(r.Context(), "invalid request body", "error", err)
+		return
+	}
+	var jsonBody createAPIKeyBody
+	if err := json.Unmarshal(buffer[:sizeRead], &jsonBody); err != nil {
+		slog.DebugContext(r.Context(), "failure parsing the request's body", "error", err)
+		replyError(http.StatusBadRequest, fmt.Sprintf("error parsing json in the request's body: %v", err.Error()))
+		return
+	}
+	if strings.TrimSpace(jsonBody.Name) == "" {
+		replyError(http.StatusBadRequest, "the name is required")
+		return
+	}
+	if len(jsonBody.Scopes) == 0 {
+		replyError(http.StatusBadRequest, "at least one scope is required")
+		return
+	}
+	for _, scope := range jsonBody.Scopes {
+		if !records.IsScope(scope) {
+			replyError(http.StatusBadRequest, fmt.Sprintf("unknown scope '%v', must be one of %v", scope, strings.Join(records.SCOPES, ", ")))
+			return
+		}
+	}
+
+	ctx := r.Context()
+	key, token, err := records.CreateAPIKey(ctx, jsonBody.Name, jsonBody.Scopes)
+	if err != nil {
+		replyError(storeErrorStatus(err), "failure creating the API key")
+		return
+	}
+	key.Hash = ""
+	slog.InfoContext(ctx, "created API key", "api_key_id", key.ID, "name", key.Name, "scopes", key.Scopes,
+		"created_by", apiKeyFromContext(ctx).ID)
+	resp, _ := json.Marshal(apiKeyReply{nil, token, &key})
+	w.Write(resp)
+}
+
+// listAPIKeysReply is the JSON reply of ListAPIKeys.
+type listAPIKeysReply struct {
+	Error interface{}      `json:"error"`
+	Keys  []records.APIKey `json:"keys"`
+}
+
+// ListAPIKeys lists every API key, including the revoked ones, without their secrets. The key
+// set in API_KEY isn't listed. The response will be:
+//
+//	{
+//	  "error": null,
+//	  "keys": [{"id": "0123456789ab", "name": "ci", "scopes": [...], "created_at": "...", "revoked_at": "..."}]
+//	}
+func ListAPIKeys(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Add("Content-Type", APPLICATION_JSON)
+	keys, err := records.ListAPIKeys(r.Context())
+	if err != nil {
+		w.WriteHeader(storeErrorStatus(err))
+		resp, _ := json.Marshal(listAPIKeysReply{fmt.Sprintf("error listing API keys: %v", err.Error()), []records.APIKey{}})
+		w.Write(resp)
+		return
+	}
+	resp, _ := json.Marshal(listAPIKeysReply{nil, keys})
+	w.Write(resp)
+}
+
+// RevokeAPIKey revokes the API key with the given ID, which can't be used anymore. The key is
+// kept, so that the redirects it created can still be traced to it. The response will be:
+//
+//	{
+//	  "error": null,
+//	  "api_key": {"id": "0123456789ab", "name": "ci", ..., "revoked_at": "..."}
+//	}
+//
+// Keys loaded from API_KEYS_FILE can't be revoked through the API.
+func RevokeAPIKey(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	replyError := func(status int, err string) { replyAPIKeyError(w, status, err) }
+	w.Header().Add("Content-Type", APPLICATION_JSON)
+
+	id := ps.ByName("id")
+	ctx := r.Context()
+	key, err := records.RevokeAPIKey(ctx, id)
+	switch {
+	case errors.Is(err, records.ErrNotFound):
+		replyError(http.StatusNotFound, fmt.Sprintf("no API key found with id '%v'", id))
+	case errors.Is(err, records.ErrReadOnlyAPIKey):
+		replyError(http.StatusBadRequest, fmt.Sprintf("API key '%v' is defined in the keys file and can only be revoked there", id))
+	case err != nil:
+		replyError(storeErrorStatus(err), fmt.Sprintf("error revoking API key '%v': %v", id, err.Error()))
+	default:
+		slog.InfoContext(ctx, "revoked API key", "api_key_id", id, "revoked_by", apiKeyFromContext(ctx).ID)
+		resp, _ := json.Marshal(apiKeyReply{nil, "", &key})
+		w.Write(resp)
+	}
+}
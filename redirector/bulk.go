@@ -0,0 +1,466 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/luizcdc/redirectory/redirector/records"
+)
+
+// MAX_BULK_ITEMS is the largest number of items accepted by a bulk request, and
+// MAX_BULK_BODY_BYTES the largest body.
+const MAX_BULK_ITEMS = 1000
+const MAX_BULK_BODY_BYTES = 4 << 20
+
+// APPLICATION_NDJSON is the Content-Type of bodies holding a JSON value per line.
+const APPLICATION_NDJSON = "application/x-ndjson"
+
+// errTooManyItems is returned when a bulk request has more than MAX_BULK_ITEMS items.
+var errTooManyItems = fmt.Errorf("a bulk request can't have more than %v items", MAX_BULK_ITEMS)
+
+// decodeBulkItems decodes the items of the body of a bulk request, which is either a JSON array
+// or, if its Content-Type is application/x-ndjson, a stream of JSON values, one per line.
+func decodeBulkItems[T any](w http.ResponseWriter, r *http.Request) ([]T, error) {
+	contentType := r.Header.Get("Content-Type")
+	ndjson := strings.Contains(contentType, APPLICATION_NDJSON)
+	if !ndjson && !strings.Contains(contentType, APPLICATION_JSON) {
+		return nil, fmt.Errorf("Content-Type must be '%v' or '%v'", APPLICATION_JSON, APPLICATION_NDJSON)
+	}
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, MAX_BULK_BODY_BYTES))
+	if !ndjson {
+		if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+			return nil, fmt.Errorf("the body must be a JSON array")
+		}
+	}
+
+	items := []T{}
+	for ndjson || decoder.More() {
+		var item T
+		err := decoder.Decode(&item)
+		if ndjson && errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("error parsing item %v: %w", len(items), err)
+		}
+		if len(items) == MAX_BULK_ITEMS {
+			return nil, errTooManyItems
+		}
+		items = append(items, item)
+	}
+	if !ndjson {
+		if token, err := decoder.Token(); err != nil || token != json.Delim(']') {
+			return nil, fmt.Errorf("the body must be a JSON array")
+		}
+		if _, err := decoder.Token(); !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("the body must only have a JSON array")
+		}
+	}
+	return items, nil
+}
+
+// bulkErrorStatus returns the status code of the reply to a bulk request whose body couldn't be
+// decoded because of err.
+func bulkErrorStatus(err error) int {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) || errors.Is(err, errTooManyItems) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// bulkSetItem is an item of the body of BulkSetRedirects.
+type bulkSetItem struct {
+	// Path is the path of the redirect, a random one being chosen if it's empty.
+	Path string `json:"path"`
+	setRedirectBody
+}
+
+// bulkSetReply is the JSON reply of BulkSetRedirects.
+type bulkSetReply struct {
+	Error interface{} `json:"error"`
+	// Created is the number of redirects created.
+	Created int                `json:"created"`
+	Items   []setRedirectReply `json:"items"`
+}
+
+// BulkSetRedirects creates many redirects at once. It expects either a JSON array or, with the
+// application/x-ndjson Content-Type, one JSON object per line, of up to 1000 items with the
+// following structure:
+//
+//	{
+//	  "path": "docs",
+//	  "url": "https://example.com",
+//	  "duration": 10,
+//	  "status_code": 301,
+//	  "tags": ["campaign"],
+//	  "notes": "free text"
+//	}
+//
+// where "path" is optional, a random path being chosen if it's absent, and the remaining fields
+// are the same as in SetSpecificRedirect. Existing redirects are never replaced.
+// The redirects are created in a single pipeline. With the "atomic=true" query parameter, either
+// every redirect is created or none is, with a 400 status if any item is invalid and a 409
+// status if any path already has a redirect. As a Redis cluster can't create redirects
+// atomically, "atomic=true" is replied with a 400 status there. The response will be:
+//
+//	{
+//	  "error": null,
+//	  "created": 1,
+//	  "items": [{"error": null, "path": "docs", "duration": 10, "ttl": 10, "record": {...}}, {"error": "failure message", ...}]
+//	}
+//
+// where "items" has the result of each item, in the same format as SetSpecificRedirect's reply.
+func BulkSetRedirects(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	replyError := func(status int, err string, items []setRedirectReply) {
+		w.WriteHeader(status)
+		resp, _ := json.Marshal(bulkSetReply{err, 0, items})
+		w.Write(resp)
+	}
+	w.Header().Add("Content-Type", APPLICATION_JSON)
+
+	ctx := r.Context()
+	atomic := r.URL.Query().Get("atomic") == "true"
+	items, err := decodeBulkItems[bulkSetItem](w, r)
+	if err != nil {
+		slog.DebugContext(ctx, "invalid bulk request body", "error", err)
+		replyError(bulkErrorStatus(err), err.Error(), []setRedirectReply{})
+		return
+	}
+
+	// The given paths are taken before any random path is chosen, so that they can't collide.
+	results := make([]setRedirectReply, len(items))
+	taken := make(map[string]bool, len(items))
+	valid := make([]bool, len(items))
+	for i, item := range items {
+		itemError := func(_ int, err string) { results[i].Error = err }
+		results[i].Path = item.Path
+		switch {
+		case item.Overwrite:
+			itemError(http.StatusBadRequest, "bulk requests can't overwrite redirects")
+			continue
+		case item.Path == "":
+		case !validatePath(item.Path, itemError):
+			continue
+		case taken[item.Path]:
+			itemError(http.StatusBadRequest, fmt.Sprintf("'%v' is given more than once", item.Path))
+			continue
+		}
+		record, ok := recordFromBody(ctx, item.setRedirectBody, itemError)
+		if !ok {
+			continue
+		}
+		taken[item.Path] = true
+		results[i].Duration = record.Duration
+		results[i].TTL = ttlSeconds(record.TTL())
+		results[i].Record = &record
+		valid[i] = true
+	}
+	if atomic && !all(valid) {
+		rollBack(results, valid, "not created because other items are invalid")
+		replyError(http.StatusBadRequest, "no redirect was created because some items are invalid", results)
+		return
+	}
+
+	keyed := make([]records.KeyedRecord, 0, len(items))
+	indexes := make([]int, 0, len(items))
+	for i := range items {
+		if !valid[i] {
+			continue
+		}
+		if results[i].Path == "" {
+			results[i].Path, err = randomFreePath(ctx, func(path string) bool { return taken[path] })
+			if err != nil {
+				replyError(http.StatusInternalServerError, err.Error(), []setRedirectReply{})
+				return
+			}
+			taken[results[i].Path] = true
+		}
+		keyed = append(keyed, records.KeyedRecord{Key: results[i].Path, Record: *results[i].Record})
+		indexes = append(indexes, i)
+	}
+
+	created, err := records.CreateRecords(ctx, keyed, atomic)
+	nCreated := 0
+	for j, i := range indexes {
+		switch {
+		case created[j]:
+			nCreated++
+			continue
+		case errors.Is(err, records.ErrAtomicUnsupported):
+			results[i].Error = "not created because the store can't create redirects atomically"
+		case err != nil:
+			results[i].Error = fmt.Sprintf("failure setting '%v' to '%v'", results[i].Path, results[i].Record.URL)
+		case atomic:
+			results[i].Error = conflictError(ctx, results[i].Path, "not created because other items conflict")
+		case items[i].Path == "":
+			// Another request took the random path in the meantime.
+			path, err := createRandomRedirect(ctx, keyed[j].Record)
+			if err == nil {
+				results[i].Path = path
+				nCreated++
+				continue
+			}
+			results[i].Error = fmt.Sprintf("failure setting a random path to '%v'", results[i].Record.URL)
+		default:
+			results[i].Error = conflictError(ctx, results[i].Path, fmt.Sprintf("'%v' was just set by another request", results[i].Path))
+		}
+		valid[i] = false
+	}
+	rollBack(results, valid, "")
+	slog.InfoContext(ctx, "set redirects in bulk", "items", len(items), "created", nCreated, "atomic", atomic)
+
+	reply := bulkSetReply{nil, nCreated, results}
+	switch {
+	case errors.Is(err, records.ErrAtomicUnsupported):
+		w.WriteHeader(http.StatusBadRequest)
+		reply.Error = "atomic=true isn't supported by a Redis cluster, as the paths may be on different nodes"
+	case err != nil:
+		w.WriteHeader(storeErrorStatus(err))
+		reply.Error = "failure setting the redirects"
+	case atomic && nCreated < len(keyed):
+		w.WriteHeader(http.StatusConflict)
+		reply.Error = "no redirect was created because some paths already have one"
+	}
+	resp, _ := json.Marshal(reply)
+	w.Write(resp)
+}
+
+// conflictError returns the error of an item that wasn't created because of a conflict: that its
+// path already redirects somewhere, if it does, or fallback otherwise.
+func conflictError(ctx context.Context, path string, fallback string) string {
+	current, err := records.GetRecord(ctx, path)
+	if err != nil {
+		return fallback
+	}
+	return fmt.Sprintf("'%v' already redirects to '%v'", path, current.URL)
+}
+
+// rollBack removes the records from the results of the items that weren't created, setting
+// their error to err if they don't have one yet.
+func rollBack(results []setRedirectReply, created []bool, err string) {
+	for i := range results {
+		if created[i] {
+			continue
+		}
+		if results[i].Error == nil && err != "" {
+			results[i].Error = err
+		}
+		results[i].Duration = 0
+		results[i].TTL = nil
+		results[i].Record = nil
+	}
+}
+
+// all indicates whether every value is true.
+func all(values []bool) bool {
+	for _, value := range values {
+		if !value {
+			return false
+		}
+	}
+	return true
+}
+
+// bulkDelItemReply is the result of an item of BulkDelRedirects.
+type bulkDelItemReply struct {
+	Error interface{} `json:"error"`
+	Path  string      `json:"path"`
+}
+
+// bulkDelReply is the JSON reply of BulkDelRedirects.
+type bulkDelReply struct {
+	Error interface{} `json:"error"`
+	// Deleted is the number of redirects deleted.
+	Deleted int                `json:"deleted"`
+	Items   []bulkDelItemReply `json:"items"`
+}
+
+// BulkDelRedirects deletes many redirects at once. It expects either a JSON array or, with the
+// application/x-ndjson Content-Type, one JSON string per line, of up to 1000 paths, such as:
+//
+//	["docs", "blog"]
+//
+// The redirects are deleted in a single pipeline. The response will be:
+//
+//	{
+//	  "error": null,
+//	  "deleted": 1,
+//	  "items": [{"error": null, "path": "docs"}, {"error": "no redirect found for path 'blog'", "path": "blog"}]
+//	}
+//
+// where "items" has the result of each path.
+func BulkDelRedirects(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Add("Content-Type", APPLICATION_JSON)
+
+	ctx := r.Context()
+	paths, err := decodeBulkItems[string](w, r)
+	if err != nil {
+		slog.DebugContext(ctx, "invalid bulk request body", "error", err)
+		w.WriteHeader(bulkErrorStatus(err))
+		resp, _ := json.Marshal(bulkDelReply{err.Error(), 0, []bulkDelItemReply{}})
+		w.Write(resp)
+		return
+	}
+
+	results := make([]bulkDelItemReply, len(paths))
+	keys := make([]string, 0, len(paths))
+	indexes := make([]int, 0, len(paths))
+	for i, path := range paths {
+		results[i].Path = path
+		if path == "" {
+			results[i].Error = "no redirect to delete was specified"
+			continue
+		}
+		keys = append(keys, path)
+		indexes = append(indexes, i)
+	}
+
+	deleted, err := records.DelKeys(ctx, keys)
+	nDeleted := 0
+	for j, i := range indexes {
+		switch {
+		case deleted[j]:
+			nDeleted++
+		case err != nil:
+			results[i].Error = fmt.Sprintf("error deleting redirect for path '%v'", paths[i])
+		default:
+			results[i].Error = fmt.Sprintf("no redirect found for path '%v'", paths[i])
+		}
+	}
+	slog.InfoContext(ctx, "deleted redirects in bulk", "items", len(paths), "deleted", nDeleted)
+
+	reply := bulkDelReply{nil, nDeleted, results}
+	if err != nil {
+		w.WriteHeader(storeErrorStatus(err))
+		reply.Error = "failure deleting the redirects"
+	}
+	resp, _ := json.Marshal(reply)
+	w.Write(resp)
+}
+
+// deleteFilterBody is the JSON body expected by StartDeleteJob.
+type deleteFilterBody struct {
+	Prefix        string `json:"prefix"`
+	Tag           string `json:"tag"`
+	CreatedBy     string `json:"created_by"`
+	CreatedBefore string `json:"created_before"`
+}
+
+// deleteJobReply is the JSON reply of the endpoints that manage delete jobs.
+type deleteJobReply struct {
+	Error interface{}        `json:"error"`
+	Job   *records.DeleteJob `json:"job,omitempty"`
+}
+
+// replyDeleteJobError replies with the specified status code and error message in the "error"
+// field.
+func replyDeleteJobError(w http.ResponseWriter, status int, err string) {
+	w.WriteHeader(status)
+	resp, _ := json.Marshal(deleteJobReply{Error: err})
+	w.Write(resp)
+}
+
+// StartDeleteJob starts deleting, in the background, every redirect matching a filter. It expects
+// a JSON payload in the request body with the following structure:
+//
+//	{
+//	  "prefix": "campaign-",
+//	  "tag": "campaign",
+//	  "created_by": "0123456789ab",
+//	  "created_before": "2024-06-01"
+//	}
+//
+// where the redirects must match every field given, and at least one must be: "prefix" matches
+// the start of their paths, "tag" one of their tags, "created_by" the ID of the API key that
+// created them and "created_before" their creation time, either an RFC 3339 timestamp or a date.
+// The response, with a 202 status, will be:
+//
+//	{
+//	  "error": null,
+//	  "job": {"id": "0123456789abcdef", "status": "running", "scanned": 0, "matched": 0, "deleted": 0, ...}
+//	}
+//
+// where the progress of the job can be followed with GetDeleteJob.
+func StartDeleteJob(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	replyError := func(status int, err string) { replyDeleteJobError(w, status, err) }
+	w.Header().Add("Content-Type", APPLICATION_JSON)
+
+	ctx := r.Context()
+	buffer, sizeRead, err := readJSONIntoBuffer(r, replyError)
+	if err != nil {
+		slog.DebugContext(ctx, "invalid request body", "error", err)
+		return
+	}
+	var jsonBody deleteFilterBody
+	if err := json.Unmarshal(buffer[:sizeRead], &jsonBody); err != nil {
+		slog.DebugContext(ctx, "failure parsing the request's body", "error", err)
+		replyError(http.StatusBadRequest, fmt.Sprintf("error parsing json in the request's body: %v", err.Error()))
+		return
+	}
+	filter := records.DeleteFilter{Prefix: jsonBody.Prefix, Tag: jsonBody.Tag, CreatedBy: jsonBody.CreatedBy}
+	if jsonBody.CreatedBefore != "" {
+		filter.CreatedBefore, err = parseStatsTime(jsonBody.CreatedBefore, false)
+		if err != nil {
+			replyError(http.StatusBadRequest, fmt.Sprintf("invalid 'created_before': %v", err.Error()))
+			return
+		}
+	}
+
+	job, err := records.StartDeleteJob(ctx, filter)
+	switch {
+	case errors.Is(err, records.ErrEmptyFilter):
+		replyError(http.StatusBadRequest, err.Error())
+	case err != nil:
+		replyError(storeErrorStatus(err), "failure starting the delete job")
+	default:
+		w.WriteHeader(http.StatusAccepted)
+		resp, _ := json.Marshal(deleteJobReply{nil, &job})
+		w.Write(resp)
+	}
+}
+
+// GetDeleteJob reports the progress of the delete job with the given ID, which is kept for a day
+// after it was last updated. The response will be:
+//
+//	{
+//	  "error": null,
+//	  "job": {"id": "0123456789abcdef", "status": "done", "scanned": 120, "matched": 12, "deleted": 12, ...}
+//	}
+//
+// where "status" is one of "running", "done", "canceled" and "failed", the last one with the
+// failure in the "error" field of the job.
+func GetDeleteJob(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Add("Content-Type", APPLICATION_JSON)
+	id := ps.ByName("id")
+	job, err := records.GetDeleteJob(r.Context(), id)
+	replyDeleteJob(w, id, job, err)
+}
+
+// CancelDeleteJob asks the delete job with the given ID to stop before its next batch, replying
+// the same as GetDeleteJob. The redirects it already deleted aren't restored.
+func CancelDeleteJob(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Add("Content-Type", APPLICATION_JSON)
+	id := ps.ByName("id")
+	job, err := records.CancelDeleteJob(r.Context(), id)
+	replyDeleteJob(w, id, job, err)
+}
+
+// replyDeleteJob replies with a job, or with the failure retrieving it.
+func replyDeleteJob(w http.ResponseWriter, id string, job records.DeleteJob, err error) {
+	switch {
+	case errors.Is(err, records.ErrNotFound):
+		replyDeleteJobError(w, http.StatusNotFound, fmt.Sprintf("no job found with id '%v'", id))
+	case err != nil:
+		replyDeleteJobError(w, storeErrorStatus(err), fmt.Sprintf("error getting job '%v': %v", id, err.Error()))
+	default:
+		resp, _ := json.Marshal(deleteJobReply{nil, &job})
+		w.Write(resp)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/luizcdc/redirectory/redirector/records"
+	redis_client "github.com/luizcdc/redirectory/redirector/records/redis_client_singleton"
+	"github.com/luizcdc/redirectory/redirector/records/store"
+)
+
+// decodeBulkSetReply decodes the reply of BulkSetRedirects.
+func decodeBulkSetReply(t *testing.T, body string) bulkSetReply {
+	t.Helper()
+	var reply bulkSetReply
+	if err := json.Unmarshal([]byte(body), &reply); err != nil {
+		t.Fatalf("failure decoding %v: %v", body, err)
+	}
+	return reply
+}
+
+func TestBulkSetRedirects(t *testing.T) {
+	router := newTestRouter(t)
+	doRequest(router, http.MethodPost, "/api/set/taken", `{"url": "https://example.com/taken"}`)
+
+	rec := doRequest(router, http.MethodPost, "/api/bulk/set", `[
+		{"path": "docs", "url": "https://example.com/docs", "duration": 10},
+		{"url": "https://example.com/random"},
+		{"path": "taken", "url": "https://example.com/other"},
+		{"path": "docs", "url": "https://example.com/again"},
+		{"path": "bad", "url": "relative"}
+	]`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST /api/bulk/set returned status %v: %v", rec.Code, rec.Body.String())
+	}
+	reply := decodeBulkSetReply(t, rec.Body.String())
+	if reply.Error != nil || reply.Created != 2 || len(reply.Items) != 5 {
+		t.Fatalf("POST /api/bulk/set replied %+v", reply)
+	}
+	if item := reply.Items[0]; item.Error != nil || item.Path != "docs" || item.Duration != 10 || item.Record == nil {
+		t.Errorf("the first item resulted in %+v", item)
+	}
+	if item := reply.Items[1]; item.Error != nil || len(item.Path) != RANDOM_SIZE {
+		t.Errorf("the item with a random path resulted in %+v", item)
+	}
+	for _, item := range reply.Items[2:] {
+		if item.Error == nil || item.Record != nil {
+			t.Errorf("an invalid item resulted in %+v", item)
+		}
+	}
+	if record, err := records.GetRecord(context.Background(), reply.Items[1].Path); err != nil || record.URL != "https://example.com/random" {
+		t.Errorf("GetRecord(%v) = %+v, %v", reply.Items[1].Path, record, err)
+	}
+	if record, _ := records.GetRecord(context.Background(), "taken"); record.URL != "https://example.com/taken" {
+		t.Errorf("an existing redirect was replaced by %v", record.URL)
+	}
+
+	req := newTestRequest(http.MethodPost, "/api/bulk/set", "{\"path\": \"ndjson1\", \"url\": \"https://example.com/1\"}\n{\"url\": \"https://example.com/2\"}\n")
+	req.Header.Set("Content-Type", APPLICATION_NDJSON)
+	rec = serve(router, req)
+	if reply := decodeBulkSetReply(t, rec.Body.String()); rec.Code != http.StatusOK || reply.Created != 2 {
+		t.Errorf("POST /api/bulk/set with NDJSON returned status %v: %v", rec.Code, rec.Body.String())
+	}
+
+	for _, invalid := range []string{`{"url": "https://example.com"}`, `[{"url": "https://example.com"}`, `[{"url": "https://example.com"}] garbage`} {
+		if rec := doRequest(router, http.MethodPost, "/api/bulk/set", invalid); rec.Code != http.StatusBadRequest {
+			t.Errorf("POST /api/bulk/set with %q returned status %v, want %v", invalid, rec.Code, http.StatusBadRequest)
+		}
+	}
+	tooMany := "[" + strings.Repeat(`{"url": "https://example.com"},`, MAX_BULK_ITEMS) + `{"url": "https://example.com"}]`
+	if rec := doRequest(router, http.MethodPost, "/api/bulk/set", tooMany); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("POST /api/bulk/set with too many items returned status %v, want %v", rec.Code, http.StatusRequestEntityTooLarge)
+	}
+}
+
+func TestBulkSetRedirectsAtomic(t *testing.T) {
+	router := newTestRouter(t)
+	doRequest(router, http.MethodPost, "/api/set/taken", `{"url": "https://example.com/taken"}`)
+
+	rec := doRequest(router, http.MethodPost, "/api/bulk/set?atomic=true", `[
+		{"path": "free", "url": "https://example.com/free"},
+		{"path": "taken", "url": "https://example.com/other"}
+	]`)
+	reply := decodeBulkSetReply(t, rec.Body.String())
+	if rec.Code != http.StatusConflict || reply.Created != 0 || reply.Items[0].Error == nil || reply.Items[0].Record != nil {
+		t.Errorf("POST /api/bulk/set?atomic=true with a conflict returned status %v: %v", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(reply.Items[1].Error.(string), "already redirects to 'https://example.com/taken'") {
+		t.Errorf("the conflicting item resulted in %+v", reply.Items[1])
+	}
+
+	rec = doRequest(router, http.MethodPost, "/api/bulk/set?atomic=true", `[
+		{"path": "free", "url": "https://example.com/free"},
+		{"path": "bad", "url": "https://example.com/bad"}
+	]`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("POST /api/bulk/set?atomic=true with an invalid item returned status %v: %v", rec.Code, rec.Body.String())
+	}
+	if _, err := records.GetRecord(context.Background(), "free"); err == nil {
+		t.Error("an atomic bulk request that failed created a redirect")
+	}
+
+	rec = doRequest(router, http.MethodPost, "/api/bulk/set?atomic=true", `[
+		{"path": "free", "url": "https://example.com/free"},
+		{"url": "https://example.com/random"}
+	]`)
+	if reply := decodeBulkSetReply(t, rec.Body.String()); rec.Code != http.StatusOK || reply.Created != 2 {
+		t.Errorf("POST /api/bulk/set?atomic=true returned status %v: %v", rec.Code, rec.Body.String())
+	}
+}
+
+func TestBulkSetRedirectsAtomicOnCluster(t *testing.T) {
+	router := newTestRouter(t)
+	server := miniredis.RunT(t)
+	t.Setenv("REDIS_MODE", redis_client.CLUSTER)
+	t.Setenv("REDIS_ADDRS", server.Addr())
+	t.Setenv("REDIS_DB", "0")
+	t.Cleanup(func() { redis_client.CloseClient() })
+	records.UseStore(store.NewRedisStore())
+
+	body := `[{"path": "docs", "url": "https://example.com/docs"}, {"path": "blog", "url": "https://example.com/blog"}]`
+	rec := doRequest(router, http.MethodPost, "/api/bulk/set?atomic=true", body)
+	if reply := decodeBulkSetReply(t, rec.Body.String()); rec.Code != http.StatusBadRequest || reply.Created != 0 {
+		t.Errorf("POST /api/bulk/set?atomic=true on a cluster returned status %v: %v", rec.Code, rec.Body.String())
+	}
+	if server.Exists("TEST:docs") || server.Exists("TEST:blog") {
+		t.Error("an atomic bulk request rejected on a cluster created a redirect")
+	}
+	rec = doRequest(router, http.MethodPost, "/api/bulk/set", body)
+	if reply := decodeBulkSetReply(t, rec.Body.String()); rec.Code != http.StatusOK || reply.Created != 2 {
+		t.Errorf("POST /api/bulk/set on a cluster returned status %v: %v", rec.Code, rec.Body.String())
+	}
+}
+
+func TestBulkDelRedirects(t *testing.T) {
+	router := newTestRouter(t)
+	doRequest(router, http.MethodPost, "/api/set/docs", `{"url": "https://example.com/docs"}`)
+	doRequest(router, http.MethodPost, "/api/set/blog", `{"url": "https://example.com/blog"}`)
+
+	rec := doRequest(router, http.MethodPost, "/api/bulk/del", `["docs", "missing", ""]`)
+	var reply bulkDelReply
+	if err := json.Unmarshal(rec.Body.Bytes(), &reply); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("POST /api/bulk/del returned status %v: %v", rec.Code, rec.Body.String())
+	}
+	if reply.Error != nil || reply.Deleted != 1 || len(reply.Items) != 3 || reply.Items[0].Error != nil || reply.Items[1].Error == nil || reply.Items[2].Error == nil {
+		t.Errorf("POST /api/bulk/del replied %+v", reply)
+	}
+	if _, err := records.GetRecord(context.Background(), "docs"); !errors.Is(err, records.ErrNotFound) {
+		t.Errorf("GetRecord(docs) returned error %v after it was deleted, want ErrNotFound", err)
+	}
+	if _, err := records.GetRecord(context.Background(), "blog"); err != nil {
+		t.Errorf("GetRecord(blog) returned error %v, but it wasn't deleted", err)
+	}
+}
+
+func TestDeleteJobRoutes(t *testing.T) {
+	router := newTestRouter(t)
+	doRequest(router, http.MethodPost, "/api/set/camp-a", `{"url": "https://example.com/a", "tags": ["campaign"]}`)
+	doRequest(router, http.MethodPost, "/api/set/camp-b", `{"url": "https://example.com/b"}`)
+	doRequest(router, http.MethodPost, "/api/set/other", `{"url": "https://example.com/other", "tags": ["campaign"]}`)
+
+	if rec := doRequest(router, http.MethodPost, "/api/bulk/del/filter", `{}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("POST /api/bulk/del/filter without a filter returned status %v, want %v", rec.Code, http.StatusBadRequest)
+	}
+	if rec := doRequest(router, http.MethodPost, "/api/bulk/del/filter", `{"created_before": "yesterday"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("POST /api/bulk/del/filter with an invalid date returned status %v, want %v", rec.Code, http.StatusBadRequest)
+	}
+
+	rec := doRequest(router, http.MethodPost, "/api/bulk/del/filter", `{"prefix": "camp-", "tag": "campaign", "created_by": "env"}`)
+	var reply deleteJobReply
+	if err := json.Unmarshal(rec.Body.Bytes(), &reply); err != nil || rec.Code != http.StatusAccepted || reply.Job == nil {
+		t.Fatalf("POST /api/bulk/del/filter returned status %v: %v", rec.Code, rec.Body.String())
+	}
+	path := "/api/jobs/" + reply.Job.ID
+	deadline := time.Now().Add(5 * time.Second)
+	for reply.Job.Status == records.JOB_RUNNING && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		rec = doRequest(router, http.MethodGet, path, "")
+		reply = deleteJobReply{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &reply); err != nil || reply.Job == nil {
+			t.Fatalf("GET %v returned status %v: %v", path, rec.Code, rec.Body.String())
+		}
+	}
+	if job := reply.Job; job.Status != records.JOB_DONE || job.Scanned != 2 || job.Deleted != 1 {
+		t.Fatalf("GET %v replied %+v, want a done job that deleted camp-a", path, job)
+	}
+	for path, deleted := range map[string]bool{"camp-a": true, "camp-b": false, "other": false} {
+		if _, err := records.GetRecord(context.Background(), path); errors.Is(err, records.ErrNotFound) != deleted {
+			t.Errorf("GetRecord(%v) returned error %v after the job", path, err)
+		}
+	}
+
+	if rec := doRequest(router, http.MethodDelete, path, ""); rec.Code != http.StatusOK {
+		t.Errorf("DELETE %v on a done job returned status %v: %v", path, rec.Code, rec.Body.String())
+	}
+	if rec := doRequest(router, http.MethodGet, "/api/jobs/missing", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("GET /api/jobs/missing returned status %v, want %v", rec.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,603 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/luizcdc/redirectory/redirector/records"
+)
+
+// CLI_DEFAULT_URL is the server the command-line client talks to if none is configured.
+const CLI_DEFAULT_URL = "http://localhost:8080"
+
+// CLI_REQUEST_TIMEOUT is how long the command-line client waits for the reply of a request,
+// other than the exports and imports, which last as long as the redirects take to transfer.
+const CLI_REQUEST_TIMEOUT = 30 * time.Second
+
+// The output formats of the command-line client.
+const OUTPUT_TABLE = "table"
+const OUTPUT_JSON = "json"
+
+// cliConfig is the configuration of the command-line client, read from a JSON file such as
+//
+//	{"url": "https://example.com", "api_key": "rdk_..."}
+//
+// and overridden by the REDIRECTORY_URL and REDIRECTORY_API_KEY environment variables.
+type cliConfig struct {
+	URL    string `json:"url"`
+	APIKey string `json:"api_key"`
+}
+
+// loadCLIConfig reads the configuration from path or, if it's empty, from REDIRECTORY_CONFIG or
+// else redirectory/config.json in the user's configuration directory, which may not exist.
+func loadCLIConfig(path string) (cliConfig, error) {
+	config := cliConfig{URL: CLI_DEFAULT_URL}
+	required := path != ""
+	if path == "" {
+		path = os.Getenv("REDIRECTORY_CONFIG")
+		required = path != ""
+	}
+	if path == "" {
+		if dir, err := os.UserConfigDir(); err == nil {
+			path = filepath.Join(dir, "redirectory", "config.json")
+		}
+	}
+	if path != "" {
+		contents, err := os.ReadFile(path)
+		switch {
+		case errors.Is(err, os.ErrNotExist) && !required:
+		case err != nil:
+			return cliConfig{}, fmt.Errorf("failure reading the config file: %w", err)
+		default:
+			if err := json.Unmarshal(contents, &config); err != nil {
+				return cliConfig{}, fmt.Errorf("failure parsing the config file '%v': %w", path, err)
+			}
+		}
+	}
+	if value := os.Getenv("REDIRECTORY_URL"); value != "" {
+		config.URL = value
+	}
+	if value := os.Getenv("REDIRECTORY_API_KEY"); value != "" {
+		config.APIKey = value
+	}
+	return config, nil
+}
+
+// cliClient sends the requests of the command-line client to the API and prints their replies.
+type cliClient struct {
+	ctx    context.Context
+	config cliConfig
+	output string
+	http   *http.Client
+	stdin  io.Reader
+	stdout io.Writer
+}
+
+// apiError is a reply of the API with an error status.
+type apiError struct {
+	Status  int
+	Message string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("the server replied %v: %v", e.Status, e.Message)
+}
+
+// request sends a request to the API route at path, returning the reply if its status isn't an
+// error.
+func (c *cliClient) request(ctx context.Context, method string, path string, query url.Values, body io.Reader, contentType string) (*http.Response, error) {
+	target := strings.TrimSuffix(c.config.URL, "/") + API_ROOT + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.config.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.config.APIKey)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		defer resp.Body.Close()
+		return nil, replyError(resp)
+	}
+	return resp, nil
+}
+
+// replyError returns the error of a reply, from its "error" field if it's JSON.
+func replyError(resp *http.Response) error {
+	contents, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	var reply struct {
+		Error interface{} `json:"error"`
+	}
+	if json.Unmarshal(contents, &reply) == nil && reply.Error != nil {
+		return &apiError{resp.StatusCode, fmt.Sprint(reply.Error)}
+	}
+	message := strings.TrimSpace(string(contents))
+	if message == "" {
+		message = http.StatusText(resp.StatusCode)
+	}
+	return &apiError{resp.StatusCode, message}
+}
+
+// requestJSON sends body, if it isn't nil, as JSON to the API route at path, decoding the reply
+// into reply, within CLI_REQUEST_TIMEOUT.
+func (c *cliClient) requestJSON(method string, path string, query url.Values, body interface{}, reply interface{}) error {
+	var reader io.Reader
+	contentType := ""
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(encoded)
+		contentType = APPLICATION_JSON
+	}
+	ctx, cancel := context.WithTimeout(c.ctx, CLI_REQUEST_TIMEOUT)
+	defer cancel()
+	resp, err := c.request(ctx, method, path, query, reader, contentType)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(reply); err != nil {
+		return fmt.Errorf("failure decoding the reply: %w", err)
+	}
+	return nil
+}
+
+// print prints reply as indented JSON or, for the table output, through table.
+func (c *cliClient) print(reply interface{}, table func(w io.Writer)) error {
+	if c.output == OUTPUT_JSON {
+		encoder := json.NewEncoder(c.stdout)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(reply)
+	}
+	w := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	table(w)
+	return w.Flush()
+}
+
+// stringsFlag is a flag that can be given many times, collecting its values.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// cliCommand is a subcommand of the command-line client. Its setup defines its flags, returning
+// the function that runs it with the remaining arguments.
+type cliCommand struct {
+	usage   string
+	summary string
+	setup   func(flags *flag.FlagSet) func(c *cliClient, args []string) error
+}
+
+// CLI_COMMANDS are the subcommands of the command-line client, by name.
+var CLI_COMMANDS = map[string]cliCommand{
+	"create": {"create [flags] <url>", "create a redirect, at a random path unless -path is given", setupCreate},
+	"get":    {"get <path>", "show a redirect", setupGet},
+	"update": {"update [flags] <path>", "change the given fields of a redirect", setupUpdate},
+	"delete": {"delete <path>...", "delete redirects", setupDelete},
+	"list":   {"list [flags]", "list the paths that have redirects", setupList},
+	"stats":  {"stats [flags] <path>", "show the analytics of a redirect", setupStats},
+	"export": {"export [flags]", "export every redirect as JSON lines or CSV", setupExport},
+	"import": {"import [flags] [file]", "import redirects exported by export, from the standard input if no file is given", setupImport},
+}
+
+// isCLICommand indicates whether the arguments of the program run the command-line client
+// instead of the server.
+func isCLICommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	_, ok := CLI_COMMANDS[args[0]]
+	return ok || args[0] == "help"
+}
+
+// cliUsage prints the usage of the command-line client.
+func cliUsage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: %v <command> [flags] [arguments]\n\nCommands:\n", filepath.Base(os.Args[0]))
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	names := make([]string, 0, len(CLI_COMMANDS))
+	for name := range CLI_COMMANDS {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		fmt.Fprintf(tw, "  %v\t%v\n", CLI_COMMANDS[name].usage, CLI_COMMANDS[name].summary)
+	}
+	tw.Flush()
+	fmt.Fprintln(w, "\nEvery command accepts -config, -server and -output (table or json). The server and API key are read from")
+	fmt.Fprintln(w, "the config file and the REDIRECTORY_URL and REDIRECTORY_API_KEY environment variables.")
+	fmt.Fprintln(w, "Run a command with -h for its flags. Without a command, the server is started.")
+}
+
+// runCLI runs the subcommand of the command-line client in args, returning the exit code.
+func runCLI(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	command, ok := CLI_COMMANDS[args[0]]
+	if !ok {
+		cliUsage(stdout)
+		return 0
+	}
+	flags := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		summary := strings.ToUpper(command.summary[:1]) + command.summary[1:]
+		fmt.Fprintf(stderr, "Usage: %v %v\n\n%v.\n\nFlags:\n", filepath.Base(os.Args[0]), command.usage, summary)
+		flags.PrintDefaults()
+	}
+	configPath := flags.String("config", "", "the config file (default $REDIRECTORY_CONFIG or redirectory/config.json in the user's config directory)")
+	server := flags.String("server", "", "the URL of the server, overriding the config")
+	output := flags.String("output", OUTPUT_TABLE, "the output format, table or json")
+	run := command.setup(flags)
+	if err := flags.Parse(args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if *output != OUTPUT_TABLE && *output != OUTPUT_JSON {
+		fmt.Fprintf(stderr, "-output must be '%v' or '%v'\n", OUTPUT_TABLE, OUTPUT_JSON)
+		return 2
+	}
+
+	config, err := loadCLIConfig(*configPath)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	if *server != "" {
+		config.URL = *server
+	}
+	client := &cliClient{ctx, config, *output, &http.Client{}, stdin, stdout}
+	if err := run(client, flags.Args()); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// errUsage is returned by the commands given the wrong arguments.
+var errUsage = errors.New("invalid arguments, run the command with -h for its usage")
+
+// errNoRecord is returned by the commands that set a redirect if the reply doesn't have it.
+var errNoRecord = errors.New("the reply of the server doesn't have the redirect")
+
+// printRedirect prints a redirect as a table of its fields.
+func (c *cliClient) printRedirect(reply interface{}, path string, ttl *int64, hits *int64, record records.Record) error {
+	return c.print(reply, func(w io.Writer) {
+		fmt.Fprintf(w, "path\t%v\n", path)
+		fmt.Fprintf(w, "url\t%v\n", record.URL)
+		fmt.Fprintf(w, "status code\t%v\n", record.StatusCode)
+		fmt.Fprintf(w, "ttl\t%v\n", formatTTL(ttl))
+		if hits != nil {
+			fmt.Fprintf(w, "hits\t%v\n", *hits)
+		}
+		fmt.Fprintf(w, "tags\t%v\n", strings.Join(record.Tags, ", "))
+		fmt.Fprintf(w, "notes\t%v\n", record.Notes)
+		fmt.Fprintf(w, "created by\t%v\n", record.CreatedBy)
+		fmt.Fprintf(w, "created at\t%v\n", formatTime(record.CreatedAt))
+	})
+}
+
+// formatTTL formats a time to live in seconds, nil meaning that the redirect never expires.
+func formatTTL(ttl *int64) string {
+	if ttl == nil {
+		return "never expires"
+	}
+	return (time.Duration(*ttl) * time.Second).String()
+}
+
+// formatTime formats a time in RFC 3339, or as "unknown" if it's the zero value.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "unknown"
+	}
+	return t.Format(time.RFC3339)
+}
+
+func setupCreate(flags *flag.FlagSet) func(c *cliClient, args []string) error {
+	path := flags.String("path", "", "the path of the redirect, random if unset")
+	duration := flags.Uint("duration", 0, "how many seconds the redirect lasts (default the server's)")
+	statusCode := flags.Int("status", 0, "the status code of the redirect (default 307)")
+	var tags stringsFlag
+	flags.Var(&tags, "tag", "a tag of the redirect, can be given many times")
+	notes := flags.String("notes", "", "free text about the redirect")
+	overwrite := flags.Bool("overwrite", false, "replace the redirect of -path if it has one")
+	return func(c *cliClient, args []string) error {
+		if len(args) != 1 {
+			return errUsage
+		}
+		body := setRedirectBody{args[0], *duration, *statusCode, tags, *notes, *overwrite}
+		route := "set"
+		if *path != "" {
+			route += "/" + url.PathEscape(*path)
+		}
+		var reply setRedirectReply
+		if err := c.requestJSON(http.MethodPost, route, nil, body, &reply); err != nil {
+			return err
+		}
+		if reply.Record == nil {
+			return errNoRecord
+		}
+		return c.printRedirect(reply, reply.Path, reply.TTL, nil, *reply.Record)
+	}
+}
+
+func setupGet(flags *flag.FlagSet) func(c *cliClient, args []string) error {
+	return func(c *cliClient, args []string) error {
+		if len(args) != 1 {
+			return errUsage
+		}
+		var reply getRedirectReply
+		if err := c.requestJSON(http.MethodGet, "get/"+url.PathEscape(args[0]), nil, nil, &reply); err != nil {
+			return err
+		}
+		return c.printRedirect(reply, reply.Path, reply.TTL, &reply.Hits, reply.Record)
+	}
+}
+
+func setupUpdate(flags *flag.FlagSet) func(c *cliClient, args []string) error {
+	target := flags.String("url", "", "the new URL of the redirect")
+	duration := flags.Uint("duration", 0, "how many seconds the redirect lasts from now on")
+	statusCode := flags.Int("status", 0, "the new status code of the redirect")
+	var tags stringsFlag
+	flags.Var(&tags, "tag", "a tag of the redirect, replacing the current ones, can be given many times")
+	clearTags := flags.Bool("clear-tags", false, "remove every tag of the redirect")
+	notes := flags.String("notes", "", "the new notes of the redirect")
+	return func(c *cliClient, args []string) error {
+		if len(args) != 1 {
+			return errUsage
+		}
+		// Only the flags that were given are sent, so that the other fields are left unchanged.
+		var body updateRedirectBody
+		flags.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "url":
+				body.Url = target
+			case "duration":
+				body.Duration = duration
+			case "status":
+				body.StatusCode = statusCode
+			case "tag":
+				body.Tags = (*[]string)(&tags)
+			case "clear-tags":
+				if *clearTags {
+					body.Tags = &[]string{}
+				}
+			case "notes":
+				body.Notes = notes
+			}
+		})
+		var reply setRedirectReply
+		if err := c.requestJSON(http.MethodPatch, "set/"+url.PathEscape(args[0]), nil, body, &reply); err != nil {
+			return err
+		}
+		if reply.Record == nil {
+			return errNoRecord
+		}
+		return c.printRedirect(reply, reply.Path, reply.TTL, nil, *reply.Record)
+	}
+}
+
+func setupDelete(flags *flag.FlagSet) func(c *cliClient, args []string) error {
+	return func(c *cliClient, args []string) error {
+		if len(args) == 0 {
+			return errUsage
+		}
+		var reply bulkDelReply
+		if err := c.requestJSON(http.MethodPost, "bulk/del", nil, args, &reply); err != nil {
+			return err
+		}
+		err := c.print(reply, func(w io.Writer) {
+			fmt.Fprintln(w, "PATH\tRESULT")
+			for _, item := range reply.Items {
+				result := "deleted"
+				if item.Error != nil {
+					result = fmt.Sprint(item.Error)
+				}
+				fmt.Fprintf(w, "%v\t%v\n", item.Path, result)
+			}
+		})
+		if err == nil && reply.Deleted < len(args) {
+			err = fmt.Errorf("%v of %v redirects weren't deleted", len(args)-reply.Deleted, len(args))
+		}
+		return err
+	}
+}
+
+func setupList(flags *flag.FlagSet) func(c *cliClient, args []string) error {
+	prefix := flags.String("prefix", "", "list only the paths that start with it")
+	match := flags.String("match", "", "list only the paths that match this glob pattern after the prefix")
+	count := flags.Int("count", DEFAULT_LIST_COUNT, "how many paths to examine per request")
+	details := flags.Bool("details", false, "show the URL and time to live of each redirect")
+	return func(c *cliClient, args []string) error {
+		if len(args) != 0 {
+			return errUsage
+		}
+		query := url.Values{"count": {strconv.Itoa(*count)}, "prefix": {*prefix}, "match": {*match}, "details": {strconv.FormatBool(*details)}}
+		// Every page is fetched, the reply merging their items.
+		listed := listRedirectsReply{nil, "0", []listedRedirect{}}
+		for {
+			var page listRedirectsReply
+			if err := c.requestJSON(http.MethodGet, "list", query, nil, &page); err != nil {
+				return err
+			}
+			listed.Items = append(listed.Items, page.Items...)
+			if page.Cursor == "0" {
+				break
+			}
+			query.Set("cursor", page.Cursor)
+		}
+		return c.print(listed, func(w io.Writer) {
+			if !*details {
+				for _, item := range listed.Items {
+					fmt.Fprintln(w, item.Path)
+				}
+				return
+			}
+			fmt.Fprintln(w, "PATH\tURL\tTTL")
+			for _, item := range listed.Items {
+				fmt.Fprintf(w, "%v\t%v\t%v\n", item.Path, item.Url, formatTTL(item.TTL))
+			}
+		})
+	}
+}
+
+func setupStats(flags *flag.FlagSet) func(c *cliClient, args []string) error {
+	from := flags.String("from", "", "the start of the period, an RFC 3339 timestamp or a date (default a week ago, or a day ago hourly)")
+	to := flags.String("to", "", "the end of the period (default now)")
+	granularity := flags.String("granularity", "", "the size of the buckets of the series, day or hour (default day)")
+	return func(c *cliClient, args []string) error {
+		if len(args) != 1 {
+			return errUsage
+		}
+		query := url.Values{}
+		for name, value := range map[string]string{"from": *from, "to": *to, "granularity": *granularity} {
+			if value != "" {
+				query.Set(name, value)
+			}
+		}
+		var reply hitStatsReply
+		if err := c.requestJSON(http.MethodGet, "stats/"+url.PathEscape(args[0]), query, nil, &reply); err != nil {
+			return err
+		}
+		return c.print(reply, func(w io.Writer) {
+			fmt.Fprintf(w, "path\t%v\n", reply.Path)
+			fmt.Fprintf(w, "period\t%v to %v\n", formatTime(reply.From), formatTime(reply.To))
+			fmt.Fprintf(w, "total\t%v\n", reply.Total)
+			fmt.Fprintln(w, "\nTIME\tHITS")
+			for _, bucket := range reply.Series {
+				fmt.Fprintf(w, "%v\t%v\n", formatTime(bucket.Time), bucket.Hits)
+			}
+			for _, breakdown := range []struct {
+				name   string
+				counts map[string]int64
+			}{{"REFERRER", reply.Referrers}, {"AGENT", reply.Agents}, {"COUNTRY", reply.Countries}} {
+				fmt.Fprintf(w, "\n%v\tHITS\n", breakdown.name)
+				keys := make([]string, 0, len(breakdown.counts))
+				for key := range breakdown.counts {
+					keys = append(keys, key)
+				}
+				slices.SortFunc(keys, func(a, b string) int {
+					return cmp.Compare(breakdown.counts[b], breakdown.counts[a])
+				})
+				for _, key := range keys {
+					fmt.Fprintf(w, "%v\t%v\n", key, breakdown.counts[key])
+				}
+			}
+		})
+	}
+}
+
+func setupExport(flags *flag.FlagSet) func(c *cliClient, args []string) error {
+	format := flags.String("format", FORMAT_JSONL, "the format of the export, jsonl or csv")
+	prefix := flags.String("prefix", "", "export only the paths that start with it")
+	file := flags.String("file", "", "the file written, the standard output if unset")
+	return func(c *cliClient, args []string) error {
+		if len(args) != 0 {
+			return errUsage
+		}
+		query := url.Values{"format": {*format}}
+		if *prefix != "" {
+			query.Set("prefix", *prefix)
+		}
+		resp, err := c.request(c.ctx, http.MethodGet, "export", query, nil, "")
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		out := c.stdout
+		if *file != "" {
+			f, err := os.Create(*file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			out = f
+		}
+		// The server aborts the reply if the export fails, so that it can't be mistaken for a
+		// complete one.
+		if _, err := io.Copy(out, resp.Body); err != nil {
+			return fmt.Errorf("the export is incomplete: %w", err)
+		}
+		return nil
+	}
+}
+
+func setupImport(flags *flag.FlagSet) func(c *cliClient, args []string) error {
+	format := flags.String("format", "", "the format of the import, jsonl or csv (default csv for .csv files, jsonl otherwise)")
+	onConflict := flags.String("on-conflict", ON_CONFLICT_SKIP, "what to do with the paths that have a redirect: skip, overwrite or fail")
+	return func(c *cliClient, args []string) error {
+		if len(args) > 1 {
+			return errUsage
+		}
+		in := c.stdin
+		if len(args) == 1 && args[0] != "-" {
+			f, err := os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			in = f
+			if *format == "" && strings.EqualFold(filepath.Ext(args[0]), ".csv") {
+				*format = FORMAT_CSV
+			}
+		}
+		if *format == "" {
+			*format = FORMAT_JSONL
+		}
+
+		query := url.Values{"format": {*format}, "on_conflict": {*onConflict}}
+		contentType := APPLICATION_NDJSON
+		if *format == FORMAT_CSV {
+			contentType = TEXT_CSV
+		}
+		var reply importReply
+		resp, err := c.request(c.ctx, http.MethodPost, "import", query, in, contentType)
+		var apiErr *apiError
+		if errors.As(err, &apiErr) && apiErr.Status == http.StatusConflict {
+			return fmt.Errorf("%w (run with -on-conflict skip or overwrite to import the other redirects)", err)
+		} else if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+			return fmt.Errorf("failure decoding the reply: %w", err)
+		}
+		return c.print(reply, func(w io.Writer) {
+			fmt.Fprintf(w, "created\t%v\n", reply.Created)
+			fmt.Fprintf(w, "overwritten\t%v\n", reply.Overwritten)
+			fmt.Fprintf(w, "skipped\t%v\n", reply.Skipped)
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/luizcdc/redirectory/redirector/records"
+)
+
+// newTestCLI starts a test server, returning a function that runs the command-line client
+// against it with args, returning its exit code and output.
+func newTestCLI(t *testing.T) func(stdin string, args ...string) (int, string, string) {
+	t.Helper()
+	server := httptest.NewServer(newTestRouter(t))
+	t.Cleanup(server.Close)
+	t.Setenv("REDIRECTORY_CONFIG", "")
+	t.Setenv("REDIRECTORY_URL", server.URL)
+	t.Setenv("REDIRECTORY_API_KEY", API_KEY)
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	return func(stdin string, args ...string) (int, string, string) {
+		var stdout, stderr bytes.Buffer
+		code := runCLI(context.Background(), args, strings.NewReader(stdin), &stdout, &stderr)
+		return code, stdout.String(), stderr.String()
+	}
+}
+
+func TestCLIRedirects(t *testing.T) {
+	run := newTestCLI(t)
+
+	code, out, errOut := run("", "create", "-path", "docs", "-tag", "a", "-tag", "b", "-output", "json", "https://example.com/docs")
+	var created setRedirectReply
+	if err := json.Unmarshal([]byte(out), &created); code != 0 || err != nil || created.Path != "docs" || len(created.Record.Tags) != 2 {
+		t.Fatalf("create exited with %v: %v%v", code, out, errOut)
+	}
+	if code, out, _ := run("", "get", "docs"); code != 0 || !strings.Contains(out, "https://example.com/docs") || !strings.Contains(out, "a, b") {
+		t.Errorf("get exited with %v: %v", code, out)
+	}
+	if code, _, errOut := run("", "get", "nope"); code != 1 || !strings.Contains(errOut, "404") {
+		t.Errorf("get of a missing redirect exited with %v: %v", code, errOut)
+	}
+
+	if code, out, errOut := run("", "update", "-notes", "changed", "docs"); code != 0 || !strings.Contains(out, "changed") {
+		t.Errorf("update exited with %v: %v%v", code, out, errOut)
+	}
+	record, _ := records.GetRecord(context.Background(), "docs")
+	if record.Notes != "changed" || record.URL != "https://example.com/docs" || len(record.Tags) != 2 {
+		t.Errorf("update changed the redirect to %+v, want only its notes changed", record)
+	}
+
+	if code, out, _ := run("", "create", "-path", "blog", "-duration", "3600", "https://example.com/blog"); code != 0 || !strings.Contains(out, "1h0m0s") && !strings.Contains(out, "59m59s") {
+		t.Errorf("create with a duration exited with %v: %v", code, out)
+	}
+	code, out, _ = run("", "list", "-count", "1", "-details")
+	if code != 0 || !strings.Contains(out, "PATH") || !strings.Contains(out, "docs") || !strings.Contains(out, "https://example.com/blog") {
+		t.Errorf("list exited with %v: %v", code, out)
+	}
+	if code, out, _ := run("", "stats", "docs"); code != 0 || !strings.Contains(out, "total") {
+		t.Errorf("stats exited with %v: %v", code, out)
+	}
+
+	if code, out, _ := run("", "delete", "docs", "nope"); code != 1 || !strings.Contains(out, "deleted") || !strings.Contains(out, "no redirect found") {
+		t.Errorf("delete of a missing redirect exited with %v: %v", code, out)
+	}
+	if _, err := records.GetRecord(context.Background(), "docs"); err == nil {
+		t.Errorf("delete didn't delete the redirect")
+	}
+	if code, _, _ := run("", "create"); code != 1 {
+		t.Errorf("create without a URL exited with %v, want 1", code)
+	}
+}
+
+func TestCLIExportImport(t *testing.T) {
+	run := newTestCLI(t)
+	run("", "create", "-path", "docs", "https://example.com/docs")
+	file := filepath.Join(t.TempDir(), "redirects.csv")
+	if code, _, errOut := run("", "export", "-format", "csv", "-file", file); code != 0 {
+		t.Fatalf("export exited with %v: %v", code, errOut)
+	}
+	if contents, _ := os.ReadFile(file); !strings.Contains(string(contents), "https://example.com/docs") {
+		t.Errorf("the export doesn't have the redirect: %s", contents)
+	}
+
+	if code, _, errOut := run("", "import", "-on-conflict", "fail", file); code != 1 || !strings.Contains(errOut, "409") {
+		t.Errorf("import of existing redirects with -on-conflict fail exited with %v: %v", code, errOut)
+	}
+	if code, out, errOut := run("", "import", "-on-conflict", "overwrite", file); code != 0 || !strings.Contains(out, "overwritten  1") {
+		t.Errorf("import exited with %v: %v%v", code, out, errOut)
+	}
+	code, out, errOut := run(`{"path": "blog", "url": "https://example.com/blog"}`, "import", "-output", "json")
+	if code != 0 || !strings.Contains(out, `"created": 1`) {
+		t.Errorf("import from the standard input exited with %v: %v%v", code, out, errOut)
+	}
+}
+
+func TestCLISetWithoutRecord(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"error": null, "path": "docs", "duration": 0}`))
+	}))
+	defer server.Close()
+	t.Setenv("REDIRECTORY_CONFIG", "")
+	t.Setenv("REDIRECTORY_URL", server.URL)
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	var stdout, stderr bytes.Buffer
+	for _, args := range [][]string{{"create", "https://example.com"}, {"update", "-notes", "changed", "docs"}} {
+		stderr.Reset()
+		if code := runCLI(context.Background(), args, strings.NewReader(""), &stdout, &stderr); code != 1 || !strings.Contains(stderr.String(), errNoRecord.Error()) {
+			t.Errorf("%v of a reply without a record exited with %v: %v", args[0], code, stderr.String())
+		}
+	}
+}
+
+func TestLoadCLIConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(path, []byte(`{"url": "https://example.com", "api_key": "from-file"}`), 0o600)
+	t.Setenv("REDIRECTORY_URL", "")
+	t.Setenv("REDIRECTORY_API_KEY", "from-env")
+
+	config, err := loadCLIConfig(path)
+	if err != nil || config.URL != "https://example.com" || config.APIKey != "from-env" {
+		t.Errorf("loadCLIConfig = %+v, %v, want the URL of the file and the API key of the environment", config, err)
+	}
+	if _, err := loadCLIConfig(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Errorf("loadCLIConfig of a missing file given explicitly returned no error")
+	}
+}
@@ -0,0 +1,528 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/luizcdc/redirectory/redirector/records"
+)
+
+// The formats of the exports, and of the imports.
+const FORMAT_CSV = "csv"
+const FORMAT_JSONL = "jsonl"
+
+// TEXT_CSV is the Content-Type of the CSV exports.
+const TEXT_CSV = "text/csv"
+
+// EXPORT_BATCH_SIZE is how many paths an export reads from the store at once, and
+// IMPORT_BATCH_SIZE how many redirects an import decodes, validates and writes at once.
+const EXPORT_BATCH_SIZE = 500
+const IMPORT_BATCH_SIZE = 500
+
+// MAX_IMPORT_BODY_BYTES is the largest body accepted by ImportRedirects.
+const MAX_IMPORT_BODY_BYTES = 64 << 20
+
+// The policies of ImportRedirects for the paths that already have a redirect.
+const ON_CONFLICT_SKIP = "skip"
+const ON_CONFLICT_OVERWRITE = "overwrite"
+const ON_CONFLICT_FAIL = "fail"
+
+// CSV_COLUMNS are the columns of the CSV exports, in order. Imports accept them in any order,
+// and only require "path" and "url".
+var CSV_COLUMNS = []string{"path", "url", "ttl", "duration", "status_code", "tags", "notes", "created_by", "created_at"}
+
+// exportedRedirect is a redirect in an export, and in an import.
+type exportedRedirect struct {
+	Path string `json:"path"`
+	Url  string `json:"url"`
+	// TTL is the remaining time to live in seconds, absent if the redirect never expires.
+	TTL        *int64    `json:"ttl,omitempty"`
+	Duration   uint      `json:"duration"`
+	StatusCode int       `json:"status_code"`
+	Tags       []string  `json:"tags"`
+	Notes      string    `json:"notes"`
+	CreatedBy  string    `json:"created_by"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// csvRow returns the redirect as a row of CSV_COLUMNS, its tags being a JSON array.
+func (item exportedRedirect) csvRow() []string {
+	ttl := ""
+	if item.TTL != nil {
+		ttl = strconv.FormatInt(*item.TTL, 10)
+	}
+	tags, _ := json.Marshal(item.Tags)
+	createdAt := ""
+	if !item.CreatedAt.IsZero() {
+		createdAt = item.CreatedAt.Format(time.RFC3339Nano)
+	}
+	return []string{item.Path, item.Url, ttl, strconv.FormatUint(uint64(item.Duration), 10),
+		strconv.Itoa(item.StatusCode), string(tags), item.Notes, item.CreatedBy, createdAt}
+}
+
+// parseCSVRow parses a CSV row whose columns are named by header, the inverse of csvRow. Empty
+// cells are taken as absent.
+func parseCSVRow(header []string, row []string) (exportedRedirect, error) {
+	var item exportedRedirect
+	var err error
+	for i, column := range header {
+		value := row[i]
+		if value == "" {
+			continue
+		}
+		switch column {
+		case "path":
+			item.Path = value
+		case "url":
+			item.Url = value
+		case "ttl":
+			var ttl int64
+			ttl, err = strconv.ParseInt(value, 10, 64)
+			item.TTL = &ttl
+		case "duration":
+			var duration uint64
+			duration, err = strconv.ParseUint(value, 10, 0)
+			item.Duration = uint(duration)
+		case "status_code":
+			item.StatusCode, err = strconv.Atoi(value)
+		case "tags":
+			err = json.Unmarshal([]byte(value), &item.Tags)
+		case "notes":
+			item.Notes = value
+		case "created_by":
+			item.CreatedBy = value
+		case "created_at":
+			item.CreatedAt, err = time.Parse(time.RFC3339, value)
+		}
+		if err != nil {
+			return exportedRedirect{}, fmt.Errorf("invalid '%v': %w", column, err)
+		}
+	}
+	return item, nil
+}
+
+// parseFormat returns the format given in the "format" query parameter, FORMAT_JSONL by default.
+func parseFormat(r *http.Request) (string, error) {
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "":
+		return FORMAT_JSONL, nil
+	case FORMAT_CSV, FORMAT_JSONL:
+		return format, nil
+	}
+	return "", fmt.Errorf("format must be '%v' or '%v'", FORMAT_CSV, FORMAT_JSONL)
+}
+
+// replyJSONError replies with the specified status code and error message in the "error"
+// field.
+func replyJSONError(w http.ResponseWriter, status int, err string) {
+	w.Header().Set("Content-Type", APPLICATION_JSON)
+	w.WriteHeader(status)
+	resp, _ := json.Marshal(struct {
+		Error interface{} `json:"error"`
+	}{err})
+	w.Write(resp)
+}
+
+// exportEncoder writes the redirects of an export, buffering them until they're flushed.
+type exportEncoder interface {
+	Encode(item exportedRedirect) error
+	Flush() error
+}
+
+type csvEncoder struct {
+	writer *csv.Writer
+}
+
+func (e csvEncoder) Encode(item exportedRedirect) error {
+	return e.writer.Write(item.csvRow())
+}
+
+func (e csvEncoder) Flush() error {
+	e.writer.Flush()
+	return e.writer.Error()
+}
+
+type jsonlEncoder struct {
+	buffer  *bufio.Writer
+	encoder *json.Encoder
+}
+
+func (e jsonlEncoder) Encode(item exportedRedirect) error {
+	return e.encoder.Encode(item)
+}
+
+func (e jsonlEncoder) Flush() error {
+	return e.buffer.Flush()
+}
+
+// newExportEncoder returns the encoder of an export in format to w, writing the header of the
+// CSV exports.
+func newExportEncoder(format string, w io.Writer) (exportEncoder, error) {
+	if format == FORMAT_CSV {
+		writer := csv.NewWriter(w)
+		return csvEncoder{writer}, writer.Write(CSV_COLUMNS)
+	}
+	buffer := bufio.NewWriter(w)
+	encoder := json.NewEncoder(buffer)
+	encoder.SetEscapeHTML(false)
+	return jsonlEncoder{buffer, encoder}, nil
+}
+
+// exportRedirect reads the redirect of a path from the store.
+func exportRedirect(ctx context.Context, path string) (exportedRedirect, error) {
+	record, err := records.GetRecord(ctx, path)
+	if err != nil {
+		return exportedRedirect{}, err
+	}
+	ttl, err := records.GetRecordTTL(ctx, path)
+	if err != nil {
+		return exportedRedirect{}, err
+	}
+	return exportedRedirect{
+		Path:       path,
+		Url:        record.URL,
+		TTL:        ttlSeconds(ttl),
+		Duration:   record.Duration,
+		StatusCode: record.StatusCode,
+		Tags:       record.Tags,
+		Notes:      record.Notes,
+		CreatedBy:  record.CreatedBy,
+		CreatedAt:  record.CreatedAt,
+	}, nil
+}
+
+// ExportRedirects streams every redirect of the environment, without blocking the store. It
+// accepts the following query parameters, both optional:
+//   - format: "jsonl" (the default), for a JSON object per line, or "csv", for a header row
+//     followed by a row per redirect, with the tags as a JSON array.
+//   - prefix: exports only the paths that start with it.
+//
+// Each redirect has the following fields, which are the columns of the CSV:
+//
+//	{
+//	  "path": "docs",
+//	  "url": "https://example.com",
+//	  "ttl": 3600,
+//	  "duration": 86400,
+//	  "status_code": 307,
+//	  "tags": ["campaign"],
+//	  "notes": "free text",
+//	  "created_by": "0123456789ab",
+//	  "created_at": "2024-06-01T12:00:00Z"
+//	}
+//
+// where "ttl" is the remaining time to live in seconds, absent if the redirect never expires.
+// If the store fails once the export has started, the reply is aborted, so that a truncated
+// export can't be mistaken for a complete one.
+func ExportRedirects(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	format, err := parseFormat(r)
+	if err != nil {
+		replyJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	ctx := r.Context()
+	prefix := r.URL.Query().Get("prefix")
+	keys, next, err := records.ListKeys(ctx, 0, prefix, "", EXPORT_BATCH_SIZE)
+	if err != nil {
+		replyJSONError(w, storeErrorStatus(err), fmt.Sprintf("error listing redirects: %v", err.Error()))
+		return
+	}
+
+	contentType := APPLICATION_NDJSON
+	if format == FORMAT_CSV {
+		contentType = TEXT_CSV
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"redirects.%v\"", format))
+	abort := func(exported int, err error) {
+		slog.ErrorContext(ctx, "failure exporting the redirects, aborting the reply", "exported", exported, "error", err)
+		panic(http.ErrAbortHandler)
+	}
+	encoder, err := newExportEncoder(format, w)
+	if err != nil {
+		abort(0, err)
+	}
+	controller := http.NewResponseController(w)
+
+	exported := 0
+	for {
+		for _, key := range keys {
+			item, err := exportRedirect(ctx, key)
+			if errors.Is(err, records.ErrNotFound) {
+				// The redirect expired or was deleted since it was listed.
+				continue
+			} else if err != nil {
+				abort(exported, err)
+			}
+			if err := encoder.Encode(item); err != nil {
+				abort(exported, err)
+			}
+			exported++
+		}
+		if err := encoder.Flush(); err != nil {
+			abort(exported, err)
+		}
+		controller.Flush()
+		if next == 0 {
+			break
+		}
+		keys, next, err = records.ListKeys(ctx, next, prefix, "", EXPORT_BATCH_SIZE)
+		if err != nil {
+			abort(exported, err)
+		}
+	}
+	slog.InfoContext(ctx, "exported redirects", "format", format, "prefix", prefix, "exported", exported)
+}
+
+// newImportDecoder returns a function decoding the next redirect of an import in format, which
+// returns io.EOF once there are none left. The header of the CSV imports is read and checked
+// first.
+func newImportDecoder(format string, body io.Reader) (func() (exportedRedirect, error), error) {
+	if format == FORMAT_JSONL {
+		decoder := json.NewDecoder(body)
+		return func() (exportedRedirect, error) {
+			var item exportedRedirect
+			err := decoder.Decode(&item)
+			return item, err
+		}, nil
+	}
+
+	reader := csv.NewReader(body)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("error reading the CSV header: %w", err)
+	}
+	for _, column := range header {
+		if !slices.Contains(CSV_COLUMNS, column) {
+			return nil, fmt.Errorf("unknown CSV column '%v', must be one of %v", column, strings.Join(CSV_COLUMNS, ", "))
+		}
+	}
+	if !slices.Contains(header, "path") || !slices.Contains(header, "url") {
+		return nil, fmt.Errorf("the CSV columns must include 'path' and 'url'")
+	}
+	return func() (exportedRedirect, error) {
+		row, err := reader.Read()
+		if err != nil {
+			return exportedRedirect{}, err
+		}
+		return parseCSVRow(header, row)
+	}, nil
+}
+
+// decodeImportBatch decodes and validates up to IMPORT_BATCH_SIZE redirects of an import, the
+// first of them being item number first. It returns the redirects, fewer than IMPORT_BATCH_SIZE
+// if the import ends with them, or the error of the first invalid item.
+func decodeImportBatch(ctx context.Context, decode func() (exportedRedirect, error), first int) ([]records.KeyedRecord, error) {
+	batch := []records.KeyedRecord{}
+	taken := map[string]bool{}
+	for len(batch) < IMPORT_BATCH_SIZE {
+		item, err := decode()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("error parsing item %v: %w", first+len(batch), err)
+		}
+		record, err := importedRecord(ctx, item)
+		if err == nil && taken[item.Path] {
+			err = fmt.Errorf("'%v' is given more than once", item.Path)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("item %v is invalid: %w", first+len(batch), err)
+		}
+		taken[item.Path] = true
+		batch = append(batch, records.KeyedRecord{Key: item.Path, Record: record})
+	}
+	return batch, nil
+}
+
+// importedRecord validates an imported redirect, returning its record. The redirect keeps its
+// remaining time to live and its metadata, its creator being the API key importing it if it has
+// none.
+func importedRecord(ctx context.Context, item exportedRedirect) (records.Record, error) {
+	var err error
+	replyError := func(_ int, msg string) { err = errors.New(msg) }
+	if !validatePath(item.Path, replyError) {
+		return records.Record{}, err
+	}
+	targetUrl, ok := validateTarget(item.Url, item.StatusCode, replyError)
+	if !ok {
+		return records.Record{}, err
+	}
+	if item.TTL != nil && *item.TTL <= 0 {
+		return records.Record{}, fmt.Errorf("the ttl must be positive, or absent if the redirect never expires")
+	}
+
+	record := records.NewRecord(targetUrl, 0)
+	record.Duration = item.Duration
+	if item.TTL != nil {
+		record.ExpiresAt = record.CreatedAt.Add(time.Duration(*item.TTL) * time.Second)
+	}
+	if item.StatusCode != 0 {
+		record.StatusCode = item.StatusCode
+	}
+	if item.Tags != nil {
+		record.Tags = item.Tags
+	}
+	record.Notes = item.Notes
+	record.CreatedBy = item.CreatedBy
+	if record.CreatedBy == "" {
+		record.CreatedBy = apiKeyFromContext(ctx).ID
+	}
+	if !item.CreatedAt.IsZero() {
+		record.CreatedAt = item.CreatedAt.UTC()
+	}
+	return record, nil
+}
+
+// importReply is the JSON reply of ImportRedirects.
+type importReply struct {
+	Error interface{} `json:"error"`
+	// Created is the number of redirects created, Overwritten the number that replaced existing
+	// ones and Skipped the number that weren't imported because their paths have a redirect.
+	Created     int `json:"created"`
+	Overwritten int `json:"overwritten"`
+	Skipped     int `json:"skipped"`
+	// Conflicts lists the paths that already have a redirect, if the import failed because of
+	// them.
+	Conflicts []string `json:"conflicts"`
+}
+
+// ImportRedirects restores the redirects of an export, possibly from another environment. It
+// expects a body in the format of ExportRedirects, and accepts the following query parameters,
+// both optional:
+//   - format: "jsonl" (the default) or "csv".
+//   - on_conflict: what to do with the paths that already have a redirect: "skip" them (the
+//     default), "overwrite" their redirects, or "fail", stopping the import at the batch of the
+//     first one.
+//
+// The redirects keep their remaining time to live and their metadata. The body is streamed:
+// the items are decoded, validated and written in batches of IMPORT_BATCH_SIZE, so an invalid
+// item, which is replied with a 400 status, stops the import after the batches before its own
+// were written. A path given twice in the same batch is invalid, while one given again in a
+// later batch is handled as a conflict. The response will be:
+//
+//	{
+//	  "error": null,
+//	  "created": 10,
+//	  "overwritten": 0,
+//	  "skipped": 2,
+//	  "conflicts": []
+//	}
+//
+// When the import stops, the response has the numbers of redirects imported by then. With
+// "fail", the conflicting paths of the batch it stopped at are replied in "conflicts", with a
+// 409 status, as are those taken by other requests while the batch was written.
+func ImportRedirects(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	reply := importReply{Conflicts: []string{}}
+	replyError := func(status int, err string) {
+		w.WriteHeader(status)
+		reply.Error = err
+		resp, _ := json.Marshal(reply)
+		w.Write(resp)
+	}
+	w.Header().Add("Content-Type", APPLICATION_JSON)
+
+	format, err := parseFormat(r)
+	if err != nil {
+		replyError(http.StatusBadRequest, err.Error())
+		return
+	}
+	onConflict := r.URL.Query().Get("on_conflict")
+	if onConflict == "" {
+		onConflict = ON_CONFLICT_SKIP
+	}
+	if onConflict != ON_CONFLICT_SKIP && onConflict != ON_CONFLICT_OVERWRITE && onConflict != ON_CONFLICT_FAIL {
+		replyError(http.StatusBadRequest, fmt.Sprintf("on_conflict must be '%v', '%v' or '%v'", ON_CONFLICT_SKIP, ON_CONFLICT_OVERWRITE, ON_CONFLICT_FAIL))
+		return
+	}
+
+	ctx := r.Context()
+	decode, err := newImportDecoder(format, http.MaxBytesReader(w, r.Body, MAX_IMPORT_BODY_BYTES))
+	if err != nil {
+		slog.DebugContext(ctx, "invalid import body", "error", err)
+		replyError(bulkErrorStatus(err), err.Error())
+		return
+	}
+
+	items := 0
+	for {
+		batch, err := decodeImportBatch(ctx, decode, items+1)
+		if err != nil {
+			slog.DebugContext(ctx, "invalid import body", "error", err)
+			replyError(bulkErrorStatus(err), fmt.Sprintf("the import stopped because %v", err.Error()))
+			return
+		}
+		items += len(batch)
+
+		if onConflict == ON_CONFLICT_FAIL {
+			for _, k := range batch {
+				_, err := records.GetRecordTTL(ctx, k.Key)
+				if err == nil {
+					reply.Conflicts = append(reply.Conflicts, k.Key)
+				} else if !errors.Is(err, records.ErrNotFound) {
+					replyError(storeErrorStatus(err), "failure checking the paths of the redirects")
+					return
+				}
+			}
+			if len(reply.Conflicts) > 0 {
+				replyError(http.StatusConflict, "the import stopped because some paths already have a redirect")
+				return
+			}
+		}
+
+		created, err := records.CreateRecords(ctx, batch, false)
+		conflicts := []records.KeyedRecord{}
+		for j, ok := range created {
+			if ok {
+				reply.Created++
+			} else if err == nil {
+				conflicts = append(conflicts, batch[j])
+			}
+		}
+		if err != nil {
+			replyError(storeErrorStatus(err), "failure importing the redirects")
+			return
+		}
+
+		switch onConflict {
+		case ON_CONFLICT_SKIP:
+			reply.Skipped += len(conflicts)
+		case ON_CONFLICT_OVERWRITE:
+			for _, k := range conflicts {
+				if err := records.SetRecord(ctx, k.Key, k.Record); err != nil {
+					replyError(storeErrorStatus(err), fmt.Sprintf("failure overwriting the redirect of '%v'", k.Key))
+					return
+				}
+				reply.Overwritten++
+			}
+		case ON_CONFLICT_FAIL:
+			for _, k := range conflicts {
+				reply.Conflicts = append(reply.Conflicts, k.Key)
+			}
+			if len(conflicts) > 0 {
+				replyError(http.StatusConflict, "the import stopped because other requests set some of its paths")
+				return
+			}
+		}
+		if len(batch) < IMPORT_BATCH_SIZE {
+			break
+		}
+	}
+	slog.InfoContext(ctx, "imported redirects", "format", format, "on_conflict", onConflict, "items", items,
+		"created", reply.Created, "overwritten", reply.Overwritten, "skipped", reply.Skipped)
+	resp, _ := json.Marshal(reply)
+	w.Write(resp)
+}
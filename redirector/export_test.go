@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/luizcdc/redirectory/redirector/records"
+)
+
+// exportRedirects exports every redirect in format, failing the test if the export fails.
+func exportRedirects(t *testing.T, router http.Handler, format string) string {
+	t.Helper()
+	rec := doRequest(router, http.MethodGet, "/api/export?format="+format, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /api/export?format=%v returned status %v: %v", format, rec.Code, rec.Body.String())
+	}
+	return rec.Body.String()
+}
+
+// importRedirects imports body, returning the status and the reply.
+func importRedirects(t *testing.T, router http.Handler, query string, body string) (int, importReply) {
+	t.Helper()
+	rec := doRequest(router, http.MethodPost, "/api/import?"+query, body)
+	var reply importReply
+	if err := json.Unmarshal(rec.Body.Bytes(), &reply); err != nil {
+		t.Fatalf("failure decoding %v: %v", rec.Body.String(), err)
+	}
+	return rec.Code, reply
+}
+
+func TestExportImportRedirects(t *testing.T) {
+	for _, format := range []string{FORMAT_CSV, FORMAT_JSONL} {
+		t.Run(format, func(t *testing.T) {
+			router := newTestRouter(t)
+			doRequest(router, http.MethodPost, "/api/set/docs", `{"url": "https://example.com/docs?a=1&b=2", "duration": 3600, "status_code": 301, "tags": ["a", "b,c"], "notes": "line\nbreak"}`)
+			// The redirects set through the API always expire.
+			records.SetRecord(context.Background(), "forever", records.NewRecord("https://example.com/forever", 0))
+			original, _ := records.GetRecord(context.Background(), "docs")
+
+			export := exportRedirects(t, router, format)
+			if format == FORMAT_CSV && !strings.HasPrefix(export, strings.Join(CSV_COLUMNS, ",")+"\n") {
+				t.Errorf("the CSV export doesn't start with its header: %v", export)
+			}
+
+			router = newTestRouter(t)
+			status, reply := importRedirects(t, router, "format="+format, export)
+			if status != http.StatusOK || reply.Error != nil || reply.Created != 2 {
+				t.Fatalf("POST /api/import returned status %v: %+v", status, reply)
+			}
+			imported, err := records.GetRecord(context.Background(), "docs")
+			if err != nil || imported.URL != original.URL || imported.StatusCode != 301 || imported.Duration != 3600 ||
+				len(imported.Tags) != 2 || imported.Tags[1] != "b,c" || imported.Notes != original.Notes ||
+				imported.CreatedBy != ENV_API_KEY_ID || !imported.CreatedAt.Equal(original.CreatedAt) {
+				t.Errorf("GetRecord(docs) = %+v, %v after the import, want %+v", imported, err, original)
+			}
+			if ttl, err := records.GetRecordTTL(context.Background(), "docs"); err != nil || ttl <= 59*time.Minute || ttl > time.Hour {
+				t.Errorf("GetRecordTTL(docs) = %v, %v after the import, want about an hour", ttl, err)
+			}
+			if ttl, err := records.GetRecordTTL(context.Background(), "forever"); err != nil || ttl != 0 {
+				t.Errorf("GetRecordTTL(forever) = %v, %v after the import, want no expiration", ttl, err)
+			}
+		})
+	}
+}
+
+func TestImportRedirectsConflicts(t *testing.T) {
+	router := newTestRouter(t)
+	doRequest(router, http.MethodPost, "/api/set/docs", `{"url": "https://example.com/old"}`)
+	body := `{"path": "docs", "url": "https://example.com/new"}` + "\n" + `{"path": "blog", "url": "https://example.com/blog"}` + "\n"
+
+	status, reply := importRedirects(t, router, "on_conflict=fail", body)
+	if status != http.StatusConflict || reply.Created != 0 || len(reply.Conflicts) != 1 || reply.Conflicts[0] != "docs" {
+		t.Errorf("POST /api/import?on_conflict=fail returned status %v: %+v", status, reply)
+	}
+	if _, err := records.GetRecord(context.Background(), "blog"); err == nil {
+		t.Errorf("a failed import created a redirect")
+	}
+
+	status, reply = importRedirects(t, router, "", body)
+	if status != http.StatusOK || reply.Created != 1 || reply.Skipped != 1 {
+		t.Errorf("POST /api/import returned status %v: %+v", status, reply)
+	}
+	if record, _ := records.GetRecord(context.Background(), "docs"); record.URL != "https://example.com/old" {
+		t.Errorf("skipping a conflict replaced the redirect with %v", record.URL)
+	}
+
+	status, reply = importRedirects(t, router, "on_conflict=overwrite", body)
+	if status != http.StatusOK || reply.Created != 0 || reply.Overwritten != 2 {
+		t.Errorf("POST /api/import?on_conflict=overwrite returned status %v: %+v", status, reply)
+	}
+	if record, _ := records.GetRecord(context.Background(), "docs"); record.URL != "https://example.com/new" {
+		t.Errorf("overwriting a conflict left the redirect to %v", record.URL)
+	}
+
+	invalid := `{"path": "news", "url": "https://example.com/news"}` + "\n" + `{"path": "rel", "url": "relative"}` + "\n"
+	if status, _ := importRedirects(t, router, "", invalid); status != http.StatusBadRequest {
+		t.Errorf("POST /api/import with an invalid item returned status %v, want %v", status, http.StatusBadRequest)
+	}
+	if _, err := records.GetRecord(context.Background(), "news"); err == nil {
+		t.Errorf("an import with an invalid item created a redirect")
+	}
+	if status, _ := importRedirects(t, router, "on_conflict=merge", body); status != http.StatusBadRequest {
+		t.Errorf("POST /api/import?on_conflict=merge returned status %v, want %v", status, http.StatusBadRequest)
+	}
+}
+
+func TestImportRedirectsBatches(t *testing.T) {
+	router := newTestRouter(t)
+	var body strings.Builder
+	for i := range IMPORT_BATCH_SIZE {
+		fmt.Fprintf(&body, `{"path": "path%v", "url": "https://example.com/%v"}`+"\n", i, i)
+	}
+	body.WriteString(`{"path": "rel", "url": "relative"}` + "\n")
+
+	// The batch before the invalid item was written when the import stopped.
+	status, reply := importRedirects(t, router, "", body.String())
+	if status != http.StatusBadRequest || reply.Created != IMPORT_BATCH_SIZE {
+		t.Errorf("POST /api/import with an invalid item in its second batch returned status %v: %+v", status, reply)
+	}
+	if _, err := records.GetRecord(context.Background(), fmt.Sprintf("path%v", IMPORT_BATCH_SIZE-1)); err != nil {
+		t.Errorf("the last redirect of the first batch wasn't imported: %v", err)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/luizcdc/redirectory/redirector/records"
+)
+
+// The statuses of the health endpoints and of each dependency checked by Readyz.
+const (
+	STATUS_OK       = "ok"
+	STATUS_DISABLED = "disabled"
+	STATUS_ERROR    = "error"
+)
+
+var errConfigNotLoaded = errors.New("the configuration wasn't loaded")
+
+// dependencyStatus is the outcome of the check of a dependency by Readyz.
+type dependencyStatus struct {
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// healthReply is the JSON reply of the health endpoints.
+type healthReply struct {
+	Status string                      `json:"status"`
+	Checks map[string]dependencyStatus `json:"checks,omitempty"`
+}
+
+// Healthz replies that the process is alive, without checking any dependency:
+//
+//	{
+//	  "status": "ok"
+//	}
+func Healthz(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Add("Content-Type", APPLICATION_JSON)
+	w.Header().Set("Cache-Control", "no-store")
+	resp, _ := json.Marshal(healthReply{Status: STATUS_OK})
+	w.Write(resp)
+}
+
+// Readyz replies whether the server is ready to serve requests, checking that the configuration
+// was loaded, that the local cache was initialized (it is reported as "disabled" otherwise,
+// which doesn't make the server unready) and that the store is reachable. The response will be:
+//
+//	{
+//	  "status": "ok",
+//	  "checks": {
+//	    "config": {"status": "ok", "latency_ms": 0},
+//	    "cache": {"status": "disabled", "latency_ms": 0},
+//	    "store": {"status": "ok", "latency_ms": 0.42}
+//	  }
+//	}
+//
+// If any check fails, the status code is 503, the status is "error" and the failed checks have
+// an "error" field.
+func Readyz(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Add("Content-Type", APPLICATION_JSON)
+	w.Header().Set("Cache-Control", "no-store")
+
+	checks := map[string]dependencyStatus{
+		"config": checkDependency(func() (string, error) {
+			if intToString == nil {
+				return STATUS_ERROR, errConfigNotLoaded
+			}
+			return STATUS_OK, nil
+		}),
+		"cache": checkDependency(func() (string, error) {
+			if !records.CacheInitialized() {
+				return STATUS_DISABLED, nil
+			}
+			return STATUS_OK, nil
+		}),
+		"store": checkDependency(func() (string, error) {
+			if err := records.Ping(r.Context()); err != nil {
+				return STATUS_ERROR, err
+			}
+			return STATUS_OK, nil
+		}),
+	}
+
+	reply := healthReply{Status: STATUS_OK, Checks: checks}
+	for _, check := range checks {
+		if check.Status == STATUS_ERROR {
+			reply.Status = STATUS_ERROR
+			w.WriteHeader(http.StatusServiceUnavailable)
+			break
+		}
+	}
+	resp, _ := json.Marshal(reply)
+	w.Write(resp)
+}
+
+// checkDependency runs the check of a dependency, timing it.
+func checkDependency(check func() (string, error)) dependencyStatus {
+	start := time.Now()
+	status, err := check()
+	result := dependencyStatus{
+		Status:    status,
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
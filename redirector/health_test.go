@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/luizcdc/redirectory/redirector/records"
+	"github.com/luizcdc/redirectory/redirector/records/store"
+)
+
+// unreachableStore is a Store that can't be reached.
+type unreachableStore struct {
+	*store.MemoryStore
+}
+
+func (s unreachableStore) Ping(ctx context.Context) error {
+	return errors.New("connection refused")
+}
+
+func TestHealthz(t *testing.T) {
+	router := newTestRouter(t)
+
+	// The health endpoints don't require authentication.
+	rec := serve(router, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != `{"status":"ok"}` {
+		t.Errorf("GET /healthz returned status %v: %v", rec.Code, rec.Body.String())
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	router := newTestRouter(t)
+
+	rec := serve(router, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	var reply healthReply
+	if err := json.Unmarshal(rec.Body.Bytes(), &reply); err != nil {
+		t.Fatalf("GET /readyz replied %v: %v", rec.Body.String(), err)
+	}
+	if rec.Code != http.StatusOK || reply.Status != STATUS_OK {
+		t.Errorf("GET /readyz returned status %v: %v", rec.Code, rec.Body.String())
+	}
+	want := map[string]string{"config": STATUS_OK, "cache": STATUS_DISABLED, "store": STATUS_OK}
+	for name, status := range want {
+		if reply.Checks[name].Status != status {
+			t.Errorf("the %v check is %+v, want status %v", name, reply.Checks[name], status)
+		}
+	}
+
+	records.UseStore(unreachableStore{store.NewMemoryStore(0)})
+	rec = serve(router, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	reply = healthReply{}
+	json.Unmarshal(rec.Body.Bytes(), &reply)
+	if rec.Code != http.StatusServiceUnavailable || reply.Status != STATUS_ERROR || reply.Checks["store"].Error == "" {
+		t.Errorf("GET /readyz with an unreachable store returned status %v: %v", rec.Code, rec.Body.String())
+	}
+}
+
+func TestReservedPaths(t *testing.T) {
+	router := newTestRouter(t)
+
+	for _, path := range []string{LIVENESS_PATH, READINESS_PATH} {
+		rec := doRequest(router, http.MethodPost, "/api/set/"+path, `{"url": "https://example.com"}`)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("POST /api/set/%v returned status %v, want %v", path, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// The formats of the access log.
+const (
+	// COMMON is the Common Log Format of Apache.
+	COMMON = "common"
+	// COMBINED is the Combined Log Format of Apache, which adds the referer and user agent.
+	COMBINED = "combined"
+	// JSON logs a JSON object per request, which adds the latency and request ID.
+	JSON = "json"
+)
+
+const (
+	DEFAULT_ACCESS_LOG_MAX_SIZE_MB  = 100
+	DEFAULT_ACCESS_LOG_MAX_BACKUPS  = 7
+	DEFAULT_ACCESS_LOG_MAX_AGE_DAYS = 30
+)
+
+// CLF_TIME_LAYOUT is the layout of the timestamps in the common and combined formats.
+const CLF_TIME_LAYOUT = "02/Jan/2006:15:04:05 -0700"
+
+// AccessLogger writes a line to the access log for every request it serves. The credentials of
+// the requests, such as the Authorization header, are never logged.
+type AccessLogger struct {
+	mu     sync.Mutex
+	out    io.Writer
+	format string
+	// clientIPHeader is the header set by the proxy in front of the server with the address of
+	// the client (as its first entry), or "" to log the address of the connection.
+	clientIPHeader string
+}
+
+// NewAccessLogger creates an AccessLogger writing to out in the given format.
+func NewAccessLogger(out io.Writer, format string, clientIPHeader string) (*AccessLogger, error) {
+	switch format {
+	case COMMON, COMBINED, JSON:
+	default:
+		return nil, fmt.Errorf("unknown access log format '%v'", format)
+	}
+	return &AccessLogger{out: out, format: format, clientIPHeader: clientIPHeader}, nil
+}
+
+// OpenAccessLog creates the AccessLogger configured by the environment variables:
+//   - ACCESS_LOG_FORMAT: "common", "combined" (the default) or "json".
+//   - ACCESS_LOG_FILE: the file the access log is written to, rotated once it reaches
+//     ACCESS_LOG_MAX_SIZE_MB, keeping ACCESS_LOG_MAX_BACKUPS old files for at most
+//     ACCESS_LOG_MAX_AGE_DAYS. The access log is written to the standard output if it is unset.
+//   - CLIENT_IP_HEADER: the header holding the address of the client, such as X-Forwarded-For,
+//     if the server is behind a proxy.
+func OpenAccessLog() (*AccessLogger, error) {
+	format := os.Getenv("ACCESS_LOG_FORMAT")
+	if format == "" {
+		format = COMBINED
+	}
+	var out io.Writer = os.Stdout
+	if os.Getenv("ACCESS_LOG_FILE") != "" {
+		out = &lumberjack.Logger{
+			Filename:   os.Getenv("ACCESS_LOG_FILE"),
+			MaxSize:    envInt("ACCESS_LOG_MAX_SIZE_MB", DEFAULT_ACCESS_LOG_MAX_SIZE_MB),
+			MaxBackups: envInt("ACCESS_LOG_MAX_BACKUPS", DEFAULT_ACCESS_LOG_MAX_BACKUPS),
+			MaxAge:     envInt("ACCESS_LOG_MAX_AGE_DAYS", DEFAULT_ACCESS_LOG_MAX_AGE_DAYS),
+		}
+	}
+	return NewAccessLogger(out, format, os.Getenv("CLIENT_IP_HEADER"))
+}
+
+// envInt reads a positive integer from an environment variable, returning fallback if it is
+// unset or invalid.
+func envInt(name string, fallback int) int {
+	if os.Getenv(name) == "" {
+		return fallback
+	}
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || value <= 0 {
+		slog.Error("invalid setting of the access log, using the default", "variable", name, "default", fallback)
+		return fallback
+	}
+	return value
+}
+
+// Close closes the file the access log is written to, if any.
+func (l *AccessLogger) Close() error {
+	if closer, ok := l.out.(io.Closer); ok && l.out != os.Stdout {
+		return closer.Close()
+	}
+	return nil
+}
+
+// accessRecorder is an http.ResponseWriter that remembers the status code and size of the reply.
+type accessRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (w *accessRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+// Unwrap allows http.ResponseController to flush the underlying http.ResponseWriter.
+func (w *accessRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// accessEntry is a line of the access log.
+type accessEntry struct {
+	Time      time.Time `json:"time"`
+	ClientIP  string    `json:"client_ip"`
+	Method    string    `json:"method"`
+	URI       string    `json:"uri"`
+	Proto     string    `json:"proto"`
+	Status    int       `json:"status"`
+	Bytes     int       `json:"bytes"`
+	LatencyMs float64   `json:"latency_ms"`
+	Referer   string    `json:"referer"`
+	UserAgent string    `json:"user_agent"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+// Middleware logs every request served by next once it is replied, including the ones whose
+// handler panics, such as with http.ErrAbortHandler to abort the reply, the panic being passed on
+// once the request is logged.
+func (l *AccessLogger) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &accessRecorder{ResponseWriter: w}
+		defer func() {
+			recovered := recover()
+			switch {
+			case recorder.status != 0:
+			case recovered != nil:
+				recorder.status = http.StatusInternalServerError
+			default:
+				recorder.status = http.StatusOK
+			}
+			l.write(accessEntry{
+				Time:      start,
+				ClientIP:  ClientIP(r, l.clientIPHeader),
+				Method:    r.Method,
+				URI:       r.RequestURI,
+				Proto:     r.Proto,
+				Status:    recorder.status,
+				Bytes:     recorder.bytes,
+				LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+				Referer:   r.Referer(),
+				UserAgent: r.UserAgent(),
+				RequestID: RequestID(r.Context()),
+			})
+			if recovered != nil {
+				panic(recovered)
+			}
+		}()
+		next.ServeHTTP(recorder, r)
+	})
+}
+
+// ClientIP returns the address of the client that sent the request: the first entry of header,
+// set by the proxy in front of the server, or the address of the connection if header is "" or
+// the request doesn't have it.
+func ClientIP(r *http.Request, header string) string {
+	if header != "" {
+		if forwarded, _, _ := strings.Cut(r.Header.Get(header), ","); strings.TrimSpace(forwarded) != "" {
+			return strings.TrimSpace(forwarded)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// write writes an entry to the access log in its format.
+func (l *AccessLogger) write(entry accessEntry) {
+	var line []byte
+	if l.format == JSON {
+		line, _ = json.Marshal(entry)
+	} else {
+		// The user is always "-", as the only credential is the API key.
+		line = fmt.Appendf(nil, "%s - - [%s] %q %d %s",
+			entry.ClientIP, entry.Time.Format(CLF_TIME_LAYOUT),
+			entry.Method+" "+entry.URI+" "+entry.Proto, entry.Status, clfBytes(entry.Bytes))
+		if l.format == COMBINED {
+			line = fmt.Appendf(line, " %q %q", clfString(entry.Referer), clfString(entry.UserAgent))
+		}
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.out.Write(line); err != nil {
+		slog.Error("failure writing the access log", "error", err)
+	}
+}
+
+// clfBytes formats the size of a reply as in the common and combined formats, where empty
+// replies are "-".
+func clfBytes(bytes int) string {
+	if bytes == 0 {
+		return "-"
+	}
+	return strconv.Itoa(bytes)
+}
+
+// clfString formats a header as in the combined format, where missing headers are "-".
+func clfString(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
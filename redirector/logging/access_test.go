@@ -0,0 +1,93 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// serveLogged serves a request with an API key through an AccessLogger in the given format,
+// returning what it logged.
+func serveLogged(t *testing.T, format string) string {
+	t.Helper()
+	var buffer bytes.Buffer
+	logger, err := NewAccessLogger(&buffer, format, "X-Forwarded-For")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := Middleware(logger.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("short and stout"))
+	})))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/get/abcd?details=true", nil)
+	req.RemoteAddr = "10.0.0.1:5000"
+	req.Header.Set("X-Forwarded-For", "203.0.113.7, 10.0.0.1")
+	req.Header.Set("Authorization", "Bearer secret-api-key")
+	req.Header.Set("Referer", "https://example.com/")
+	req.Header.Set("User-Agent", "curl/8.0")
+	req.Header.Set(REQUEST_ID_HEADER, "req-1234")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if strings.Contains(buffer.String(), "secret-api-key") {
+		t.Errorf("the access log contains the API key: %v", buffer.String())
+	}
+	return buffer.String()
+}
+
+func TestAccessLogCombined(t *testing.T) {
+	line := serveLogged(t, COMBINED)
+	want := regexp.MustCompile(`^203\.0\.113\.7 - - \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "GET /api/get/abcd\?details=true HTTP/1\.1" 418 15 "https://example.com/" "curl/8\.0"\n$`)
+	if !want.MatchString(line) {
+		t.Errorf("logged %q, want the combined format", line)
+	}
+	if line := serveLogged(t, COMMON); !strings.HasSuffix(line, `HTTP/1.1" 418 15`+"\n") {
+		t.Errorf("logged %q, want the common format", line)
+	}
+}
+
+func TestAccessLogJSON(t *testing.T) {
+	var entry accessEntry
+	line := serveLogged(t, JSON)
+	if err := json.Unmarshal([]byte(line), &entry); err != nil {
+		t.Fatalf("logged %q, want a JSON object: %v", line, err)
+	}
+	if entry.ClientIP != "203.0.113.7" || entry.Method != http.MethodGet || entry.URI != "/api/get/abcd?details=true" ||
+		entry.Status != http.StatusTeapot || entry.Bytes != 15 || entry.RequestID != "req-1234" || entry.UserAgent != "curl/8.0" {
+		t.Errorf("logged %+v", entry)
+	}
+}
+
+func TestNewAccessLoggerUnknownFormat(t *testing.T) {
+	if _, err := NewAccessLogger(&bytes.Buffer{}, "apache", ""); err == nil {
+		t.Error("NewAccessLogger accepted an unknown format")
+	}
+}
+
+func TestAccessLogAbortedRequest(t *testing.T) {
+	var buffer bytes.Buffer
+	logger, err := NewAccessLogger(&buffer, COMMON, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := logger.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("partial"))
+		panic(http.ErrAbortHandler)
+	}))
+
+	func() {
+		defer func() {
+			if recovered := recover(); recovered != http.ErrAbortHandler {
+				t.Errorf("the middleware panicked with %v, want http.ErrAbortHandler", recovered)
+			}
+		}()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/export", nil))
+	}()
+	if line := buffer.String(); !strings.HasSuffix(line, `"GET /api/export HTTP/1.1" 200 7`+"\n") {
+		t.Errorf("logged %q for an aborted request, want its status and size", line)
+	}
+}
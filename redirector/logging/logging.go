@@ -0,0 +1,118 @@
+// Package logging sets up the structured JSON logging of the server, identifies each request
+// with an ID, which is included in every line logged while serving it, and writes the access log.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// REQUEST_ID_HEADER carries the ID of a request, both in the request, if the client or a proxy
+// in front of the server assigned one, and in the reply.
+const REQUEST_ID_HEADER = "X-Request-ID"
+
+// MAX_REQUEST_ID_LENGTH is the longest request ID accepted from the client. Longer IDs, or IDs
+// with characters other than printable ASCII, are replaced by a new one.
+const MAX_REQUEST_ID_LENGTH = 128
+
+// Setup makes the default slog logger, which the log package writes through as well, log JSON
+// lines to the standard error from the level set in LOG_LEVEL ("debug", "info", "warn" or
+// "error", defaulting to "info").
+func Setup() {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(os.Getenv("LOG_LEVEL")))
+	if os.Getenv("LOG_LEVEL") == "" || err != nil {
+		level = slog.LevelInfo
+	}
+	slog.SetDefault(New(os.Stderr, level))
+	if os.Getenv("LOG_LEVEL") != "" && err != nil {
+		slog.Warn("invalid LOG_LEVEL, using info", "error", err)
+	}
+}
+
+// New creates a logger writing JSON lines to w from the given level, which adds the request ID
+// and the trace and span IDs from the context of each line, if any.
+func New(w io.Writer, level slog.Leveler) *slog.Logger {
+	return slog.New(contextHandler{slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level})})
+}
+
+// contextHandler is a slog.Handler that adds the IDs carried by the context of each record.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsValid() {
+		record.AddAttrs(
+			slog.String("trace_id", spanContext.TraceID().String()),
+			slog.String("span_id", spanContext.SpanID().String()),
+		)
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// requestIDKey is the key of the request ID in contexts.
+type requestIDKey struct{}
+
+// WithRequestID returns a context carrying the ID of a request.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the ID of the request carried by ctx, or "" if there is none.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Middleware identifies each request served by next with the ID in its X-Request-ID header, if
+// it is valid, or a new random one otherwise. The ID is carried by the context of the request
+// and returned in the X-Request-ID header of the reply.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(REQUEST_ID_HEADER)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(REQUEST_ID_HEADER, id)
+		next.ServeHTTP(w, r.WithContext(WithRequestID(r.Context(), id)))
+	})
+}
+
+// validRequestID indicates whether a request ID from the client can be used as is.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > MAX_REQUEST_ID_LENGTH {
+		return false
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID generates a random request ID.
+func newRequestID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
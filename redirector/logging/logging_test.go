@@ -0,0 +1,81 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func TestMiddleware(t *testing.T) {
+	var seen string
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = RequestID(r.Context())
+	}))
+
+	tests := []struct {
+		name     string
+		incoming string
+		kept     bool
+	}{
+		{"no incoming ID", "", false},
+		{"valid incoming ID", "req-1234", true},
+		{"ID with spaces", "req 1234", false},
+		{"ID too long", strings.Repeat("a", MAX_REQUEST_ID_LENGTH+1), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/abcd", nil)
+			if test.incoming != "" {
+				req.Header.Set(REQUEST_ID_HEADER, test.incoming)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			replied := rec.Header().Get(REQUEST_ID_HEADER)
+			switch {
+			case seen == "" || seen != replied:
+				t.Errorf("the request had ID '%v' but the reply had '%v'", seen, replied)
+			case test.kept && seen != test.incoming:
+				t.Errorf("the request had ID '%v', want the incoming '%v'", seen, test.incoming)
+			case !test.kept && seen == test.incoming:
+				t.Errorf("the incoming ID '%v' was kept, want a new one", test.incoming)
+			}
+		})
+	}
+}
+
+func TestContextHandler(t *testing.T) {
+	var buffer bytes.Buffer
+	logger := New(&buffer, slog.LevelInfo)
+
+	ctx, span := sdktrace.NewTracerProvider().Tracer("test").Start(WithRequestID(context.Background(), "req-1234"), "test")
+	defer span.End()
+	logger.DebugContext(ctx, "ignored")
+	logger.With("path", "abcd").ErrorContext(ctx, "failure", "key", "TEST:abcd")
+
+	var line map[string]any
+	if err := json.Unmarshal(buffer.Bytes(), &line); err != nil {
+		t.Fatalf("logged %q, want a single JSON line: %v", buffer.String(), err)
+	}
+	want := map[string]any{
+		"level":      "ERROR",
+		"msg":        "failure",
+		"path":       "abcd",
+		"key":        "TEST:abcd",
+		"request_id": "req-1234",
+		"trace_id":   span.SpanContext().TraceID().String(),
+		"span_id":    span.SpanContext().SpanID().String(),
+	}
+	for field, value := range want {
+		if line[field] != value {
+			t.Errorf("logged %v = %v, want %v", field, line[field], value)
+		}
+	}
+}
@@ -2,16 +2,25 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
+	"time"
 
 	secretmanager "cloud.google.com/go/secretmanager/apiv1"
 	secretmanagerpb "cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
 	"github.com/joho/godotenv"
+	"github.com/luizcdc/redirectory/redirector/logging"
+	"github.com/luizcdc/redirectory/redirector/metrics"
+	"github.com/luizcdc/redirectory/redirector/records"
+	"github.com/luizcdc/redirectory/redirector/tracing"
 	"github.com/luizcdc/redirectory/redirector/uint_to_any_base"
 )
 
@@ -26,6 +35,21 @@ const API_ROOT = "/api/"
 
 var SERVER_PORT uint16
 
+// SHUTDOWN_TIMEOUT is how long in-flight requests are given to finish once the server is asked
+// to stop.
+var SHUTDOWN_TIMEOUT time.Duration
+
+// STORE_READ_TIMEOUT and STORE_WRITE_TIMEOUT bound how long each operation reading from or writing
+// to the store may take, 0 meaning no limit besides the request being canceled.
+var STORE_READ_TIMEOUT, STORE_WRITE_TIMEOUT time.Duration
+
+// COUNTRY_HEADER is the request header holding the client's country code, set by App Engine
+// or the load balancer in front of the server.
+var COUNTRY_HEADER string
+var COUNTER_FLUSH_INTERVAL time.Duration
+var COUNTER_MAX_PENDING int
+var STATS_RETENTION time.Duration
+
 // initConstants sets the global constants from the environment variables.
 func initConstants() {
 	ALLOWED_CHARS = os.Getenv("ALLOWED_CHARS")
@@ -42,6 +66,15 @@ func initConstants() {
 	}
 
 	API_KEY = os.Getenv("API_KEY")
+	AUTH_DISABLED = os.Getenv("AUTH_DISABLED") == "true"
+	if AUTH_DISABLED {
+		slog.Warn("AUTH_DISABLED is set, the API is open to requests without an API key")
+	}
+	if os.Getenv("API_KEYS_FILE") != "" {
+		if err := records.LoadAPIKeysFile(os.Getenv("API_KEYS_FILE")); err != nil {
+			log.Fatalf("failure loading API_KEYS_FILE: %v", err.Error())
+		}
+	}
 
 	duration, err := strconv.Atoi(os.Getenv("DEFAULT_DURATION"))
 	if err != nil {
@@ -54,6 +87,52 @@ func initConstants() {
 		log.Fatalf("failure reading SERVER_PORT into an int constant: %v", err.Error())
 	}
 	SERVER_PORT = uint16(server_port)
+
+	shutdownSeconds, err := strconv.Atoi(os.Getenv("SHUTDOWN_TIMEOUT_SECONDS"))
+	if err != nil {
+		log.Fatalf("failure reading SHUTDOWN_TIMEOUT_SECONDS into an int constant: %v", err.Error())
+	}
+	SHUTDOWN_TIMEOUT = time.Duration(shutdownSeconds) * time.Second
+
+	readTimeoutMs, err := strconv.Atoi(os.Getenv("STORE_READ_TIMEOUT_MS"))
+	if err != nil {
+		log.Fatalf("failure reading STORE_READ_TIMEOUT_MS into an int constant: %v", err.Error())
+	}
+	STORE_READ_TIMEOUT = time.Duration(readTimeoutMs) * time.Millisecond
+	writeTimeoutMs, err := strconv.Atoi(os.Getenv("STORE_WRITE_TIMEOUT_MS"))
+	if err != nil {
+		log.Fatalf("failure reading STORE_WRITE_TIMEOUT_MS into an int constant: %v", err.Error())
+	}
+	STORE_WRITE_TIMEOUT = time.Duration(writeTimeoutMs) * time.Millisecond
+
+	COUNTRY_HEADER = os.Getenv("COUNTRY_HEADER")
+	if COUNTRY_HEADER == "" {
+		COUNTRY_HEADER = "X-Appengine-Country"
+	}
+
+	flushIntervalMs, err := strconv.Atoi(os.Getenv("COUNTER_FLUSH_INTERVAL_MS"))
+	if err != nil {
+		log.Fatalf("failure reading COUNTER_FLUSH_INTERVAL_MS into an int constant: %v", err.Error())
+	}
+	if flushIntervalMs <= 0 {
+		log.Fatalf("COUNTER_FLUSH_INTERVAL_MS must be positive, got %v", flushIntervalMs)
+	}
+	COUNTER_FLUSH_INTERVAL = time.Duration(flushIntervalMs) * time.Millisecond
+	COUNTER_MAX_PENDING, err = strconv.Atoi(os.Getenv("COUNTER_MAX_PENDING"))
+	if err != nil {
+		log.Fatalf("failure reading COUNTER_MAX_PENDING into an int constant: %v", err.Error())
+	}
+	retentionDays, err := strconv.Atoi(os.Getenv("STATS_RETENTION_DAYS"))
+	if err != nil {
+		log.Fatalf("failure reading STATS_RETENTION_DAYS into an int constant: %v", err.Error())
+	}
+	STATS_RETENTION = time.Duration(retentionDays) * 24 * time.Hour
+
+	RATE_LIMITS, err = parseRateLimits(os.Getenv("RATE_LIMITS"))
+	if err != nil {
+		log.Fatalf("failure reading RATE_LIMITS: %v", err.Error())
+	}
+	CLIENT_IP_HEADER = os.Getenv("CLIENT_IP_HEADER")
 }
 
 // getProjectNumber retrieves the project number from the environment variables or from the metadata server.
@@ -89,7 +168,7 @@ func getProjectNumber() {
 // and sets them in the runtime environment.
 func getSecrets() {
 	getProjectNumber()
-	log.Println("Getting secrets from GCP Secret Manager")
+	slog.Info("getting secrets from GCP Secret Manager")
 	ctx := context.Background()
 	client, err := secretmanager.NewClient(ctx)
 	if err != nil {
@@ -127,32 +206,111 @@ func getSecrets() {
 
 	os.Setenv("REDIS_HOST", string(result.Payload.Data))
 
-	log.Println("Secrets loaded successfully")
+	slog.Info("secrets loaded successfully")
 }
 
 // loadEnv loads environment variables from a .env file if the application is not running on
-// Google App Engine.
+// Google App Engine, then sets up the logging according to them.
 func loadEnv() {
-	if os.Getenv("GAE_APPLICATION") == "" {
-		if godotenv.Load() != nil {
-			log.Fatal("Error loading .env file")
-		}
-	} else {
-		log.Println("Running on Google App Engine, environment variables are already set.")
+	onAppEngine := os.Getenv("GAE_APPLICATION") != ""
+	if !onAppEngine && godotenv.Load() != nil {
+		log.Fatal("Error loading .env file")
+	}
+	logging.Setup()
+	if onAppEngine {
+		slog.Info("running on Google App Engine, environment variables are already set")
 		getSecrets()
 	}
 	initConstants()
-	log.Println("Environment variables loaded successfully")
+	slog.Info("environment variables loaded successfully")
+}
+
+// migrateLegacyRecords rewrites the redirects stored as bare URLs as structured records.
+func migrateLegacyRecords() {
+	migrated, err := records.MigrateLegacyRecords(context.Background())
+	if err != nil {
+		slog.Error("failure migrating legacy records", "migrated", migrated, "error", err)
+		return
+	}
+	slog.Info("migrated legacy records", "migrated", migrated)
 }
 
 func main() {
+	if isCLICommand(os.Args[1:]) {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		code := runCLI(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr)
+		stop()
+		os.Exit(code)
+	}
 	loadEnv()
+	if os.Getenv("MIGRATE_LEGACY_RECORDS") == "true" {
+		go migrateLegacyRecords()
+	}
+	shutdownTracing, err := tracing.Setup(context.Background())
+	if err != nil {
+		log.Fatalf("failure setting up tracing: %v", err.Error())
+	}
+	records.SetTimeouts(STORE_READ_TIMEOUT, STORE_WRITE_TIMEOUT)
+	records.StartCounterFlusher(COUNTER_FLUSH_INTERVAL, COUNTER_MAX_PENDING, STATS_RETENTION)
 	// records.MakeCache(5)
+	metrics.RegisterCache(records.CacheStats)
+	metrics.RegisterDroppedIncrements(records.DroppedIncrements)
 	AuthSubRouter := CreateAuthSubRouter()
 
 	// This GET wildcard is necessary because of httprouter's weird "ambiguous route" behavior
 	router := DefineRoutes(AuthSubRouter)
 
-	log.Printf("Server running on port %v", SERVER_PORT)
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%v", SERVER_PORT), router))
+	accessLog, err := logging.OpenAccessLog()
+	if err != nil {
+		log.Fatalf("failure opening the access log: %v", err.Error())
+	}
+	handler := logging.Middleware(accessLog.Middleware(router))
+
+	server := &http.Server{Addr: fmt.Sprintf(":%v", SERVER_PORT), Handler: handler}
+	err = runServer(server, shutdownTracing)
+	accessLog.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// runServer runs the server until it receives SIGINT or SIGTERM, then stops accepting connections,
+// waits up to SHUTDOWN_TIMEOUT for in-flight requests to finish, writes the pending counter
+// increments, closes the store and flushes the pending spans through shutdownTracing. It returns
+// the error that stopped the server, if it wasn't asked to stop.
+func runServer(server *http.Server, shutdownTracing func(context.Context) error) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		slog.Info("server running", "port", SERVER_PORT)
+		serverErr <- server.ListenAndServe()
+	}()
+
+	var err error
+	select {
+	case err = <-serverErr:
+	case <-ctx.Done():
+		slog.Info("shutting down, draining in-flight requests")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			slog.Error("failure draining in-flight requests", "error", err)
+		}
+	}
+
+	if err := records.Close(); err != nil {
+		slog.Error("failure closing the store", "error", err)
+	}
+	tracingCtx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+	defer cancel()
+	if err := shutdownTracing(tracingCtx); err != nil {
+		slog.Error("failure flushing the pending spans", "error", err)
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	slog.Info("server stopped")
+	return nil
 }
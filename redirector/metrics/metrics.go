@@ -0,0 +1,146 @@
+// Package metrics provides the Prometheus metrics of the server and the handler that exposes
+// them.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/luizcdc/redirectory/redirector/records/lru_cache"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// NAMESPACE prefixes the names of all the metrics.
+const NAMESPACE = "redirectory"
+
+// registry holds the metrics of the server, along with the ones of the Go runtime and the process.
+var registry = prometheus.NewRegistry()
+
+var (
+	requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "http_requests_total",
+		Help:      "Requests served, by route and status code.",
+	}, []string{"route", "code"})
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time taken to serve requests, by route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route"})
+	redisDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "redis_command_duration_seconds",
+		Help:      "Time taken by the commands sent to Redis, by command (pipelines are 'pipeline').",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"command"})
+	redisErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "redis_errors_total",
+		Help:      "Commands sent to Redis that failed, by command (pipelines are 'pipeline').",
+	}, []string{"command"})
+	rateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "rate_limited_requests_total",
+		Help:      "Requests rejected because their client exceeded the rate limit, by route.",
+	}, []string{"route"})
+	randomPathCollisions = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "random_path_collisions_total",
+		Help:      "Random paths that were already taken, so another one had to be chosen.",
+	})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		requests,
+		requestDuration,
+		redisDuration,
+		redisErrors,
+		rateLimited,
+		randomPathCollisions,
+	)
+}
+
+// Handler returns the handler that serves the metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// ObserveRequest records that a request to the given route was replied with status after
+// duration.
+func ObserveRequest(route string, status int, duration time.Duration) {
+	requestDuration.WithLabelValues(route).Observe(duration.Seconds())
+	requests.WithLabelValues(route, strconv.Itoa(status)).Inc()
+}
+
+// ObserveRedisCommand records that a command sent to Redis took duration, failing if err isn't
+// nil.
+func ObserveRedisCommand(command string, duration time.Duration, err error) {
+	redisDuration.WithLabelValues(command).Observe(duration.Seconds())
+	if err != nil {
+		redisErrors.WithLabelValues(command).Inc()
+	}
+}
+
+// IncrRateLimited counts a request to route rejected by the rate limit.
+func IncrRateLimited(route string) {
+	rateLimited.WithLabelValues(route).Inc()
+}
+
+// IncrRandomPathCollisions counts a random path that was already taken.
+func IncrRandomPathCollisions() {
+	randomPathCollisions.Inc()
+}
+
+// cacheCollector collects the usage of an LRUCache from the function that returns its stats.
+type cacheCollector struct {
+	stats     func() lru_cache.Stats
+	hits      *prometheus.Desc
+	misses    *prometheus.Desc
+	evictions *prometheus.Desc
+	size      *prometheus.Desc
+}
+
+func (c cacheCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.hits
+	ch <- c.misses
+	ch <- c.evictions
+	ch <- c.size
+}
+
+func (c cacheCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.stats()
+	ch <- prometheus.MustNewConstMetric(c.hits, prometheus.CounterValue, float64(stats.Hits))
+	ch <- prometheus.MustNewConstMetric(c.misses, prometheus.CounterValue, float64(stats.Misses))
+	ch <- prometheus.MustNewConstMetric(c.evictions, prometheus.CounterValue, float64(stats.Evictions))
+	ch <- prometheus.MustNewConstMetric(c.size, prometheus.GaugeValue, float64(stats.Len))
+}
+
+// RegisterCache exposes the hits, misses, evictions and size of the local cache, as returned by
+// stats whenever the metrics are scraped. It must be called only once.
+func RegisterCache(stats func() lru_cache.Stats) {
+	name := func(name string) string { return prometheus.BuildFQName(NAMESPACE, "cache", name) }
+	registry.MustRegister(cacheCollector{
+		stats:     stats,
+		hits:      prometheus.NewDesc(name("hits_total"), "Lookups that found the redirect in the local cache.", nil, nil),
+		misses:    prometheus.NewDesc(name("misses_total"), "Lookups that didn't find the redirect in the local cache.", nil, nil),
+		evictions: prometheus.NewDesc(name("evictions_total"), "Least recently used entries dropped from the local cache.", nil, nil),
+		size:      prometheus.NewDesc(name("size"), "Entries currently in the local cache.", nil, nil),
+	})
+}
+
+// RegisterDroppedIncrements exposes the number of counter increments dropped, as returned by
+// dropped whenever the metrics are scraped. It must be called only once.
+func RegisterDroppedIncrements(dropped func() int64) {
+	registry.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "dropped_increments_total",
+		Help:      "Counter increments dropped because too many were waiting to be written to the store.",
+	}, func() float64 { return float64(dropped()) }))
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/luizcdc/redirectory/redirector/logging"
+	"github.com/luizcdc/redirectory/redirector/metrics"
+	"github.com/luizcdc/redirectory/redirector/records"
+	"github.com/luizcdc/redirectory/redirector/records/store"
+)
+
+// API_RATE_LIMIT names the limit of the API routes that don't have one of their own, which share
+// a single bucket per API key.
+const API_RATE_LIMIT = "api"
+
+// AUTH_RATE_LIMIT names the limit of the requests to the API of each client address, checked
+// before their API key is authenticated so that the failed attempts are limited too.
+const AUTH_RATE_LIMIT = "auth"
+
+// rateLimit allows Burst requests at once, refilled at Rate requests per second.
+type rateLimit struct {
+	Rate  float64
+	Burst int64
+}
+
+// RATE_LIMITS are the limits of the requests of each client, by route name or API_RATE_LIMIT.
+// Routes without a limit aren't limited.
+var RATE_LIMITS map[string]rateLimit
+
+// CLIENT_IP_HEADER is the header set by the proxy in front of the server with the address of the
+// client (as its first entry), which identifies the clients without an API key, or "" to
+// identify them by the address of the connection.
+var CLIENT_IP_HEADER string
+
+// parseRateLimits parses a comma-separated list of limits such as
+// "api=120/m,SetRandomRedirect=10/m:5,Redirect=20/s:100", each being the name of a route or
+// "api" or "auth", the number of requests allowed per second, minute or hour and, optionally, how many can
+// be made at once (the number per period, by default).
+func parseRateLimits(value string) (map[string]rateLimit, error) {
+	limits := map[string]rateLimit{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, spec, ok := strings.Cut(entry, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("rate limit '%v' must be <route>=<requests>/<s|m|h>[:<burst>]", entry)
+		}
+		spec, burstSpec, hasBurst := strings.Cut(spec, ":")
+		countSpec, period, _ := strings.Cut(spec, "/")
+		count, err := strconv.ParseInt(countSpec, 10, 64)
+		if err != nil || count <= 0 {
+			return nil, fmt.Errorf("the number of requests of rate limit '%v' must be a positive integer", entry)
+		}
+		limit := rateLimit{Burst: count}
+		switch period {
+		case "s":
+			limit.Rate = float64(count)
+		case "m":
+			limit.Rate = float64(count) / 60
+		case "h":
+			limit.Rate = float64(count) / 3600
+		default:
+			return nil, fmt.Errorf("the period of rate limit '%v' must be 's', 'm' or 'h'", entry)
+		}
+		if hasBurst {
+			limit.Burst, err = strconv.ParseInt(burstSpec, 10, 64)
+			if err != nil || limit.Burst <= 0 {
+				return nil, fmt.Errorf("the burst of rate limit '%v' must be a positive integer", entry)
+			}
+		}
+		limits[strings.TrimSpace(name)] = limit
+	}
+	return limits, nil
+}
+
+// rateLimitOf returns the limit that applies to route and its name, which also names its bucket:
+// the route's own limit, or else the API_RATE_LIMIT for the API routes.
+func rateLimitOf(route string) (string, rateLimit, bool) {
+	if limit, ok := RATE_LIMITS[route]; ok {
+		return route, limit, true
+	}
+	if route != "Redirect" && route != AUTH_RATE_LIMIT {
+		limit, ok := RATE_LIMITS[API_RATE_LIMIT]
+		return API_RATE_LIMIT, limit, ok
+	}
+	return "", rateLimit{}, false
+}
+
+// rateLimitClient identifies the client of a request: its API key if it was authenticated with
+// one, or else its address.
+func rateLimitClient(r *http.Request) string {
+	if id := apiKeyFromContext(r.Context()).ID; id != "" {
+		return "key:" + id
+	}
+	return "ip:" + logging.ClientIP(r, CLIENT_IP_HEADER)
+}
+
+// limitRate wraps the handle of a route so that each client can only make the requests allowed
+// by the limit of the route, as checked by allowRequest.
+func limitRate(route string, handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if allowRequest(w, r, route, rateLimitClient(r)) {
+			handle(w, r, ps)
+		}
+	}
+}
+
+// allowRequest takes a token for the request from the bucket of client under the limit of route,
+// kept in the store so that every instance shares it, returning whether the request is allowed.
+// The replies have the RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers, and
+// requests over the limit are replied with a 429 status and a Retry-After header, along with a
+// JSON error except for redirects. Requests are allowed if the store fails.
+func allowRequest(w http.ResponseWriter, r *http.Request, route string, client string) bool {
+	name, limit, ok := rateLimitOf(route)
+	if !ok {
+		return true
+	}
+	bucket, err := records.TakeToken(r.Context(), name+":"+client, limit.Rate, limit.Burst)
+	if err != nil {
+		slog.WarnContext(r.Context(), "failure checking the rate limit, allowing the request", "route", route, "error", err)
+		return true
+	}
+	setRateLimitHeaders(w.Header(), limit, bucket)
+	if bucket.Taken {
+		return true
+	}
+	metrics.IncrRateLimited(route)
+	w.Header().Set("Retry-After", ceilSeconds(bucket.RetryAfter))
+	if route == "Redirect" {
+		http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+	} else {
+		replyJSONError(w, http.StatusTooManyRequests, fmt.Sprintf("too many requests, retry in %v seconds", ceilSeconds(bucket.RetryAfter)))
+	}
+	return false
+}
+
+// setRateLimitHeaders sets the headers describing the state of the bucket of the client.
+func setRateLimitHeaders(header http.Header, limit rateLimit, bucket store.TokenBucket) {
+	header.Set("RateLimit-Limit", strconv.FormatInt(limit.Burst, 10))
+	header.Set("RateLimit-Remaining", strconv.FormatInt(bucket.Remaining, 10))
+	header.Set("RateLimit-Reset", ceilSeconds(bucket.Reset))
+}
+
+// ceilSeconds formats a duration as whole seconds, rounded up.
+func ceilSeconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestParseRateLimits(t *testing.T) {
+	limits, err := parseRateLimits("api=120/m, SetRandomRedirect=10/h:5,Redirect=20/s:100")
+	if err != nil {
+		t.Fatalf("parseRateLimits returned error %v", err)
+	}
+	want := map[string]rateLimit{
+		"api":               {Rate: 2, Burst: 120},
+		"SetRandomRedirect": {Rate: 10.0 / 3600, Burst: 5},
+		"Redirect":          {Rate: 20, Burst: 100},
+	}
+	for name, limit := range want {
+		if limits[name] != limit {
+			t.Errorf("the limit of %v is %+v, want %+v", name, limits[name], limit)
+		}
+	}
+	for _, invalid := range []string{"api", "api=10", "api=10/d", "api=0/m", "api=10/m:0", "=10/m"} {
+		if _, err := parseRateLimits(invalid); err == nil {
+			t.Errorf("parseRateLimits(%q) didn't return an error", invalid)
+		}
+	}
+}
+
+func TestRateLimits(t *testing.T) {
+	router := newTestRouter(t)
+	RATE_LIMITS = map[string]rateLimit{
+		API_RATE_LIMIT:      {Rate: 0.01, Burst: 2},
+		"SetRandomRedirect": {Rate: 0.01, Burst: 1},
+		"Redirect":          {Rate: 0.01, Burst: 1},
+	}
+	t.Cleanup(func() { RATE_LIMITS = nil })
+
+	rec := doRequest(router, http.MethodPost, "/api/set", `{"url": "https://example.com"}`)
+	if rec.Code != http.StatusOK || rec.Header().Get("RateLimit-Limit") != "1" || rec.Header().Get("RateLimit-Remaining") != "0" {
+		t.Fatalf("POST /api/set returned status %v with headers %v", rec.Code, rec.Header())
+	}
+	rec = doRequest(router, http.MethodPost, "/api/set", `{"url": "https://example.com"}`)
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "100" {
+		t.Errorf("POST /api/set over the limit returned status %v with headers %v", rec.Code, rec.Header())
+	}
+	var reply struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &reply); err != nil || reply.Error == "" || rec.Header().Get("Content-Type") != APPLICATION_JSON {
+		t.Errorf("POST /api/set over the limit replied %q with Content-Type %v, want a JSON error", rec.Body.String(), rec.Header().Get("Content-Type"))
+	}
+
+	// The other API routes share the api bucket, which is separate from SetRandomRedirect's.
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		if rec := doRequest(router, http.MethodPost, "/api/set/docs"+string(rune('a'+i)), `{"url": "https://example.com"}`); rec.Code != want {
+			t.Errorf("POST /api/set/docs%c returned status %v, want %v", 'a'+i, rec.Code, want)
+		}
+	}
+
+	// Redirects are limited by the address of the client.
+	for _, remoteAddr := range []string{"192.0.2.1:1234", "192.0.2.2:1234"} {
+		req := newTestRequest(http.MethodGet, "/docsa", "")
+		req.RemoteAddr = remoteAddr
+		if rec := serve(router, req); rec.Code != http.StatusTemporaryRedirect {
+			t.Errorf("GET /docsa from %v returned status %v, want %v", remoteAddr, rec.Code, http.StatusTemporaryRedirect)
+		}
+	}
+	req := newTestRequest(http.MethodGet, "/docsa", "")
+	req.RemoteAddr = "192.0.2.1:4321"
+	if rec := serve(router, req); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("GET /docsa over the limit returned status %v, want %v", rec.Code, http.StatusTooManyRequests)
+	}
+}
+
+func TestAuthRateLimit(t *testing.T) {
+	router := newTestRouter(t)
+	RATE_LIMITS = map[string]rateLimit{AUTH_RATE_LIMIT: {Rate: 0.01, Burst: 2}}
+	CLIENT_IP_HEADER = "X-Forwarded-For"
+	t.Cleanup(func() { RATE_LIMITS, CLIENT_IP_HEADER = nil, "" })
+
+	// Failed attempts at guessing an API key are limited by the first address of the header,
+	// whatever the entries after it.
+	request := func(forwardedFor string) int {
+		req := newTestRequest(http.MethodGet, "/api/get/docs", "")
+		req.Header.Set("Authorization", "Bearer wrong-key")
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		return serve(router, req).Code
+	}
+	for i, want := range []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests} {
+		if code := request("192.0.2.1, 10.0.0." + string(rune('1'+i))); code != want {
+			t.Errorf("request %v with a wrong API key returned status %v, want %v", i+1, code, want)
+		}
+	}
+	if code := request("192.0.2.2"); code != http.StatusUnauthorized {
+		t.Errorf("a request with a wrong API key from another address returned status %v, want %v", code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,230 @@
+package records
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/luizcdc/redirectory/redirector/records/store"
+)
+
+// DEFAULT_MAX_PENDING_INCREMENTS is how many distinct counters and hash fields may await a
+// flush before the aggregator is started with a different limit.
+const DEFAULT_MAX_PENDING_INCREMENTS = 10000
+
+// counterAggregator accumulates increments to counters in memory, so that they are written to
+// the store in periodic batches rather than one round-trip per increment.
+type counterAggregator struct {
+	mu      sync.Mutex
+	pending store.Deltas
+	// size is the number of distinct counters and hash fields in pending.
+	size       int
+	maxPending int
+	// dropped counts the increments discarded because too many were pending.
+	dropped        atomic.Int64
+	statsRetention time.Duration
+	// flushing is held while a flush writes to the store, so that the counters forgotten in the
+	// meantime aren't written after they're deleted.
+	flushing sync.Mutex
+
+	// stop stops the periodic flushes, and stopped is closed once they stopped. stop is nil
+	// while they aren't running, and runMu guards both.
+	runMu   sync.Mutex
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+var aggregator = newCounterAggregator(DEFAULT_MAX_PENDING_INCREMENTS)
+
+// newCounterAggregator constructs a counterAggregator holding up to maxPending increments.
+func newCounterAggregator(maxPending int) *counterAggregator {
+	return &counterAggregator{
+		pending:    store.NewDeltas(),
+		maxPending: maxPending,
+	}
+}
+
+// incr adds delta to the pending increment of a counter.
+func (a *counterAggregator) incr(key string, delta int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.incrLocked(key, delta)
+}
+
+// incrLocked adds delta to the pending increment of a counter (without locking).
+func (a *counterAggregator) incrLocked(key string, delta int64) {
+	if _, ok := a.pending.Counters[key]; !ok {
+		if a.size >= a.maxPending {
+			a.dropped.Add(delta)
+			return
+		}
+		a.size++
+	}
+	a.pending.Counters[key] += delta
+}
+
+// hincrStats adds the deltas to the pending increments of the fields of a hash of analytics,
+// which is kept for the retention period of the analytics.
+func (a *counterAggregator) hincrStats(key string, deltas map[string]int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.hincrLocked(key, deltas, a.statsRetention)
+}
+
+// hincrLocked adds the deltas to the pending increments of the fields of a hash (without
+// locking).
+func (a *counterAggregator) hincrLocked(key string, deltas map[string]int64, ttl time.Duration) {
+	fields, ok := a.pending.Hashes[key]
+	if !ok {
+		fields = make(map[string]int64, len(deltas))
+		a.pending.Hashes[key] = fields
+	}
+	for field, delta := range deltas {
+		if _, ok := fields[field]; !ok {
+			if a.size >= a.maxPending {
+				a.dropped.Add(delta)
+				continue
+			}
+			a.size++
+		}
+		fields[field] += delta
+	}
+	if ttl > 0 {
+		a.pending.Expirations[key] = ttl
+	}
+}
+
+// forget discards the pending increments of the counters and hashes of keys, waiting for the
+// flush in progress, if any, so that the keys can be deleted from the store once it returns.
+func (a *counterAggregator) forget(keys []string) {
+	a.flushing.Lock()
+	defer a.flushing.Unlock()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, key := range keys {
+		if _, ok := a.pending.Counters[key]; ok {
+			delete(a.pending.Counters, key)
+			a.size--
+		}
+		if fields, ok := a.pending.Hashes[key]; ok {
+			delete(a.pending.Hashes, key)
+			delete(a.pending.Expirations, key)
+			a.size -= len(fields)
+		}
+	}
+}
+
+// pendingFields returns the fields of a hash that have pending increments.
+func (a *counterAggregator) pendingFields(key string) []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	fields := make([]string, 0, len(a.pending.Hashes[key]))
+	for field := range a.pending.Hashes[key] {
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// flush writes every pending increment to the store in a single batch. If that fails, the
+// increments that weren't applied are kept to be retried on the next flush.
+func (a *counterAggregator) flush() error {
+	a.flushing.Lock()
+	defer a.flushing.Unlock()
+	a.mu.Lock()
+	deltas := a.pending
+	a.pending = store.NewDeltas()
+	a.size = 0
+	a.mu.Unlock()
+
+	if len(deltas.Counters) == 0 && len(deltas.Hashes) == 0 {
+		return nil
+	}
+	ctx, cancel := withWriteTimeout(context.Background())
+	defer cancel()
+	unapplied, err := getStore().ApplyDeltas(ctx, deltas)
+	if err != nil {
+		a.mu.Lock()
+		for key, delta := range unapplied.Counters {
+			a.incrLocked(key, delta)
+		}
+		for key, fields := range unapplied.Hashes {
+			a.hincrLocked(key, fields, unapplied.Expirations[key])
+		}
+		a.mu.Unlock()
+	}
+	return err
+}
+
+// run flushes the pending increments every interval until stop is closed, flushing one last
+// time before closing stopped.
+func (a *counterAggregator) run(interval time.Duration, stop chan struct{}, stopped chan struct{}) {
+	defer close(stopped)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			if err := a.flush(); err != nil {
+				slog.Error("failure flushing the counters on shutdown", "error", err)
+			}
+			return
+		case <-ticker.C:
+			if err := a.flush(); err != nil {
+				slog.Error("failure flushing the counters", "error", err)
+			}
+		}
+	}
+}
+
+// StartCounterFlusher starts flushing the pending increments of every counter (including the
+// analytics of redirects, which are kept for statsRetention) to the store every interval,
+// holding at most maxPending distinct counters between flushes. It has no effect while the
+// flushes are running, and starts them again once stopped. Until it is called, increments are
+// only written by FlushCounters.
+func StartCounterFlusher(interval time.Duration, maxPending int, statsRetention time.Duration) {
+	aggregator.runMu.Lock()
+	defer aggregator.runMu.Unlock()
+	if aggregator.stop != nil {
+		return
+	}
+	aggregator.mu.Lock()
+	aggregator.maxPending = maxPending
+	aggregator.statsRetention = statsRetention
+	aggregator.mu.Unlock()
+	aggregator.stop = make(chan struct{})
+	aggregator.stopped = make(chan struct{})
+	go aggregator.run(interval, aggregator.stop, aggregator.stopped)
+}
+
+// StopCounterFlusher stops the periodic flushes after flushing the pending increments one last
+// time, waiting for it to finish.
+func StopCounterFlusher() {
+	aggregator.runMu.Lock()
+	defer aggregator.runMu.Unlock()
+	if aggregator.stop == nil {
+		if err := aggregator.flush(); err != nil {
+			slog.Error("failure flushing the counters on shutdown", "error", err)
+		}
+		return
+	}
+	close(aggregator.stop)
+	<-aggregator.stopped
+	aggregator.stop = nil
+}
+
+// FlushCounters immediately writes the pending increments of every counter to the store.
+func FlushCounters() error {
+	return aggregator.flush()
+}
+
+// DroppedIncrements returns how many increments were discarded because too many counters were
+// waiting to be flushed, which happens when the store is unavailable for too long.
+func DroppedIncrements() int64 {
+	return aggregator.dropped.Load()
+}
@@ -0,0 +1,122 @@
+package records
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/luizcdc/redirectory/redirector/records/store"
+)
+
+// failingStore is a Store whose batches of increments always fail.
+type failingStore struct {
+	*store.MemoryStore
+}
+
+func (s failingStore) ApplyDeltas(ctx context.Context, deltas store.Deltas) (store.Deltas, error) {
+	return deltas, errors.New("unavailable")
+}
+
+func TestCounterAggregator(t *testing.T) {
+	t.Setenv("RUNNING_ENV", "TEST")
+	memory := store.NewMemoryStore(0)
+	UseStore(memory)
+	a := newCounterAggregator(3)
+	a.statsRetention = time.Hour
+
+	a.incr("a", 1)
+	a.incr("a", 2)
+	a.hincrStats("h", map[string]int64{"x": 1, "y": 1})
+	a.incr("b", 1) // Over the limit of pending counters.
+	if dropped := a.dropped.Load(); dropped != 1 {
+		t.Errorf("dropped = %v, want 1", dropped)
+	}
+
+	UseStore(failingStore{memory})
+	if err := a.flush(); err == nil {
+		t.Error("flush to a failing store returned no error")
+	}
+	UseStore(memory)
+	if err := a.flush(); err != nil {
+		t.Fatalf("flush returned error %v", err)
+	}
+	if value, _ := memory.Get(context.Background(), "a"); value != "3" {
+		t.Errorf("counter a = %v, want 3", value)
+	}
+	if fields, _ := memory.HGetAll(context.Background(), "h"); fields["x"] != 1 || fields["y"] != 1 {
+		t.Errorf("hash h = %v, want x and y 1", fields)
+	}
+	if ttl, _ := memory.TTL(context.Background(), "h"); ttl <= 0 || ttl > time.Hour {
+		t.Errorf("TTL(h) = %v, want up to an hour", ttl)
+	}
+	if _, err := memory.Get(context.Background(), "b"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("dropped counter b was written, Get returned error %v", err)
+	}
+}
+
+// partialStore is a Store whose batches of increments only apply the counters before failing.
+type partialStore struct {
+	*store.MemoryStore
+}
+
+func (s partialStore) ApplyDeltas(ctx context.Context, deltas store.Deltas) (store.Deltas, error) {
+	unapplied := store.NewDeltas()
+	for key, delta := range deltas.Counters {
+		s.IncrBy(ctx, key, delta)
+	}
+	unapplied.Hashes = deltas.Hashes
+	unapplied.Expirations = deltas.Expirations
+	return unapplied, errors.New("timeout")
+}
+
+func TestCounterAggregatorRetriesOnlyUnapplied(t *testing.T) {
+	t.Setenv("RUNNING_ENV", "TEST")
+	memory := store.NewMemoryStore(0)
+	a := newCounterAggregator(10)
+	a.incr("a", 1)
+	a.hincrStats("h", map[string]int64{"x": 1})
+	a.hincrStats("gone", map[string]int64{"x": 1})
+
+	UseStore(partialStore{memory})
+	if err := a.flush(); err == nil {
+		t.Error("flush to a partially failing store returned no error")
+	}
+	// The retried increments of deleted keys are discarded.
+	a.forget([]string{"gone"})
+	UseStore(memory)
+	if err := a.flush(); err != nil {
+		t.Fatalf("flush returned error %v", err)
+	}
+	if value, _ := memory.Get(context.Background(), "a"); value != "1" {
+		t.Errorf("counter a = %v after a retry, want 1", value)
+	}
+	if fields, _ := memory.HGetAll(context.Background(), "h"); fields["x"] != 1 {
+		t.Errorf("hash h = %v after a retry, want x 1", fields)
+	}
+	if fields, _ := memory.HGetAll(context.Background(), "gone"); len(fields) != 0 {
+		t.Errorf("hash gone = %v, a forgotten hash was written", fields)
+	}
+}
+
+func TestCounterFlusherRestarts(t *testing.T) {
+	t.Setenv("RUNNING_ENV", "TEST")
+	memory := store.NewMemoryStore(0)
+	UseStore(memory)
+	StartCounterFlusher(time.Millisecond, DEFAULT_MAX_PENDING_INCREMENTS, 0)
+	StopCounterFlusher()
+	StartCounterFlusher(time.Millisecond, DEFAULT_MAX_PENDING_INCREMENTS, 0)
+	t.Cleanup(StopCounterFlusher)
+
+	aggregator.incr("restarted", 1)
+	deadline := time.Now().Add(time.Second)
+	for {
+		if value, _ := memory.Get(context.Background(), "restarted"); value == "1" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the counters weren't flushed after the flusher was started again")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
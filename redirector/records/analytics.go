@@ -0,0 +1,204 @@
+package records
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Hit is a single time a redirect was served.
+type Hit struct {
+	Key       string
+	Time      time.Time
+	Referrer  string
+	UserAgent string
+	// Country is the ISO 3166-1 alpha-2 code of the client's country, if known.
+	Country string
+}
+
+// Granularity is the size of the time buckets in which hits are counted.
+type Granularity string
+
+const (
+	HOURLY Granularity = "hour"
+	DAILY  Granularity = "day"
+)
+
+// HitBucket is the number of hits of a redirect within the hour or day starting at Time.
+type HitBucket struct {
+	Time time.Time `json:"time"`
+	Hits int64     `json:"hits"`
+}
+
+// HitStats are the analytics of a redirect over a period of time.
+type HitStats struct {
+	Total  int64       `json:"total"`
+	Series []HitBucket `json:"series"`
+	// The breakdowns are counted over the whole days within the period.
+	Referrers map[string]int64 `json:"referrers"`
+	Agents    map[string]int64 `json:"agents"`
+	Countries map[string]int64 `json:"countries"`
+}
+
+// The fields of the daily hash of hits of a redirect, each followed by the hour, referrer host,
+// user agent family or country it counts.
+const (
+	hourField     = "hour:"
+	referrerField = "referrer:"
+	agentField    = "agent:"
+	countryField  = "country:"
+)
+
+// statsDayLayout is the layout of the days in the keys of the daily hashes of hits.
+const statsDayLayout = "20060102"
+
+// statsKey returns the key of the hash counting the hits of a redirect during a day.
+func statsKey(key string, day time.Time) string {
+	return addInternalPrefix(fmt.Sprintf("stats:%s:%s", key, day.UTC().Format(statsDayLayout)))
+}
+
+// statsDaysKey returns the key of the hash indexing the days during which a redirect was hit,
+// whose fields are the days, formatted as in statsKey, counting the hits of each. It spares
+// reading or deleting the hash of every day that may have analytics.
+func statsDaysKey(key string) string {
+	return addInternalPrefix("stats_days:" + key)
+}
+
+// RecordHit increments the counters of served redirects and the analytics of the hit without
+// blocking the caller, as they are only written to the store by the next flush of the counters.
+func RecordHit(hit Hit) {
+	IncrCountServedRedirects(hit.Key)
+
+	hitTime := hit.Time.UTC()
+	key := statsKey(hit.Key, hitTime)
+	deltas := map[string]int64{
+		hourField + hitTime.Format("15"):           1,
+		referrerField + referrerHost(hit.Referrer): 1,
+		agentField + AgentFamily(hit.UserAgent):    1,
+		countryField + countryCode(hit.Country):    1,
+	}
+	aggregator.hincrStats(key, deltas)
+	aggregator.hincrStats(statsDaysKey(hit.Key), map[string]int64{hitTime.Format(statsDayLayout): 1})
+}
+
+// referrerHost returns the host of the referrer, or "direct" if there is none.
+func referrerHost(referrer string) string {
+	parsed, err := url.Parse(referrer)
+	if referrer == "" || err != nil || parsed.Host == "" {
+		return "direct"
+	}
+	return strings.ToLower(parsed.Host)
+}
+
+// countryCode normalizes the country code, returning "unknown" if there is none.
+func countryCode(country string) string {
+	if country == "" || strings.EqualFold(country, "ZZ") || strings.EqualFold(country, "XX") {
+		return "unknown"
+	}
+	return strings.ToUpper(country)
+}
+
+// AgentFamily classifies a User-Agent header into a coarse browser family.
+func AgentFamily(userAgent string) string {
+	lower := strings.ToLower(userAgent)
+	// The order matters, as most browsers also claim to be the ones they are based on.
+	switch {
+	case userAgent == "":
+		return "unknown"
+	case strings.Contains(lower, "bot") || strings.Contains(lower, "crawl") || strings.Contains(lower, "spider"):
+		return "bot"
+	case strings.Contains(lower, "curl/") || strings.Contains(lower, "wget/"):
+		return "cli"
+	case strings.Contains(lower, "edg/"):
+		return "edge"
+	case strings.Contains(lower, "opr/") || strings.Contains(lower, "opera"):
+		return "opera"
+	case strings.Contains(lower, "firefox/") || strings.Contains(lower, "fxios/"):
+		return "firefox"
+	case strings.Contains(lower, "chrome/") || strings.Contains(lower, "crios/"):
+		return "chrome"
+	case strings.Contains(lower, "safari/"):
+		return "safari"
+	}
+	return "other"
+}
+
+// GetHitStats retrieves the analytics of a redirect from from to to (inclusive), with hits
+// counted in buckets of the given granularity.
+func GetHitStats(ctx context.Context, key string, from time.Time, to time.Time, granularity Granularity) (HitStats, error) {
+	stats := HitStats{
+		Series:    []HitBucket{},
+		Referrers: map[string]int64{},
+		Agents:    map[string]int64{},
+		Countries: map[string]int64{},
+	}
+	from, to = from.UTC(), to.UTC()
+	firstDay := from.Truncate(24 * time.Hour)
+	hitDays, err := getHash(ctx, statsDaysKey(key))
+	if err != nil {
+		slog.ErrorContext(ctx, "failure getting the analytics from the store", "path", key, "key", statsDaysKey(key), "error", err)
+		return stats, err
+	}
+	// Only the hashes of the days with hits are read.
+	keys := []string{}
+	for day := firstDay; !day.After(to); day = day.Add(24 * time.Hour) {
+		if hitDays[day.Format(statsDayLayout)] > 0 {
+			keys = append(keys, statsKey(key, day))
+		}
+	}
+	ctx, cancel := withReadTimeout(ctx)
+	defer cancel()
+	hashes, err := getStore().HGetAllMany(ctx, keys)
+	if err != nil {
+		slog.ErrorContext(ctx, "failure getting the analytics from the store", "path", key, "days", len(keys), "error", err)
+		return stats, err
+	}
+	daily := make(map[string]map[string]int64, len(keys))
+	for i, k := range keys {
+		daily[k] = hashes[i]
+	}
+
+	for day := firstDay; !day.After(to); day = day.Add(24 * time.Hour) {
+		fields := daily[statsKey(key, day)]
+
+		dayBucket := HitBucket{Time: day}
+		for hour := range 24 {
+			bucketTime := day.Add(time.Duration(hour) * time.Hour)
+			if bucketTime.Before(from.Truncate(time.Hour)) || bucketTime.After(to) {
+				continue
+			}
+			hits := fields[fmt.Sprintf("%s%02d", hourField, hour)]
+			stats.Total += hits
+			if granularity == HOURLY {
+				stats.Series = append(stats.Series, HitBucket{Time: bucketTime, Hits: hits})
+			}
+			dayBucket.Hits += hits
+		}
+		if granularity == DAILY {
+			stats.Series = append(stats.Series, dayBucket)
+		}
+
+		for field, count := range fields {
+			name, value, _ := strings.Cut(field, ":")
+			switch name + ":" {
+			case referrerField:
+				stats.Referrers[value] += count
+			case agentField:
+				stats.Agents[value] += count
+			case countryField:
+				stats.Countries[value] += count
+			}
+		}
+	}
+	return stats, nil
+}
+
+// getHash retrieves every field of the hash stored at key.
+func getHash(ctx context.Context, key string) (map[string]int64, error) {
+	ctx, cancel := withReadTimeout(ctx)
+	defer cancel()
+	return getStore().HGetAll(ctx, key)
+}
@@ -0,0 +1,98 @@
+package records
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/luizcdc/redirectory/redirector/records/store"
+)
+
+func TestAgentFamily(t *testing.T) {
+	testCases := []struct {
+		userAgent string
+		want      string
+	}{
+		{"", "unknown"},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/125.0.0.0 Safari/537.36", "chrome"},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/125.0.0.0 Safari/537.36 Edg/125.0.0.0", "edge"},
+		{"Mozilla/5.0 (X11; Linux x86_64; rv:126.0) Gecko/20100101 Firefox/126.0", "firefox"},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Mobile/15E148 Safari/604.1", "safari"},
+		{"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", "bot"},
+		{"curl/8.5.0", "cli"},
+		{"something else", "other"},
+	}
+	for _, tc := range testCases {
+		if got := AgentFamily(tc.userAgent); got != tc.want {
+			t.Errorf("AgentFamily(%v) = %v, want %v", tc.userAgent, got, tc.want)
+		}
+	}
+}
+
+func TestGetHitStats(t *testing.T) {
+	t.Setenv("RUNNING_ENV", "TEST")
+	UseStore(store.NewMemoryStore(0))
+
+	day := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	RecordHit(Hit{Key: "docs", Time: day.Add(2 * time.Hour), Referrer: "https://example.com/a"})
+	RecordHit(Hit{Key: "docs", Time: day.Add(2*time.Hour + time.Minute), Country: "br"})
+	RecordHit(Hit{Key: "docs", Time: day.Add(26 * time.Hour), UserAgent: "curl/8.5.0"})
+	RecordHit(Hit{Key: "other", Time: day.Add(2 * time.Hour)})
+	if err := FlushCounters(); err != nil {
+		t.Fatalf("FlushCounters returned error %v", err)
+	}
+
+	stats, err := GetHitStats(context.Background(), "docs", day, day.Add(48*time.Hour-time.Second), DAILY)
+	if err != nil {
+		t.Fatalf("GetHitStats returned error %v", err)
+	}
+	if stats.Total != 3 || len(stats.Series) != 2 || stats.Series[0].Hits != 2 || stats.Series[1].Hits != 1 {
+		t.Errorf("GetHitStats(DAILY) = %+v", stats)
+	}
+	if stats.Referrers["example.com"] != 1 || stats.Referrers["direct"] != 2 || stats.Countries["BR"] != 1 || stats.Agents["cli"] != 1 {
+		t.Errorf("GetHitStats(DAILY) breakdowns = %+v", stats)
+	}
+
+	stats, _ = GetHitStats(context.Background(), "docs", day.Add(time.Hour), day.Add(3*time.Hour), HOURLY)
+	if stats.Total != 2 || len(stats.Series) != 3 || stats.Series[1].Hits != 2 {
+		t.Errorf("GetHitStats(HOURLY) = %+v", stats)
+	}
+	if count, _ := GetCountHits(context.Background(), "docs"); count != 3 {
+		t.Errorf("GetCountHits(docs) = %v, want 3", count)
+	}
+}
+
+func TestDelKeysClearsHitStats(t *testing.T) {
+	t.Setenv("RUNNING_ENV", "TEST")
+	ctx := context.Background()
+	memory := store.NewMemoryStore(0)
+	UseStore(memory)
+	SetRecord(ctx, "docs", NewRecord("https://example.com", 0))
+	SetRecord(ctx, "other", NewRecord("https://example.com", 0))
+
+	now := time.Now().UTC()
+	RecordHit(Hit{Key: "docs", Time: now.AddDate(0, 0, -3)})
+	RecordHit(Hit{Key: "other", Time: now})
+	FlushCounters()
+	// The hits that weren't flushed yet mustn't be written once the redirect is deleted.
+	RecordHit(Hit{Key: "docs", Time: now})
+
+	if deleted, err := DelKeys(ctx, []string{"docs"}); err != nil || !deleted[0] {
+		t.Fatalf("DelKeys(docs) = %v, %v", deleted, err)
+	}
+	FlushCounters()
+	if stats, _ := GetHitStats(ctx, "docs", now.AddDate(0, 0, -7), now, DAILY); stats.Total != 0 {
+		t.Errorf("GetHitStats(docs) = %+v after deleting the redirect, want no hits", stats)
+	}
+	if count, _ := GetCountHits(ctx, "docs"); count != 0 {
+		t.Errorf("GetCountHits(docs) = %v after deleting the redirect, want 0", count)
+	}
+	for _, prefix := range []string{"TEST#stats:docs:", "TEST#stats_days:docs", "TEST#hits:docs"} {
+		if keys, _ := memory.Keys(ctx, prefix); len(keys) != 0 {
+			t.Errorf("the keys %v remain after deleting the redirect", keys)
+		}
+	}
+	if stats, _ := GetHitStats(ctx, "other", now, now, DAILY); stats.Total != 1 {
+		t.Errorf("GetHitStats(other) = %+v, deleting docs changed it", stats)
+	}
+}
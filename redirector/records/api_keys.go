@@ -0,0 +1,272 @@
+package records
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The scopes an API key can be granted, each allowing a group of endpoints.
+const (
+	SCOPE_LINKS_READ   = "links:read"
+	SCOPE_LINKS_WRITE  = "links:write"
+	SCOPE_LINKS_DELETE = "links:delete"
+	SCOPE_STATS_READ   = "stats:read"
+	SCOPE_KEYS_ADMIN   = "keys:admin"
+)
+
+// SCOPES are all the scopes, in the order they are listed.
+var SCOPES = []string{SCOPE_LINKS_READ, SCOPE_LINKS_WRITE, SCOPE_LINKS_DELETE, SCOPE_STATS_READ, SCOPE_KEYS_ADMIN}
+
+// API_KEY_PREFIX starts every API key, which is followed by the ID of the key, an underscore
+// and its secret.
+const API_KEY_PREFIX = "rdk_"
+
+// ErrInvalidAPIKey is returned when an API key is malformed, unknown, revoked or has the wrong
+// secret.
+var ErrInvalidAPIKey = errors.New("invalid API key")
+
+// ErrReadOnlyAPIKey is returned when revoking an API key loaded from API_KEYS_FILE, which can
+// only be revoked by removing it from the file.
+var ErrReadOnlyAPIKey = errors.New("the API key is defined in the keys file")
+
+// APIKey is an API key as it is stored. Only the hash of its secret is kept.
+type APIKey struct {
+	ID     string   `json:"id"`
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// Hash is the SHA-256 of the secret, in hexadecimal.
+	Hash      string    `json:"hash,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	// RevokedAt is the zero value if the key wasn't revoked.
+	RevokedAt time.Time `json:"revoked_at"`
+}
+
+// HasScope indicates whether the key was granted scope.
+func (key APIKey) HasScope(scope string) bool {
+	return slices.Contains(key.Scopes, scope)
+}
+
+// IsScope indicates whether scope is one of SCOPES.
+func IsScope(scope string) bool {
+	return slices.Contains(SCOPES, scope)
+}
+
+// fileKeys are the API keys loaded from API_KEYS_FILE, by ID.
+var fileKeys = map[string]APIKey{}
+var fileKeysMu sync.RWMutex
+
+// LoadAPIKeysFile loads the API keys defined in a JSON file, which holds an array of keys with
+// their "id", "name", "scopes" and "hash" (the SHA-256 of the secret, in hexadecimal), replacing
+// the ones loaded before. The IDs must not contain underscores.
+func LoadAPIKeysFile(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var keys []APIKey
+	if err := json.Unmarshal(content, &keys); err != nil {
+		return fmt.Errorf("failure parsing the keys file: %w", err)
+	}
+	loaded := make(map[string]APIKey, len(keys))
+	for _, key := range keys {
+		switch {
+		case key.ID == "" || strings.Contains(key.ID, "_"):
+			return fmt.Errorf("'%v' isn't a valid API key ID", key.ID)
+		case len(key.Hash) != 2*sha256.Size:
+			return fmt.Errorf("the hash of API key '%v' isn't a hexadecimal SHA-256", key.ID)
+		}
+		for _, scope := range key.Scopes {
+			if !IsScope(scope) {
+				return fmt.Errorf("API key '%v' has unknown scope '%v'", key.ID, scope)
+			}
+		}
+		key.Hash = strings.ToLower(key.Hash)
+		key.RevokedAt = time.Time{}
+		loaded[key.ID] = key
+	}
+
+	fileKeysMu.Lock()
+	defer fileKeysMu.Unlock()
+	fileKeys = loaded
+	return nil
+}
+
+// fileKey returns the API key with the given ID loaded from API_KEYS_FILE, if any.
+func fileKey(id string) (APIKey, bool) {
+	fileKeysMu.RLock()
+	defer fileKeysMu.RUnlock()
+	key, ok := fileKeys[id]
+	return key, ok
+}
+
+// apiKeyKey returns the key of an API key in the store.
+func apiKeyKey(id string) string {
+	return addInternalPrefix("apikey:" + id)
+}
+
+// hashSecret returns the hash of the secret of an API key, as it is stored.
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomHex returns n random bytes in hexadecimal.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// CreateAPIKey creates an API key with the given name and scopes, returning it along with the
+// key itself, which is only known to the caller since just the hash of its secret is stored.
+func CreateAPIKey(ctx context.Context, name string, scopes []string) (APIKey, string, error) {
+	secret := randomHex(32)
+	key := APIKey{
+		ID:        randomHex(6),
+		Name:      name,
+		Scopes:    scopes,
+		Hash:      hashSecret(secret),
+		CreatedAt: time.Now().UTC(),
+	}
+	encoded, err := json.Marshal(key)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+	created, err := getStore().SetNX(ctx, apiKeyKey(key.ID), string(encoded), 0)
+	if err != nil {
+		slog.ErrorContext(ctx, "failure creating the API key in the store", "api_key_id", key.ID, "error", err)
+		return APIKey{}, "", err
+	}
+	if !created {
+		return APIKey{}, "", fmt.Errorf("API key ID '%v' is already taken", key.ID)
+	}
+	return key, API_KEY_PREFIX + key.ID + "_" + secret, nil
+}
+
+// getAPIKey retrieves an API key from API_KEYS_FILE or from the store, returning ErrNotFound if
+// it doesn't exist.
+func getAPIKey(ctx context.Context, id string) (APIKey, error) {
+	if key, ok := fileKey(id); ok {
+		return key, nil
+	}
+	ctx, cancel := withReadTimeout(ctx)
+	defer cancel()
+	encoded, err := getStore().Get(ctx, apiKeyKey(id))
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			slog.ErrorContext(ctx, "failure getting the API key from the store", "api_key_id", id, "error", err)
+		}
+		return APIKey{}, err
+	}
+	var key APIKey
+	if err := json.Unmarshal([]byte(encoded), &key); err != nil {
+		return APIKey{}, fmt.Errorf("failure decoding API key '%v': %w", id, err)
+	}
+	return key, nil
+}
+
+// AuthenticateAPIKey returns the API key matching token, or ErrInvalidAPIKey if there is no
+// such key, it was revoked or the secret is wrong. Other errors come from the store.
+func AuthenticateAPIKey(ctx context.Context, token string) (APIKey, error) {
+	id, secret, ok := strings.Cut(strings.TrimPrefix(token, API_KEY_PREFIX), "_")
+	if !ok || !strings.HasPrefix(token, API_KEY_PREFIX) || id == "" || secret == "" {
+		return APIKey{}, ErrInvalidAPIKey
+	}
+	key, err := getAPIKey(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		return APIKey{}, ErrInvalidAPIKey
+	} else if err != nil {
+		return APIKey{}, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(key.Hash)) != 1 || !key.RevokedAt.IsZero() {
+		return APIKey{}, ErrInvalidAPIKey
+	}
+	key.Hash = ""
+	return key, nil
+}
+
+// ListAPIKeys retrieves every API key, including the revoked ones and the ones loaded from
+// API_KEYS_FILE, ordered by creation time, without the hashes of their secrets.
+func ListAPIKeys(ctx context.Context) ([]APIKey, error) {
+	prefix := apiKeyKey("")
+	listCtx, cancel := withReadTimeout(ctx)
+	defer cancel()
+	storeKeys, err := getStore().Keys(listCtx, prefix)
+	if err != nil {
+		slog.ErrorContext(ctx, "failure getting the API keys from the store", "key", prefix+"*", "error", err)
+		return nil, err
+	}
+
+	fileKeysMu.RLock()
+	keys := make([]APIKey, 0, len(storeKeys)+len(fileKeys))
+	for _, key := range fileKeys {
+		keys = append(keys, key)
+	}
+	fileKeysMu.RUnlock()
+	for _, storeKey := range storeKeys {
+		key, err := getAPIKey(ctx, storeKey[len(prefix):])
+		if errors.Is(err, ErrNotFound) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	for i := range keys {
+		keys[i].Hash = ""
+	}
+	slices.SortFunc(keys, func(a, b APIKey) int {
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	return keys, nil
+}
+
+// RevokeAPIKey revokes an API key, which is kept so that the redirects it created can still be
+// traced to it, returning it without the hash of its secret. It returns ErrNotFound if the key
+// doesn't exist and ErrReadOnlyAPIKey if it was loaded from API_KEYS_FILE.
+func RevokeAPIKey(ctx context.Context, id string) (APIKey, error) {
+	if _, ok := fileKey(id); ok {
+		return APIKey{}, ErrReadOnlyAPIKey
+	}
+	key, err := getAPIKey(ctx, id)
+	if err != nil {
+		return APIKey{}, err
+	}
+	if key.RevokedAt.IsZero() {
+		key.RevokedAt = time.Now().UTC()
+		encoded, err := json.Marshal(key)
+		if err != nil {
+			return APIKey{}, err
+		}
+		writeCtx, cancel := withWriteTimeout(ctx)
+		defer cancel()
+		updated, err := getStore().Update(writeCtx, apiKeyKey(id), string(encoded), 0, true)
+		if err != nil {
+			slog.ErrorContext(ctx, "failure revoking the API key in the store", "api_key_id", id, "error", err)
+			return APIKey{}, err
+		}
+		if !updated {
+			return APIKey{}, ErrNotFound
+		}
+	}
+	key.Hash = ""
+	return key, nil
+}
@@ -0,0 +1,88 @@
+package records
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/luizcdc/redirectory/redirector/records/store"
+)
+
+func TestAPIKeys(t *testing.T) {
+	t.Setenv("RUNNING_ENV", "TEST")
+	ctx := context.Background()
+	s := store.NewMemoryStore(0)
+	UseStore(s)
+
+	key, token, err := CreateAPIKey(ctx, "ci", []string{SCOPE_LINKS_WRITE})
+	if err != nil {
+		t.Fatalf("CreateAPIKey returned error %v", err)
+	}
+	if !strings.HasPrefix(token, API_KEY_PREFIX+key.ID+"_") {
+		t.Errorf("CreateAPIKey returned key '%v' for ID '%v'", token, key.ID)
+	}
+	stored, err := s.Get(ctx, apiKeyKey(key.ID))
+	if err != nil || strings.Contains(stored, strings.TrimPrefix(token, API_KEY_PREFIX+key.ID+"_")) {
+		t.Errorf("the secret is stored in plain text: %v, %v", stored, err)
+	}
+
+	authenticated, err := AuthenticateAPIKey(ctx, token)
+	if err != nil || authenticated.ID != key.ID || !authenticated.HasScope(SCOPE_LINKS_WRITE) || authenticated.HasScope(SCOPE_LINKS_DELETE) {
+		t.Errorf("AuthenticateAPIKey = %+v, %v", authenticated, err)
+	}
+	for _, invalid := range []string{"", "secret", token + "0", API_KEY_PREFIX + "nope_" + strings.Repeat("0", 64)} {
+		if _, err := AuthenticateAPIKey(ctx, invalid); !errors.Is(err, ErrInvalidAPIKey) {
+			t.Errorf("AuthenticateAPIKey(%q) returned error %v, want ErrInvalidAPIKey", invalid, err)
+		}
+	}
+
+	revoked, err := RevokeAPIKey(ctx, key.ID)
+	if err != nil || revoked.RevokedAt.IsZero() || revoked.Hash != "" {
+		t.Errorf("RevokeAPIKey = %+v, %v", revoked, err)
+	}
+	if _, err := AuthenticateAPIKey(ctx, token); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("AuthenticateAPIKey returned error %v for a revoked key, want ErrInvalidAPIKey", err)
+	}
+	if _, err := RevokeAPIKey(ctx, "nope"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("RevokeAPIKey(nope) returned error %v, want ErrNotFound", err)
+	}
+
+	keys, err := ListAPIKeys(ctx)
+	if err != nil || len(keys) != 1 || keys[0].ID != key.ID || keys[0].Hash != "" || keys[0].RevokedAt.IsZero() {
+		t.Errorf("ListAPIKeys = %+v, %v", keys, err)
+	}
+}
+
+func TestLoadAPIKeysFile(t *testing.T) {
+	t.Setenv("RUNNING_ENV", "TEST")
+	t.Cleanup(func() { fileKeys = map[string]APIKey{} })
+	ctx := context.Background()
+	UseStore(store.NewMemoryStore(0))
+
+	path := filepath.Join(t.TempDir(), "keys.json")
+	content := `[{"id": "deploy", "name": "deploy", "scopes": ["links:read"], "hash": "` + hashSecret("hunter2") + `"}]`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadAPIKeysFile(path); err != nil {
+		t.Fatalf("LoadAPIKeysFile returned error %v", err)
+	}
+	key, err := AuthenticateAPIKey(ctx, API_KEY_PREFIX+"deploy_hunter2")
+	if err != nil || key.ID != "deploy" || !key.HasScope(SCOPE_LINKS_READ) {
+		t.Errorf("AuthenticateAPIKey = %+v, %v", key, err)
+	}
+	if _, err := RevokeAPIKey(ctx, "deploy"); !errors.Is(err, ErrReadOnlyAPIKey) {
+		t.Errorf("RevokeAPIKey(deploy) returned error %v, want ErrReadOnlyAPIKey", err)
+	}
+
+	content = `[{"id": "deploy", "name": "deploy", "scopes": ["links:everything"], "hash": "` + hashSecret("hunter2") + `"}]`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadAPIKeysFile(path); err == nil {
+		t.Error("LoadAPIKeysFile accepted an unknown scope")
+	}
+}
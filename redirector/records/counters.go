@@ -2,60 +2,114 @@ package records
 
 import (
 	"context"
+	"errors"
+	"log/slog"
+	"strconv"
+	"time"
 
-	redis_client "github.com/luizcdc/redirectory/redirector/records/redis_client_singleton"
+	"github.com/luizcdc/redirectory/redirector/records/store"
 )
 
-// incrCountURLsSet increments the count of all URLs ever set.
+const countURLsSetKey = "count_urls_set"
+const countServedRedirectsKey = "count_served_redirects"
+
+// isCounterKey indicates whether an unprefixed key holds one of the global counters rather than
+// a redirect.
+func isCounterKey(key string) bool {
+	return key == countURLsSetKey || key == countServedRedirectsKey
+}
+
+// incrCountURLsSet increments the count of all URLs ever set, once the counters are flushed.
 func incrCountURLsSet() {
-	client, err := redis_client.GetClientInstance()
-	if err != nil {
-		return
-	}
-	client.Incr(context.TODO(), AddPrefix("count_urls_set"))
+	aggregator.incr(AddPrefix(countURLsSetKey), 1)
 }
 
 // GetCountURLsSet retrieves the count of all URLs ever set.
-func GetCountURLsSet() (int64, error) {
-	client, err := redis_client.GetClientInstance()
-	if err != nil {
-		return 0, err
-	}
-	return client.Get(context.TODO(), AddPrefix("count_urls_set")).Int64()
+func GetCountURLsSet(ctx context.Context) (int64, error) {
+	return getCount(ctx, AddPrefix(countURLsSetKey))
 }
 
 // clearCountURLsSet clears the count of all URLs ever set.
-func clearCountURLsSet() {
-	client, err := redis_client.GetClientInstance()
-	if err != nil {
-		return
-	}
-	client.Set(context.TODO(), AddPrefix("count_urls_set"), 0, 0)
+func clearCountURLsSet(ctx context.Context) {
+	setValue(ctx, countURLsSetKey, "0", 0)
 }
 
-// IncrCountServedRedirects increments the count of all redirects ever served.
-func IncrCountServedRedirects() {
-	client, err := redis_client.GetClientInstance()
-	if err != nil {
-		return
-	}
-	client.Incr(context.TODO(), AddPrefix("count_served_redirects"))
+// IncrCountServedRedirects increments the count of all redirects ever served, as well as the
+// count of hits of the redirect that was served, once the counters are flushed.
+func IncrCountServedRedirects(key string) {
+	aggregator.incr(AddPrefix(countServedRedirectsKey), 1)
+	aggregator.incr(hitsKey(key), 1)
 }
 
 // GetCountServedRedirects retrieves the count of all redirects ever served.
-func GetCountServedRedirects() (int64, error) {
-	client, err := redis_client.GetClientInstance()
+func GetCountServedRedirects(ctx context.Context) (int64, error) {
+	return getCount(ctx, AddPrefix(countServedRedirectsKey))
+}
+
+// clearCountServedRedirects clears the count of all redirects ever served.
+func clearCountServedRedirects(ctx context.Context) {
+	setValue(ctx, countServedRedirectsKey, "0", 0)
+}
+
+// hitsKey returns the key of the counter of hits of a redirect.
+func hitsKey(key string) string {
+	return addInternalPrefix("hits:" + key)
+}
+
+// GetCountHits retrieves the count of hits of a redirect, which is 0 if it was never served.
+func GetCountHits(ctx context.Context, key string) (int64, error) {
+	count, err := getCount(ctx, hitsKey(key))
+	if errors.Is(err, store.ErrNotFound) {
+		return 0, nil
+	}
+	return count, err
+}
+
+// clearHits clears the counts of hits and the analytics of redirects, along with their
+// increments that weren't flushed yet.
+func clearHits(ctx context.Context, keys []string) {
+	daysKeys := make([]string, len(keys))
+	cleared := make([]string, 0, 2*len(keys))
+	for i, key := range keys {
+		daysKeys[i] = statsDaysKey(key)
+		cleared = append(cleared, hitsKey(key), daysKeys[i])
+	}
+	readCtx, cancel := withReadTimeout(ctx)
+	defer cancel()
+	hitDays, err := getStore().HGetAllMany(readCtx, daysKeys)
 	if err != nil {
-		return 0, err
+		slog.ErrorContext(ctx, "failure getting the days with analytics from the store", "redirects", len(keys), "error", err)
+	}
+	for i, key := range keys {
+		days := aggregator.pendingFields(daysKeys[i])
+		for day := range hitDays[i] {
+			days = append(days, day)
+		}
+		for _, day := range days {
+			if parsed, err := time.Parse(statsDayLayout, day); err == nil {
+				cleared = append(cleared, statsKey(key, parsed))
+			}
+		}
+	}
+	aggregator.forget(cleared)
+
+	writeCtx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+	if _, err := getStore().DelMany(writeCtx, cleared); err != nil {
+		slog.ErrorContext(ctx, "failure deleting the hits of the redirects from the store", "redirects", len(keys), "error", err)
 	}
-	return client.Get(context.TODO(), AddPrefix("count_served_redirects")).Int64()
 }
 
-// clearCountServedRedirects clears the count of all redirects ever served.
-func clearCountServedRedirects() {
-	client, err := redis_client.GetClientInstance()
+// getCount retrieves the integer value of a counter.
+func getCount(ctx context.Context, key string) (int64, error) {
+	ctx, cancel := withReadTimeout(ctx)
+	defer cancel()
+	value, err := getStore().Get(ctx, key)
 	if err != nil {
-		return
+		if !errors.Is(err, store.ErrNotFound) {
+			slog.ErrorContext(ctx, "failure getting the counter from the store", "key", key, "error", err)
+		}
+		return 0, err
 	}
-	client.Set(context.TODO(), AddPrefix("count_served_redirects"), 0, 0)
-}
\ No newline at end of file
+	return strconv.ParseInt(value, 10, 64)
+}
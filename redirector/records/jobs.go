@@ -0,0 +1,309 @@
+package records
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+)
+
+// The statuses of a DeleteJob.
+const (
+	JOB_RUNNING  = "running"
+	JOB_DONE     = "done"
+	JOB_CANCELED = "canceled"
+	JOB_FAILED   = "failed"
+)
+
+// JOB_RETENTION is how long a job is kept in the store after it was last updated.
+const JOB_RETENTION = 24 * time.Hour
+
+// JOB_BATCH_SIZE is how many keys a job scans, and deletes the matching ones of, at once.
+const JOB_BATCH_SIZE = 500
+
+// JOB_CANCEL_POLL_INTERVAL is how often a job checks in the store whether another instance
+// canceled it, the instance running it canceling it right away.
+const JOB_CANCEL_POLL_INTERVAL = time.Second
+
+// ErrEmptyFilter is returned when starting a DeleteJob whose filter would match every redirect.
+var ErrEmptyFilter = errors.New("the filter must have at least one criterion")
+
+// errShutdown cancels the jobs running when the records are closed.
+var errShutdown = errors.New("the server shut down")
+
+// DeleteFilter selects the redirects deleted by a DeleteJob, which must match every criterion
+// that isn't empty.
+type DeleteFilter struct {
+	Prefix    string `json:"prefix"`
+	Tag       string `json:"tag"`
+	CreatedBy string `json:"created_by"`
+	// CreatedBefore doesn't match legacy redirects, whose creation time is unknown.
+	CreatedBefore time.Time `json:"created_before"`
+}
+
+// isEmpty indicates whether the filter has no criteria, so it would match every redirect.
+func (f DeleteFilter) isEmpty() bool {
+	return f.Prefix == "" && !f.needsRecords()
+}
+
+// needsRecords indicates whether the filter has criteria on the records, besides the prefix of
+// their keys.
+func (f DeleteFilter) needsRecords() bool {
+	return f.Tag != "" || f.CreatedBy != "" || !f.CreatedBefore.IsZero()
+}
+
+// matches indicates whether a record matches the criteria of the filter besides the prefix.
+func (f DeleteFilter) matches(record Record) bool {
+	switch {
+	case f.Tag != "" && !slices.Contains(record.Tags, f.Tag):
+		return false
+	case f.CreatedBy != "" && record.CreatedBy != f.CreatedBy:
+		return false
+	case !f.CreatedBefore.IsZero() && (record.CreatedAt.IsZero() || !record.CreatedAt.Before(f.CreatedBefore)):
+		return false
+	}
+	return true
+}
+
+// DeleteJob deletes the redirects matching a filter in the background: it scans the keys in
+// batches, deleting the matching ones of each batch before scanning the next. Its progress is
+// kept in the store, so that every instance can report it.
+type DeleteJob struct {
+	ID     string       `json:"id"`
+	Filter DeleteFilter `json:"filter"`
+	Status string       `json:"status"`
+	// Scanned is the number of keys scanned, Matched the number of them matching the filter and
+	// Deleted the number of those deleted so far.
+	Scanned int64 `json:"scanned"`
+	Matched int64 `json:"matched"`
+	Deleted int64 `json:"deleted"`
+	// CancelRequested indicates whether the job was asked to stop, which it does before its next
+	// batch.
+	CancelRequested bool      `json:"cancel_requested"`
+	Error           string    `json:"error,omitempty"`
+	StartedAt       time.Time `json:"started_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+	// FinishedAt is the zero value while the job is running.
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+// runningJobs cancels the jobs running in this process, by ID, and jobsDone waits for them.
+var runningJobs = map[string]context.CancelCauseFunc{}
+var runningJobsMu sync.Mutex
+var jobsDone sync.WaitGroup
+
+// jobKey returns the key of a job in the store, and jobCancelKey the key set to cancel it.
+func jobKey(id string) string {
+	return addInternalPrefix("job:" + id)
+}
+
+func jobCancelKey(id string) string {
+	return addInternalPrefix("job:" + id + ":cancel")
+}
+
+// saveJob writes the progress of a job to the store.
+func saveJob(ctx context.Context, job *DeleteJob) error {
+	job.UpdatedAt = time.Now().UTC()
+	encoded, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+	err = getStore().Set(ctx, jobKey(job.ID), string(encoded), JOB_RETENTION)
+	if err != nil {
+		slog.ErrorContext(ctx, "failure saving the job in the store", "job_id", job.ID, "error", err)
+	}
+	return err
+}
+
+// StartDeleteJob starts deleting the redirects matching filter in the background, returning the
+// job doing it. The job outlives ctx, but keeps its values.
+func StartDeleteJob(ctx context.Context, filter DeleteFilter) (DeleteJob, error) {
+	if filter.isEmpty() {
+		return DeleteJob{}, ErrEmptyFilter
+	}
+	now := time.Now().UTC()
+	job := DeleteJob{ID: randomHex(8), Filter: filter, Status: JOB_RUNNING, StartedAt: now}
+	if err := saveJob(ctx, &job); err != nil {
+		return DeleteJob{}, err
+	}
+
+	jobCtx, cancel := context.WithCancelCause(context.WithoutCancel(ctx))
+	runningJobsMu.Lock()
+	runningJobs[job.ID] = cancel
+	runningJobsMu.Unlock()
+	jobsDone.Add(1)
+	go runDeleteJob(jobCtx, job)
+	slog.InfoContext(ctx, "started delete job", "job_id", job.ID, "filter", filter)
+	return job, nil
+}
+
+// runDeleteJob runs a job until it's done, fails or is canceled.
+func runDeleteJob(ctx context.Context, job DeleteJob) {
+	defer jobsDone.Done()
+	defer func() {
+		runningJobsMu.Lock()
+		if cancel, ok := runningJobs[job.ID]; ok {
+			cancel(nil)
+			delete(runningJobs, job.ID)
+		}
+		runningJobsMu.Unlock()
+	}()
+
+	var cursor uint64
+	var polled time.Time
+	for {
+		if ctx.Err() != nil {
+			finishJob(ctx, &job, nil)
+			return
+		}
+		if time.Since(polled) >= JOB_CANCEL_POLL_INTERVAL {
+			polled = time.Now()
+			if canceled(ctx, job.ID) {
+				finishJob(ctx, &job, nil)
+				return
+			}
+		}
+		keys, next, err := ListKeys(ctx, cursor, job.Filter.Prefix, "", JOB_BATCH_SIZE)
+		if err != nil {
+			finishJob(ctx, &job, err)
+			return
+		}
+		job.Scanned += int64(len(keys))
+		matched := []string{}
+		for _, key := range keys {
+			if !job.Filter.needsRecords() {
+				matched = append(matched, key)
+				continue
+			}
+			record, err := GetRecord(ctx, key)
+			if errors.Is(err, ErrNotFound) {
+				continue
+			} else if err != nil {
+				finishJob(ctx, &job, err)
+				return
+			}
+			if job.Filter.matches(record) {
+				matched = append(matched, key)
+			}
+		}
+		job.Matched += int64(len(matched))
+		if len(matched) > 0 {
+			deleted, err := DelKeys(ctx, matched)
+			for _, d := range deleted {
+				if d {
+					job.Deleted++
+				}
+			}
+			if err != nil {
+				finishJob(ctx, &job, err)
+				return
+			}
+		}
+		saveJob(ctx, &job)
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	finishJob(ctx, &job, nil)
+}
+
+// canceled indicates whether a job was canceled, either in this process or, through the store,
+// by any instance.
+func canceled(ctx context.Context, id string) bool {
+	if ctx.Err() != nil {
+		return true
+	}
+	readCtx, cancel := withReadTimeout(ctx)
+	defer cancel()
+	_, err := getStore().Get(readCtx, jobCancelKey(id))
+	return err == nil
+}
+
+// finishJob records that a job stopped because it was canceled, because it failed with err, if
+// it isn't nil, or because it was done. Canceling ctx interrupts the operations of the job, so
+// their errors don't make it fail.
+func finishJob(ctx context.Context, job *DeleteJob, err error) {
+	switch {
+	case context.Cause(ctx) != nil:
+		job.Status = JOB_CANCELED
+		if errors.Is(context.Cause(ctx), errShutdown) {
+			job.Error = errShutdown.Error()
+		}
+	case err != nil:
+		job.Status = JOB_FAILED
+		job.Error = err.Error()
+	case canceled(ctx, job.ID):
+		job.Status = JOB_CANCELED
+	default:
+		job.Status = JOB_DONE
+	}
+	job.FinishedAt = time.Now().UTC()
+	ctx = context.WithoutCancel(ctx)
+	saveJob(ctx, job)
+	slog.InfoContext(ctx, "finished delete job", "job_id", job.ID, "status", job.Status,
+		"scanned", job.Scanned, "matched", job.Matched, "deleted", job.Deleted, "error", job.Error)
+}
+
+// GetDeleteJob retrieves a job from the store, returning ErrNotFound if it doesn't exist or was
+// finished for longer than JOB_RETENTION.
+func GetDeleteJob(ctx context.Context, id string) (DeleteJob, error) {
+	readCtx, cancel := withReadTimeout(ctx)
+	defer cancel()
+	encoded, err := getStore().Get(readCtx, jobKey(id))
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			slog.ErrorContext(ctx, "failure getting the job from the store", "job_id", id, "error", err)
+		}
+		return DeleteJob{}, err
+	}
+	var job DeleteJob
+	if err := json.Unmarshal([]byte(encoded), &job); err != nil {
+		return DeleteJob{}, fmt.Errorf("failure decoding job '%v': %w", id, err)
+	}
+	if job.Status == JOB_RUNNING {
+		job.CancelRequested = canceled(ctx, id)
+	}
+	return job, nil
+}
+
+// CancelDeleteJob asks a running job to stop before its next batch, whichever instance runs it
+// (within JOB_CANCEL_POLL_INTERVAL for the other instances), returning the job. Jobs that already stopped are left as they are.
+func CancelDeleteJob(ctx context.Context, id string) (DeleteJob, error) {
+	job, err := GetDeleteJob(ctx, id)
+	if err != nil || job.Status != JOB_RUNNING {
+		return job, err
+	}
+	writeCtx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+	if err := getStore().Set(writeCtx, jobCancelKey(id), "1", JOB_RETENTION); err != nil {
+		slog.ErrorContext(ctx, "failure canceling the job in the store", "job_id", id, "error", err)
+		return DeleteJob{}, err
+	}
+
+	runningJobsMu.Lock()
+	if cancelJob, ok := runningJobs[id]; ok {
+		cancelJob(nil)
+	}
+	runningJobsMu.Unlock()
+	job.CancelRequested = true
+	slog.InfoContext(ctx, "canceled delete job", "job_id", id)
+	return job, nil
+}
+
+// stopJobs cancels the jobs running in this process, waiting for them to record that they
+// stopped.
+func stopJobs() {
+	runningJobsMu.Lock()
+	for _, cancel := range runningJobs {
+		cancel(errShutdown)
+	}
+	runningJobsMu.Unlock()
+	jobsDone.Wait()
+}
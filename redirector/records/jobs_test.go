@@ -0,0 +1,176 @@
+package records
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/luizcdc/redirectory/redirector/records/store"
+)
+
+func TestDeleteJob(t *testing.T) {
+	t.Setenv("RUNNING_ENV", "TEST")
+	ctx := context.Background()
+	UseStore(store.NewMemoryStore(0))
+
+	old := NewRecord("https://example.com/old", 0)
+	old.Tags = []string{"campaign"}
+	old.CreatedAt = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	recent := old
+	recent.CreatedAt = time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	untagged := NewRecord("https://example.com/untagged", 0)
+	untagged.CreatedAt = old.CreatedAt
+	for key, record := range map[string]Record{"camp-old": old, "camp-recent": recent, "camp-untagged": untagged, "old": old} {
+		if err := SetRecord(ctx, key, record); err != nil {
+			t.Fatalf("SetRecord(%v) returned error %v", key, err)
+		}
+	}
+	GetRecord(ctx, "camp-old")
+
+	if _, err := StartDeleteJob(ctx, DeleteFilter{}); !errors.Is(err, ErrEmptyFilter) {
+		t.Errorf("StartDeleteJob with an empty filter returned error %v, want ErrEmptyFilter", err)
+	}
+	filter := DeleteFilter{Prefix: "camp-", Tag: "campaign", CreatedBefore: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)}
+	job, err := StartDeleteJob(ctx, filter)
+	if err != nil || job.Status != JOB_RUNNING {
+		t.Fatalf("StartDeleteJob = %+v, %v", job, err)
+	}
+	jobsDone.Wait()
+
+	job, err = GetDeleteJob(ctx, job.ID)
+	if err != nil || job.Status != JOB_DONE || job.Scanned != 3 || job.Matched != 1 || job.Deleted != 1 || job.FinishedAt.IsZero() {
+		t.Fatalf("GetDeleteJob = %+v, %v, want a done job that deleted camp-old", job, err)
+	}
+	if _, err := GetRecord(ctx, "camp-old"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetRecord(camp-old) returned error %v after it was deleted, want ErrNotFound", err)
+	}
+	for _, key := range []string{"camp-recent", "camp-untagged", "old"} {
+		if _, err := GetRecord(ctx, key); err != nil {
+			t.Errorf("GetRecord(%v) returned error %v, but it doesn't match the filter", key, err)
+		}
+	}
+	if canceled, err := CancelDeleteJob(ctx, job.ID); err != nil || canceled.Status != JOB_DONE || canceled.CancelRequested {
+		t.Errorf("CancelDeleteJob on a done job = %+v, %v, want it unchanged", canceled, err)
+	}
+	if _, err := GetDeleteJob(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetDeleteJob(missing) returned error %v, want ErrNotFound", err)
+	}
+}
+
+// countingStore is a MemoryStore that counts the reads of the keys canceling jobs.
+type countingStore struct {
+	*store.MemoryStore
+	cancelReads *atomic.Int64
+}
+
+func (s countingStore) Get(ctx context.Context, key string) (string, error) {
+	if strings.HasSuffix(key, ":cancel") {
+		s.cancelReads.Add(1)
+	}
+	return s.MemoryStore.Get(ctx, key)
+}
+
+func TestDeleteJobManyBatches(t *testing.T) {
+	t.Setenv("RUNNING_ENV", "TEST")
+	ctx := context.Background()
+	s := countingStore{store.NewMemoryStore(0), &atomic.Int64{}}
+	UseStore(s)
+	const count = 2*JOB_BATCH_SIZE + 100
+	for i := range count {
+		SetRecord(ctx, fmt.Sprintf("bulk-%04d", i), NewRecord("https://example.com", 0))
+	}
+	SetRecord(ctx, "kept", NewRecord("https://example.com", 0))
+
+	job, err := StartDeleteJob(ctx, DeleteFilter{Prefix: "bulk-"})
+	if err != nil {
+		t.Fatalf("StartDeleteJob returned error %v", err)
+	}
+	jobsDone.Wait()
+
+	// The keys deleted with each batch don't make the scan skip the following ones.
+	job, err = GetDeleteJob(ctx, job.ID)
+	if err != nil || job.Status != JOB_DONE || job.Scanned != count || job.Matched != count || job.Deleted != count {
+		t.Errorf("GetDeleteJob = %+v, %v, want a done job that deleted %v redirects", job, err, count)
+	}
+	if keys, _, _ := ListKeys(ctx, 0, "", "", 10*count); len(keys) != 1 || keys[0] != "kept" {
+		t.Errorf("the redirects left are %v, want only kept", keys)
+	}
+	// The store is polled for the cancellation when the job starts and finishes, and
+	// periodically rather than for each batch.
+	if reads := s.cancelReads.Load(); reads >= 3 {
+		t.Errorf("the job read its cancel key %v times, want it polled less than once per batch", reads)
+	}
+}
+
+func TestCancelDeleteJob(t *testing.T) {
+	t.Setenv("RUNNING_ENV", "TEST")
+	ctx := context.Background()
+	UseStore(store.NewMemoryStore(0))
+	SetRecord(ctx, "docs", NewRecord("https://example.com", 0))
+
+	// The job is saved as running without being started, so that it's canceled before it runs.
+	job := DeleteJob{ID: "canceled", Filter: DeleteFilter{Prefix: "docs"}, Status: JOB_RUNNING}
+	if err := saveJob(ctx, &job); err != nil {
+		t.Fatalf("saveJob returned error %v", err)
+	}
+	if canceled, err := CancelDeleteJob(ctx, job.ID); err != nil || !canceled.CancelRequested {
+		t.Fatalf("CancelDeleteJob = %+v, %v, want the cancellation requested", canceled, err)
+	}
+	jobsDone.Add(1)
+	runDeleteJob(ctx, job)
+
+	job, err := GetDeleteJob(ctx, job.ID)
+	if err != nil || job.Status != JOB_CANCELED || job.Deleted != 0 {
+		t.Errorf("GetDeleteJob = %+v, %v, want a canceled job that deleted nothing", job, err)
+	}
+	if _, err := GetRecord(ctx, "docs"); err != nil {
+		t.Errorf("GetRecord(docs) returned error %v after the job was canceled", err)
+	}
+}
+
+// blockingStore is a MemoryStore whose scans block until their context is canceled, like those
+// of a remote store interrupted by the cancellation.
+type blockingStore struct {
+	*store.MemoryStore
+	scanning chan struct{}
+}
+
+func (s blockingStore) Scan(ctx context.Context, cursor uint64, match string, count int64) ([]string, uint64, error) {
+	s.scanning <- struct{}{}
+	<-ctx.Done()
+	return nil, 0, ctx.Err()
+}
+
+func TestDeleteJobCanceledMidOperation(t *testing.T) {
+	t.Setenv("RUNNING_ENV", "TEST")
+	ctx := context.Background()
+	for _, test := range []struct {
+		name      string
+		cancel    func(id string)
+		wantError string
+	}{
+		{"canceled", func(id string) { CancelDeleteJob(ctx, id) }, ""},
+		{"shutdown", func(string) { stopJobs() }, errShutdown.Error()},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			s := blockingStore{store.NewMemoryStore(0), make(chan struct{})}
+			UseStore(s)
+			job, err := StartDeleteJob(ctx, DeleteFilter{Prefix: "docs"})
+			if err != nil {
+				t.Fatalf("StartDeleteJob returned error %v", err)
+			}
+			<-s.scanning
+			test.cancel(job.ID)
+			jobsDone.Wait()
+
+			job, err = GetDeleteJob(ctx, job.ID)
+			if err != nil || job.Status != JOB_CANCELED || job.Error != test.wantError {
+				t.Errorf("GetDeleteJob = %+v, %v, want a job canceled with error %q", job, err, test.wantError)
+			}
+		})
+	}
+}
@@ -14,6 +14,18 @@ type LRUCache struct {
 	hashmap    map[string]*node
 	// The most recently used
 	lru_head *node
+	// Counters of fetches that found a value, fetches that didn't, and least recently used
+	// entries dropped.
+	hits, misses, evictions uint64
+}
+
+// Stats are the counters of the usage of an LRUCache since it was created.
+type Stats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	// Len is the number of entries currently in the cache.
+	Len int
 }
 
 // node is a doubly-linked list node.
@@ -268,6 +280,7 @@ func (cache *LRUCache) Remove(key string) {
 func (cache *LRUCache) dropLRU() {
 	key := cache.lru_head.previous.key
 	cache.remove(key)
+	cache.evictions++
 }
 
 // DropLRU removes the least recently used entry.
@@ -289,14 +302,17 @@ func (cache *LRUCache) DropLRU() {
 func (cache *LRUCache) fetch(key string) (value interface{}, ok bool) {
 	node, ok := cache.hashmap[key]
 	if !ok {
+		cache.misses++
 		return
 	}
 	if time.Since(node.last_updated) > cache.expiration {
 		cache.remove(key)
+		cache.misses++
 		ok = false
 		return
 	}
 	cache.hit(key)
+	cache.hits++
 	value = node.value
 	return
 }
@@ -314,3 +330,16 @@ func (cache *LRUCache) Fetch(key string) (value interface{}, ok bool) {
 
 	return cache.fetch(key)
 }
+
+// Stats returns the counters of the usage of the cache and its current length.
+// Time complexity: O(1)
+func (cache *LRUCache) Stats() Stats {
+	if cache == nil {
+		return Stats{}
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	return Stats{Hits: cache.hits, Misses: cache.misses, Evictions: cache.evictions, Len: cache.len()}
+}
@@ -202,3 +202,22 @@ func TestDropLRU(t *testing.T) {
 		t.Error("DropLRU did not remove the correct LRU entry")
 	}
 }
+
+func TestStats(t *testing.T) {
+	const size uint = 2
+	c := NewCache(size, 1e12)
+	c.Insert("a", "a")
+	c.Fetch("a")
+	c.Fetch("b")
+	c.Insert("b", "b")
+	c.Insert("c", "c")
+	want := Stats{Hits: 1, Misses: 1, Evictions: 1, Len: 2}
+	if got := c.Stats(); got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+
+	var nilCache *LRUCache
+	if got := nilCache.Stats(); got != (Stats{}) {
+		t.Errorf("Stats() of a nil cache = %+v, want zero", got)
+	}
+}
@@ -1,6 +1,7 @@
 package unique_random_strings
 
 import (
+	"context"
 	"math"
 	"math/rand"
 	"runtime"
@@ -81,7 +82,7 @@ func (gen *Generator) populate() {
 }
 
 func (gen *Generator) getUsedFromRedis() map[string]struct{} {
-	keys, err := records.GetAllKeys()
+	keys, err := records.GetAllKeys(context.Background())
 	if err != nil {
 		return map[string]struct{}{}
 	}
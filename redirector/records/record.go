@@ -0,0 +1,91 @@
+package records
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Record is a redirect as it is stored, along with the metadata of its creation.
+type Record struct {
+	// URL is the target of the redirect.
+	URL       string    `json:"url"`
+	CreatedAt time.Time `json:"created_at"`
+	// ExpiresAt is the zero value if the redirect never expires.
+	ExpiresAt time.Time `json:"expires_at"`
+	// Duration is the originally requested duration of the redirect, in seconds.
+	Duration uint `json:"duration"`
+	// CreatedBy identifies the API key used to create the redirect.
+	CreatedBy string   `json:"created_by"`
+	Tags      []string `json:"tags"`
+	// StatusCode is the HTTP status code used when serving the redirect.
+	StatusCode int    `json:"status_code"`
+	Notes      string `json:"notes"`
+}
+
+// DEFAULT_STATUS_CODE is the status code of redirects that don't specify one.
+const DEFAULT_STATUS_CODE = http.StatusTemporaryRedirect
+
+// NewRecord creates a record for a redirect to url that is created now and expires after
+// duration seconds (never, if duration is 0).
+func NewRecord(url string, duration uint) Record {
+	now := time.Now().UTC()
+	record := Record{
+		URL:        url,
+		CreatedAt:  now,
+		Duration:   duration,
+		Tags:       []string{},
+		StatusCode: DEFAULT_STATUS_CODE,
+	}
+	if duration > 0 {
+		record.ExpiresAt = now.Add(time.Duration(duration) * time.Second)
+	}
+	return record
+}
+
+// IsRedirectStatusCode indicates whether code can be used as the status code of a redirect.
+func IsRedirectStatusCode(code int) bool {
+	switch code {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// TTL returns the time left until the record expires, 0 meaning it never does.
+func (record Record) TTL() time.Duration {
+	if record.ExpiresAt.IsZero() {
+		return 0
+	}
+	return max(time.Until(record.ExpiresAt), time.Second)
+}
+
+// ETag returns an entity tag identifying the current version of the record, to be used in
+// If-Match preconditions.
+func (record Record) ETag() string {
+	encoded, _ := encodeRecord(record)
+	sum := sha256.Sum256([]byte(encoded))
+	return fmt.Sprintf(`"%x"`, sum[:8])
+}
+
+// encodeRecord serializes a record into the value kept in the store.
+func encodeRecord(record Record) (string, error) {
+	encoded, err := json.Marshal(record)
+	return string(encoded), err
+}
+
+// decodeRecord deserializes a value kept in the store, also returning whether it is a legacy
+// value: a bare target URL, as stored before records were structured.
+func decodeRecord(value string) (record Record, legacy bool) {
+	if strings.HasPrefix(value, "{") && json.Unmarshal([]byte(value), &record) == nil {
+		if record.StatusCode == 0 {
+			record.StatusCode = DEFAULT_STATUS_CODE
+		}
+		return record, false
+	}
+	return Record{URL: value, Tags: []string{}, StatusCode: DEFAULT_STATUS_CODE}, true
+}
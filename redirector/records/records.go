@@ -7,23 +7,146 @@ package records
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"log/slog"
 	"os"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/luizcdc/redirectory/redirector/records/lru_cache"
-	redis_client "github.com/luizcdc/redirectory/redirector/records/redis_client_singleton"
+	"github.com/luizcdc/redirectory/redirector/records/store"
+	"github.com/luizcdc/redirectory/redirector/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var cache *lru_cache.LRUCache
 
+// ErrNotFound is returned when the requested redirect doesn't exist or has expired.
+var ErrNotFound = store.ErrNotFound
+
+// ErrInvalidCursor is returned when listing the keys from a cursor the store doesn't know.
+var ErrInvalidCursor = store.ErrInvalidCursor
+
+// ErrAtomicUnsupported is returned when creating many records atomically in a store that can't.
+var ErrAtomicUnsupported = store.ErrAtomicUnsupported
+
+// backend is the Store holding every record, chosen from the STORE_BACKEND environment variable
+// on first use unless one was provided through UseStore.
+var backend store.Store
+var backendMu sync.Mutex
+
+// readTimeout and writeTimeout bound how long each operation reading from or writing to the store
+// may take, 0 meaning it is only bound by the caller's context.
+var readTimeout, writeTimeout time.Duration
+
+// SetTimeouts sets how long each operation reading from or writing to the store may take before
+// it fails with context.DeadlineExceeded, 0 meaning it is only bound by the caller's context. It
+// should be called before the records are used.
+func SetTimeouts(read time.Duration, write time.Duration) {
+	readTimeout, writeTimeout = read, write
+}
+
+// withReadTimeout derives a context for an operation reading from the store.
+func withReadTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if readTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, readTimeout)
+}
+
+// withWriteTimeout derives a context for an operation writing to the store.
+func withWriteTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if writeTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, writeTimeout)
+}
+
+// UseStore sets the Store backing all records, replacing the one currently in use.
+func UseStore(s store.Store) {
+	backendMu.Lock()
+	defer backendMu.Unlock()
+	backend = s
+}
+
+// getStore returns the Store backing all records, instantiating it from the STORE_BACKEND
+// environment variable ("redis", "memory" or "file", defaulting to "redis") if necessary.
+func getStore() store.Store {
+	backendMu.Lock()
+	defer backendMu.Unlock()
+	if backend == nil {
+		switch os.Getenv("STORE_BACKEND") {
+		case "memory":
+			slog.Warn("using the in-memory store, records will be lost when the process exits")
+			backend = store.NewMemoryStore(storeSweepInterval())
+		case "file":
+			backend = newFileStore()
+		case "", "redis":
+			backend = store.NewRedisStore()
+		default:
+			slog.Error("unknown STORE_BACKEND, falling back to redis", "backend", os.Getenv("STORE_BACKEND"))
+			backend = store.NewRedisStore()
+		}
+	}
+	return backend
+}
+
+// Close stops the running delete jobs and the periodic flushes of the counters after flushing
+// the pending increments, then closes the Store backing all records. A new Store is instantiated
+// if records are used again.
+func Close() error {
+	stopJobs()
+	StopCounterFlusher()
+
+	backendMu.Lock()
+	defer backendMu.Unlock()
+	if backend == nil {
+		return nil
+	}
+	err := backend.Close()
+	backend = nil
+	return err
+}
+
+// Ping checks that the Store backing all records can be reached.
+func Ping(ctx context.Context) error {
+	ctx, cancel := withReadTimeout(ctx)
+	defer cancel()
+	return getStore().Ping(ctx)
+}
+
+// storeSweepInterval returns how often the memory and file stores sweep expired keys, from
+// STORE_SWEEP_INTERVAL_SECONDS.
+func storeSweepInterval() time.Duration {
+	sweepSeconds, err := strconv.Atoi(os.Getenv("STORE_SWEEP_INTERVAL_SECONDS"))
+	if err != nil {
+		log.Fatalf("failure reading STORE_SWEEP_INTERVAL_SECONDS into an int: %v", err.Error())
+	}
+	return time.Duration(sweepSeconds) * time.Second
+}
+
+// newFileStore opens the file store at STORE_FILE_PATH, sweeping expired keys every
+// STORE_SWEEP_INTERVAL_SECONDS.
+func newFileStore() store.Store {
+	fileStore, err := store.NewBoltStore(os.Getenv("STORE_FILE_PATH"), storeSweepInterval())
+	if err != nil {
+		log.Fatalf("failure opening the file store: %v", err.Error())
+	}
+	slog.Info("using the file store", "file", os.Getenv("STORE_FILE_PATH"))
+	return fileStore
+}
+
 // MakeCache initializes the local cache with the specified capacity.
 func MakeCache(cap uint) {
 	internal_cache_expire_seconds, err := strconv.Atoi(os.Getenv("INTERNAL_CACHE_EXPIRE_SECONDS"))
 	if err != nil {
-		log.Println("Error reading INTERNAL_CACHE_EXPIRE_SECONDS environment variable. Could not create the cache.")
+		slog.Error("failure reading INTERNAL_CACHE_EXPIRE_SECONDS, the cache wasn't created", "error", err)
 		return
 	}
 	if cache == nil {
@@ -33,6 +156,17 @@ func MakeCache(cap uint) {
 	}
 }
 
+// CacheInitialized indicates whether the local cache was initialized by MakeCache.
+func CacheInitialized() bool {
+	return cache != nil
+}
+
+// CacheStats returns the counters of the usage of the local cache, which are all 0 if it wasn't
+// initialized.
+func CacheStats() lru_cache.Stats {
+	return cache.Stats()
+}
+
 // ResetCache resets the cache to its initial state, preserving the same capacity.
 func ResetCache() {
 	currCap := cache.Cap()
@@ -40,40 +174,189 @@ func ResetCache() {
 	MakeCache(currCap)
 }
 
-// SetKey returns a bool indicating success of the specified set operation.
-func SetKey(key string, value interface{}, ttl time.Duration) bool {
-	client, err := redis_client.GetClientInstance()
+// SetRecord sets the record for the specified key, expiring at the record's ExpiresAt, returning
+// an error if it failed.
+func SetRecord(ctx context.Context, key string, record Record) error {
+	value, err := encodeRecord(record)
 	if err != nil {
-		log.Println("Error getting Redis client instance." + err.Error())
-		return false
+		logStoreError(ctx, "failure encoding the record", key, err)
+		return err
 	}
-	err = client.Set(context.TODO(), AddPrefix(key), value, ttl).Err()
+	err = setValue(ctx, key, value, record.TTL())
 	if err == nil {
-		cache.Insert(key, value)
+		cache.Insert(key, record)
+		incrCountURLsSet()
 	} else {
-		log.Println("Error setting key in Redis. " + err.Error())
+		logStoreError(ctx, "failure setting the record in the store", key, err)
 	}
-	go incrCountURLsSet()
-	return err == nil
+	return err
 }
 
-// DelKey deletes a key, returning true and nil if the key existed and was successfully deleted,
-// or false and an error if not.
-func DelKey(key string) (bool, error) {
-	client, err := redis_client.GetClientInstance()
+// CreateRecord sets the record for the specified key only if it doesn't have one yet, returning
+// false and nil if it does.
+func CreateRecord(ctx context.Context, key string, record Record) (bool, error) {
+	value, err := encodeRecord(record)
 	if err != nil {
-		log.Println("Error getting Redis client instance. " + err.Error())
+		logStoreError(ctx, "failure encoding the record", key, err)
 		return false, err
 	}
-	numRemoved, err := client.Del(context.TODO(), AddPrefix(key)).Result()
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+	created, err := getStore().SetNX(ctx, AddPrefix(key), value, record.TTL())
+	if err != nil {
+		logStoreError(ctx, "failure creating the record in the store", key, err)
+		return false, err
+	}
+	if created {
+		cache.Insert(key, record)
+		incrCountURLsSet()
+	}
+	return created, nil
+}
+
+// KeyedRecord is a record along with the key of its redirect.
+type KeyedRecord struct {
+	Key    string
+	Record Record
+}
+
+// CreateRecords sets the record of each key that doesn't have one yet, returning which ones were
+// created. If atomic is true, either every record is created or, if any key already has one,
+// none is, ErrAtomicUnsupported being returned by the stores that can't. If it fails, the records it reports as created were created nonetheless.
+func CreateRecords(ctx context.Context, keyed []KeyedRecord, atomic bool) ([]bool, error) {
+	entries := make([]store.Entry, len(keyed))
+	for i, k := range keyed {
+		value, err := encodeRecord(k.Record)
+		if err != nil {
+			logStoreError(ctx, "failure encoding the record", k.Key, err)
+			return make([]bool, len(keyed)), err
+		}
+		entries[i] = store.Entry{Key: AddPrefix(k.Key), Value: value, TTL: k.Record.TTL()}
+	}
+	writeCtx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+	created, err := getStore().SetNXMany(writeCtx, entries, atomic)
+	if err != nil && !errors.Is(err, ErrAtomicUnsupported) {
+		slog.ErrorContext(ctx, "failure creating the records in the store", "records", len(keyed), "atomic", atomic, "error", err)
+	}
+	for i, k := range keyed {
+		if created[i] {
+			cache.Insert(k.Key, k.Record)
+			incrCountURLsSet()
+		}
+	}
+	return created, err
+}
+
+// MAX_MODIFY_ATTEMPTS is how many times ModifyRecord reads and writes a record that keeps being
+// changed by other writers before giving up.
+const MAX_MODIFY_ATTEMPTS = 5
+
+// ErrConcurrentUpdate is returned by ModifyRecord when the record kept being changed by other
+// writers.
+var ErrConcurrentUpdate = errors.New("the record kept being changed by other writers")
+
+// ModifyRecord replaces the record of key with the one returned by modify given the current one,
+// only if the record wasn't changed in the meantime, reading it again and retrying otherwise. It
+// returns ErrNotFound if key has no record and the error of modify if it fails, in which case
+// nothing is written. If keepTTL is true, the key's current expiration is preserved.
+func ModifyRecord(ctx context.Context, key string, keepTTL bool, modify func(current Record) (Record, error)) (Record, error) {
+	for range MAX_MODIFY_ATTEMPTS {
+		// The cache is bypassed, as the write only succeeds if the stored value is unchanged.
+		current, err := getValue(ctx, key)
+		if err != nil {
+			if !errors.Is(err, ErrNotFound) {
+				logStoreError(ctx, "failure getting the record from the store", key, err)
+			}
+			return Record{}, err
+		}
+		decoded, _ := decodeRecord(current)
+		record, err := modify(decoded)
+		if err != nil {
+			return Record{}, err
+		}
+		value, err := encodeRecord(record)
+		if err != nil {
+			logStoreError(ctx, "failure encoding the record", key, err)
+			return Record{}, err
+		}
+
+		writeCtx, cancel := withWriteTimeout(ctx)
+		set, err := getStore().CompareAndSet(writeCtx, AddPrefix(key), current, value, record.TTL(), keepTTL)
+		cancel()
+		if err != nil {
+			logStoreError(ctx, "failure updating the record in the store", key, err)
+			return Record{}, err
+		}
+		if set {
+			cache.Insert(key, record)
+			return record, nil
+		}
+	}
+	cache.Remove(key)
+	return Record{}, ErrConcurrentUpdate
+}
+
+// ReplaceRecord sets the record of key like SetRecord, counting it as a URL set, but only if the
+// key has a record for which matches returns nil. It returns the error of matches if it fails, and
+// ErrNotFound or ErrConcurrentUpdate as ModifyRecord does, in which case nothing is written.
+func ReplaceRecord(ctx context.Context, key string, record Record, matches func(current Record) error) error {
+	_, err := ModifyRecord(ctx, key, false, func(current Record) (Record, error) {
+		if err := matches(current); err != nil {
+			return Record{}, err
+		}
+		return record, nil
+	})
+	if err == nil {
+		incrCountURLsSet()
+	}
+	return err
+}
+
+// DelKey deletes a key, along with the count of hits and the analytics of its redirect,
+// returning true and nil if the key existed and was successfully deleted, or false and an error
+// if not.
+func DelKey(ctx context.Context, key string) (bool, error) {
+	delCtx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+	deleted, err := getStore().Del(delCtx, AddPrefix(key))
 	if err == nil {
-		if numRemoved > 0 {
+		if deleted {
 			cache.Remove(key)
+			clearHits(ctx, []string{key})
 		}
 	} else {
-		log.Println("Error deleting key in Redis. " + err.Error())
+		logStoreError(ctx, "failure deleting the record from the store", key, err)
+	}
+	return deleted, err
+}
+
+// DelKeys deletes many keys at once, along with the counts of hits and the analytics of their
+// redirects, returning which ones existed. If it fails, the keys it reports as existing were
+// deleted nonetheless.
+func DelKeys(ctx context.Context, keys []string) ([]bool, error) {
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = AddPrefix(key)
+	}
+	delCtx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+	deleted, err := getStore().DelMany(delCtx, prefixed)
+	if err != nil {
+		slog.ErrorContext(ctx, "failure deleting the records from the store", "records", len(keys), "error", err)
+	}
+
+	removed := make([]string, 0, len(keys))
+	for i, key := range keys {
+		if deleted[i] {
+			cache.Remove(key)
+			removed = append(removed, key)
+		}
 	}
-	return numRemoved > 0, err
+	if len(removed) > 0 {
+		clearHits(ctx, removed)
+	}
+	return deleted, err
 }
 
 // AddPrefix adds a prefix to a key to separate keys from different environments.
@@ -81,32 +364,81 @@ func AddPrefix(key string) string {
 	return fmt.Sprintf("%s:%s", os.Getenv("RUNNING_ENV"), key)
 }
 
-// GetString retrieves a string value from Redis.
-func GetString(key string) (string, error) {
+// addInternalPrefix adds a prefix to a key holding internal data (such as per-link counters),
+// separating it from the keys of redirects so that it isn't listed by GetAllKeys.
+func addInternalPrefix(key string) string {
+	return fmt.Sprintf("%s#%s", os.Getenv("RUNNING_ENV"), key)
+}
+
+// logStoreError logs an error about the redirect of path, along with the key it is kept under in
+// the store.
+func logStoreError(ctx context.Context, msg string, path string, err error) {
+	slog.ErrorContext(ctx, msg, "path", path, "key", AddPrefix(path), "error", err)
+}
+
+// GetRecord retrieves the record of a redirect from the store. Legacy values holding only the
+// target URL are returned as records with no metadata.
+func GetRecord(ctx context.Context, key string) (Record, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "records.GetRecord", trace.WithAttributes(attribute.String("redirect.path", key)))
+	defer span.End()
+
 	value, ok := cache.Fetch(key)
 	if ok {
-		return_value, ok := value.(string)
+		record, ok := value.(Record)
 		if ok {
-			return return_value, nil
+			span.SetAttributes(attribute.Bool("cache.hit", true))
+			return record, nil
 		}
 	}
-	client, err := redis_client.GetClientInstance()
+	span.SetAttributes(attribute.Bool("cache.hit", false))
+	encoded, err := getValue(ctx, key)
 	if err != nil {
-		return "", err
+		if !errors.Is(err, ErrNotFound) {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			logStoreError(ctx, "failure getting the record from the store", key, err)
+		}
+		return Record{}, err
 	}
-	return client.Get(context.TODO(), AddPrefix(key)).Result()
+	record, _ := decodeRecord(encoded)
+	return record, nil
+}
+
+// getValue retrieves the value of the redirect of a key as it is kept in the store.
+func getValue(ctx context.Context, key string) (string, error) {
+	ctx, cancel := withReadTimeout(ctx)
+	defer cancel()
+	return getStore().Get(ctx, AddPrefix(key))
+}
+
+// setValue sets the value of the redirect of a key as it is kept in the store.
+func setValue(ctx context.Context, key string, value string, ttl time.Duration) error {
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+	return getStore().Set(ctx, AddPrefix(key), value, ttl)
+}
+
+// GetRecordTTL retrieves the remaining time to live of a redirect, 0 meaning it never expires.
+func GetRecordTTL(ctx context.Context, key string) (time.Duration, error) {
+	ctx, cancel := withReadTimeout(ctx)
+	defer cancel()
+	ttl, err := getStore().TTL(ctx, AddPrefix(key))
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		logStoreError(ctx, "failure getting the time to live of the record from the store", key, err)
+	}
+	return ttl, err
 }
 
 // GetAllKeys retrieves all keys that start with a prefix, with the
-// prefix itself removed.
-func GetAllKeys() ([]string, error) {
+// prefix itself removed. The keys are scanned without blocking the store, on every node of a
+// Redis cluster.
+func GetAllKeys(ctx context.Context) ([]string, error) {
 	prefix := AddPrefix("")
-	client, err := redis_client.GetClientInstance()
-	if err != nil {
-		return []string{}, err
-	}
-	keys, err := client.Keys(context.TODO(), prefix+"*").Result()
+	ctx, cancel := withReadTimeout(ctx)
+	defer cancel()
+	keys, err := getStore().Keys(ctx, prefix)
 	if err != nil {
+		slog.ErrorContext(ctx, "failure getting the keys from the store", "key", prefix+"*", "error", err)
 		return keys, err
 	}
 	for i := range keys {
@@ -115,13 +447,109 @@ func GetAllKeys() ([]string, error) {
 	return keys, err
 }
 
-// clearRedis clears all keys from the Redis database.
-func clearRedis() {
-	client, err := redis_client.GetClientInstance()
+// ListKeys iterates over the keys of redirects that start with the literal prefix and whose
+// remainder matches the glob pattern match ("*" if empty), returning a batch of about count
+// keys, with the environment prefix removed, and the cursor of the next batch (0 after the
+// last one). Iteration starts with cursor 0.
+func ListKeys(ctx context.Context, cursor uint64, prefix string, match string, count int64) ([]string, uint64, error) {
+	if match == "" {
+		match = "*"
+	}
+	envPrefix := AddPrefix("")
+	pattern := envPrefix + escapeGlob(prefix) + match
+	ctx, cancel := withReadTimeout(ctx)
+	defer cancel()
+	keys, next, err := getStore().Scan(ctx, cursor, pattern, count)
 	if err != nil {
-		return
+		slog.ErrorContext(ctx, "failure scanning the keys in the store", "key", pattern, "cursor", cursor, "error", err)
+		return []string{}, 0, err
+	}
+	unprefixed := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if key = key[len(envPrefix):]; !isCounterKey(key) {
+			unprefixed = append(unprefixed, key)
+		}
+	}
+	return unprefixed, next, nil
+}
+
+// escapeGlob escapes the special characters of glob patterns in s, so that it matches only
+// itself.
+func escapeGlob(s string) string {
+	var escaped strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[]\`, r) {
+			escaped.WriteRune('\\')
+		}
+		escaped.WriteRune(r)
+	}
+	return escaped.String()
+}
+
+// MigrateLegacyRecords rewrites every legacy value holding only a target URL as a structured
+// record, preserving its remaining time to live, and returns how many were migrated. The values
+// changed by other writers while they are migrated are left as they were written.
+func MigrateLegacyRecords(ctx context.Context) (int, error) {
+	keys, err := GetAllKeys(ctx)
+	if err != nil {
+		return 0, err
 	}
-	go client.FlushAll(context.TODO())
+	migrated := 0
+	for _, key := range keys {
+		if isCounterKey(key) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return migrated, err
+		}
+		legacyValue, err := getValue(ctx, key)
+		if err != nil {
+			continue
+		}
+		record, legacy := decodeRecord(legacyValue)
+		if !legacy {
+			continue
+		}
+		ttl, err := GetRecordTTL(ctx, key)
+		if err != nil {
+			continue
+		}
+		if ttl > 0 {
+			record.ExpiresAt = time.Now().UTC().Add(ttl)
+		}
+		encoded, err := encodeRecord(record)
+		if err != nil {
+			return migrated, err
+		}
+		// The server accepts writes meanwhile, so the value is only replaced if it's still the
+		// legacy one, keeping its expiration.
+		writeCtx, cancel := withWriteTimeout(ctx)
+		set, err := getStore().CompareAndSet(writeCtx, AddPrefix(key), legacyValue, encoded, ttl, true)
+		cancel()
+		if err != nil {
+			logStoreError(ctx, "failure migrating the record in the store", key, err)
+			return migrated, err
+		}
+		if !set {
+			continue
+		}
+		cache.Remove(key)
+		migrated++
+	}
+	return migrated, nil
+}
+
+// clearStore clears all keys from the store.
+func clearStore(ctx context.Context) {
+	go getStore().FlushAll(ctx)
 	ResetCache()
-	go clearCountURLsSet()
+	go clearCountURLsSet(ctx)
+}
+
+// TakeToken takes a token from the rate limiting bucket named key, which holds up to burst
+// tokens and is refilled at rate tokens per second.
+func TakeToken(ctx context.Context, key string, rate float64, burst int64) (store.TokenBucket, error) {
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+	return getStore().TakeToken(ctx, addInternalPrefix("ratelimit:"+key), rate, burst)
 }
@@ -0,0 +1,235 @@
+package records
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/luizcdc/redirectory/redirector/logging"
+	"github.com/luizcdc/redirectory/redirector/records/store"
+)
+
+func TestSetAndGetRecord(t *testing.T) {
+	t.Setenv("RUNNING_ENV", "TEST")
+	UseStore(store.NewMemoryStore(0))
+
+	record := NewRecord("https://example.com", 60)
+	record.Tags = []string{"campaign"}
+	if err := SetRecord(context.Background(), "docs", record); err != nil {
+		t.Fatalf("SetRecord returned error %v", err)
+	}
+	got, err := GetRecord(context.Background(), "docs")
+	if err != nil {
+		t.Fatalf("GetRecord returned error %v", err)
+	}
+	if got.URL != record.URL || got.Duration != 60 || got.StatusCode != DEFAULT_STATUS_CODE || len(got.Tags) != 1 {
+		t.Errorf("GetRecord(docs) = %+v, want %+v", got, record)
+	}
+	if !got.ExpiresAt.Equal(record.ExpiresAt) {
+		t.Errorf("GetRecord(docs) expires at %v, want %v", got.ExpiresAt, record.ExpiresAt)
+	}
+}
+
+func TestModifyRecordRetriesConcurrentChanges(t *testing.T) {
+	t.Setenv("RUNNING_ENV", "TEST")
+	ctx := context.Background()
+	UseStore(store.NewMemoryStore(0))
+	SetRecord(ctx, "docs", NewRecord("https://example.com/old", 60))
+
+	// The first attempt is interrupted by another writer, so it must be redone on its record.
+	attempts := 0
+	record, err := ModifyRecord(ctx, "docs", false, func(current Record) (Record, error) {
+		attempts++
+		if attempts == 1 {
+			SetRecord(ctx, "docs", NewRecord("https://example.com/concurrent", 60))
+		}
+		current.Notes = current.URL
+		return current, nil
+	})
+	if err != nil || attempts != 2 || record.Notes != "https://example.com/concurrent" {
+		t.Errorf("ModifyRecord = %+v, %v after %v attempts, want the concurrent record after 2", record, err, attempts)
+	}
+	if got, _ := GetRecord(ctx, "docs"); got.Notes != "https://example.com/concurrent" {
+		t.Errorf("GetRecord(docs) = %+v after ModifyRecord", got)
+	}
+
+	_, err = ModifyRecord(ctx, "docs", false, func(current Record) (Record, error) {
+		SetRecord(ctx, "docs", NewRecord(current.URL+"/again", 60))
+		return current, nil
+	})
+	if !errors.Is(err, ErrConcurrentUpdate) {
+		t.Errorf("ModifyRecord on a record always changed returned %v, want ErrConcurrentUpdate", err)
+	}
+	if _, err := ModifyRecord(ctx, "missing", false, func(current Record) (Record, error) { return current, nil }); !errors.Is(err, ErrNotFound) {
+		t.Errorf("ModifyRecord on a missing record returned %v, want ErrNotFound", err)
+	}
+}
+
+func TestMigrateLegacyRecords(t *testing.T) {
+	t.Setenv("RUNNING_ENV", "TEST")
+	ctx := context.Background()
+	s := store.NewMemoryStore(0)
+	UseStore(s)
+
+	s.Set(ctx, AddPrefix("legacy"), "https://example.com/legacy", time.Hour)
+	s.Set(ctx, AddPrefix(countURLsSetKey), "5", 0)
+	SetRecord(ctx, "current", NewRecord("https://example.com/current", 0))
+
+	legacy, err := GetRecord(ctx, "legacy")
+	if err != nil || legacy.URL != "https://example.com/legacy" || legacy.StatusCode != DEFAULT_STATUS_CODE {
+		t.Errorf("GetRecord(legacy) = %+v, %v before migrating", legacy, err)
+	}
+
+	migrated, err := MigrateLegacyRecords(ctx)
+	if err != nil || migrated != 1 {
+		t.Errorf("MigrateLegacyRecords() = %v, %v, want 1, nil", migrated, err)
+	}
+	encoded, _ := s.Get(ctx, AddPrefix("legacy"))
+	if _, isLegacy := decodeRecord(encoded); isLegacy {
+		t.Errorf("legacy value was not migrated: %v", encoded)
+	}
+	if ttl, _ := s.TTL(ctx, AddPrefix("legacy")); ttl <= 0 || ttl > time.Hour {
+		t.Errorf("migration did not preserve the TTL, got %v", ttl)
+	}
+	if count, err := GetCountURLsSet(ctx); err != nil || count < 5 {
+		t.Errorf("migration changed a counter: %v, %v", count, err)
+	}
+}
+
+// racingStore is a Store where another writer sets every key just before it's compared by
+// CompareAndSet.
+type racingStore struct {
+	*store.MemoryStore
+}
+
+func (s racingStore) CompareAndSet(ctx context.Context, key string, old string, value string, ttl time.Duration, keepTTL bool) (bool, error) {
+	s.MemoryStore.Set(ctx, key, "https://example.com/concurrent", 0)
+	return s.MemoryStore.CompareAndSet(ctx, key, old, value, ttl, keepTTL)
+}
+
+func TestMigrateLegacyRecordsKeepsConcurrentWrites(t *testing.T) {
+	t.Setenv("RUNNING_ENV", "TEST")
+	ctx := context.Background()
+	s := store.NewMemoryStore(0)
+	UseStore(racingStore{s})
+
+	s.Set(ctx, AddPrefix("legacy"), "https://example.com/legacy", time.Hour)
+	if migrated, err := MigrateLegacyRecords(ctx); err != nil || migrated != 0 {
+		t.Errorf("MigrateLegacyRecords() = %v, %v, want 0, nil", migrated, err)
+	}
+	if value, _ := s.Get(ctx, AddPrefix("legacy")); value != "https://example.com/concurrent" {
+		t.Errorf("the migration overwrote a concurrent write with %v", value)
+	}
+}
+
+func TestCloseFlushesCounters(t *testing.T) {
+	t.Setenv("RUNNING_ENV", "TEST")
+	s := store.NewMemoryStore(0)
+	UseStore(s)
+
+	incrCountURLsSet()
+	if err := Close(); err != nil {
+		t.Fatalf("Close returned error %v", err)
+	}
+	if count, _ := s.Get(context.Background(), AddPrefix(countURLsSetKey)); count == "" || count == "0" {
+		t.Errorf("count of URLs set after Close = %q, want it flushed", count)
+	}
+}
+
+// slowStore is a Store whose reads only return once their context is done.
+type slowStore struct {
+	*store.MemoryStore
+}
+
+func (s slowStore) Get(ctx context.Context, key string) (string, error) {
+	<-ctx.Done()
+	return "", ctx.Err()
+}
+
+func TestReadTimeout(t *testing.T) {
+	t.Setenv("RUNNING_ENV", "TEST")
+	UseStore(slowStore{store.NewMemoryStore(0)})
+	SetTimeouts(10*time.Millisecond, 0)
+	t.Cleanup(func() { SetTimeouts(0, 0) })
+
+	if _, err := GetRecord(context.Background(), "slow"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("GetRecord on a slow store returned error %v, want %v", err, context.DeadlineExceeded)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := GetRecord(ctx, "slow"); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetRecord with a canceled context returned error %v, want %v", err, context.Canceled)
+	}
+}
+
+// readOnlyStore is a Store whose writes of single values fail.
+type readOnlyStore struct {
+	*store.MemoryStore
+}
+
+func (s readOnlyStore) Set(ctx context.Context, key string, value string, ttl time.Duration) error {
+	return errors.New("the store is read-only")
+}
+
+func TestSetRecordCountsOnlyWrittenURLs(t *testing.T) {
+	t.Setenv("RUNNING_ENV", "TEST")
+	ctx := context.Background()
+	UseStore(store.NewMemoryStore(0))
+	FlushCounters()
+	UseStore(readOnlyStore{store.NewMemoryStore(0)})
+
+	if err := SetRecord(ctx, "docs", NewRecord("https://example.com", 0)); err == nil {
+		t.Fatal("SetRecord on a failing store didn't return an error")
+	}
+	FlushCounters()
+	if count, _ := GetCountURLsSet(ctx); count != 0 {
+		t.Errorf("GetCountURLsSet() = %v after a failed SetRecord, want 0", count)
+	}
+}
+
+func TestReplaceRecordCountsURLs(t *testing.T) {
+	t.Setenv("RUNNING_ENV", "TEST")
+	ctx := context.Background()
+	UseStore(store.NewMemoryStore(0))
+	errMismatch := errors.New("mismatch")
+
+	if err := ReplaceRecord(ctx, "docs", NewRecord("https://example.com/new", 0), func(Record) error { return nil }); !errors.Is(err, ErrNotFound) {
+		t.Errorf("ReplaceRecord of a missing record returned error %v, want ErrNotFound", err)
+	}
+	SetRecord(ctx, "docs", NewRecord("https://example.com", 0))
+	if err := ReplaceRecord(ctx, "docs", NewRecord("https://example.com/new", 0), func(Record) error { return errMismatch }); !errors.Is(err, errMismatch) {
+		t.Errorf("ReplaceRecord of a mismatching record returned error %v, want the error of matches", err)
+	}
+	if err := ReplaceRecord(ctx, "docs", NewRecord("https://example.com/new", 0), func(Record) error { return nil }); err != nil {
+		t.Errorf("ReplaceRecord returned error %v", err)
+	}
+	FlushCounters()
+	if count, _ := GetCountURLsSet(ctx); count != 2 {
+		t.Errorf("GetCountURLsSet() = %v after SetRecord and a ReplaceRecord, want 2", count)
+	}
+	if record, _ := GetRecord(ctx, "docs"); record.URL != "https://example.com/new" {
+		t.Errorf("ReplaceRecord left the URL as %v", record.URL)
+	}
+}
+
+func TestStoreErrorsAreLogged(t *testing.T) {
+	t.Setenv("RUNNING_ENV", "TEST")
+	UseStore(slowStore{store.NewMemoryStore(0)})
+	SetTimeouts(10*time.Millisecond, 0)
+	t.Cleanup(func() { SetTimeouts(0, 0) })
+	var buffer bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(logging.New(&buffer, slog.LevelInfo))
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+
+	GetRecord(logging.WithRequestID(context.Background(), "req-1234"), "slow")
+	for _, want := range []string{`"path":"slow"`, `"key":"TEST:slow"`, `"request_id":"req-1234"`, `"error":"context deadline exceeded"`} {
+		if !strings.Contains(buffer.String(), want) {
+			t.Errorf("logged %q, want it to contain %v", buffer.String(), want)
+		}
+	}
+}
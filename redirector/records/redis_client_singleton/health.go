@@ -0,0 +1,254 @@
+package redis_client_singleton
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// State is the health of the connection to Redis.
+type State string
+
+const (
+	// DISCONNECTED means the client wasn't instantiated yet, or was closed.
+	DISCONNECTED State = "disconnected"
+	HEALTHY      State = "healthy"
+	// UNAVAILABLE means Redis kept failing, so commands fail fast until it reconnects.
+	UNAVAILABLE State = "unavailable"
+)
+
+// ErrUnavailable is returned without contacting Redis while it is unavailable.
+var ErrUnavailable = errors.New("redis is unavailable")
+
+const (
+	DEFAULT_BREAKER_THRESHOLD = 5
+	DEFAULT_BACKOFF_MIN       = 100 * time.Millisecond
+	DEFAULT_BACKOFF_MAX       = 30 * time.Second
+)
+
+// circuitBreaker tracks the failures of the commands sent to Redis. After threshold consecutive
+// failures, it opens: commands fail fast with ErrUnavailable while Redis is pinged in the
+// background, with an exponential backoff between minBackoff and maxBackoff, until it answers.
+type circuitBreaker struct {
+	mu         sync.Mutex
+	state      State
+	failures   int
+	threshold  int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	client     redis.UniversalClient
+	// stop is closed to stop reconnecting, and is nil while not reconnecting.
+	stop chan struct{}
+}
+
+var breaker = &circuitBreaker{state: DISCONNECTED}
+
+// Health returns the current health of the connection to Redis.
+func Health() State {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+	return breaker.state
+}
+
+// configure reads the settings of the circuit breaker from REDIS_BREAKER_THRESHOLD,
+// REDIS_BACKOFF_MIN_MS and REDIS_BACKOFF_MAX_MS, falling back to the defaults, and sets the
+// client it reconnects.
+func (b *circuitBreaker) configure(client redis.UniversalClient) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.client = client
+	b.failures = 0
+	b.threshold = envInt("REDIS_BREAKER_THRESHOLD", DEFAULT_BREAKER_THRESHOLD)
+	b.minBackoff = time.Duration(envInt("REDIS_BACKOFF_MIN_MS", int(DEFAULT_BACKOFF_MIN.Milliseconds()))) * time.Millisecond
+	b.maxBackoff = time.Duration(envInt("REDIS_BACKOFF_MAX_MS", int(DEFAULT_BACKOFF_MAX.Milliseconds()))) * time.Millisecond
+}
+
+// envInt reads a positive integer from an environment variable, returning fallback if it is
+// unset or invalid.
+func envInt(name string, fallback int) int {
+	if os.Getenv(name) == "" {
+		return fallback
+	}
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || value <= 0 {
+		slog.Error("invalid setting of the Redis circuit breaker, using the default", "variable", name, "default", fallback)
+		return fallback
+	}
+	return value
+}
+
+// allow returns ErrUnavailable if commands must fail fast.
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == UNAVAILABLE {
+		return ErrUnavailable
+	}
+	return nil
+}
+
+// record counts the outcome of a command sent with ctx, opening the circuit after too many
+// failures.
+func (b *circuitBreaker) record(ctx context.Context, err error) {
+	if isConnectionFailure(ctx, err) {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.failures++
+		if b.state != UNAVAILABLE && b.failures >= b.threshold {
+			b.tripLocked()
+		}
+		return
+	}
+	// Only replies show that Redis is healthy, unlike the caller giving up.
+	var redisErr redis.Error
+	if err == nil || errors.As(err, &redisErr) {
+		b.success()
+	}
+}
+
+// success marks Redis as healthy.
+func (b *circuitBreaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	if b.state == DISCONNECTED {
+		b.state = HEALTHY
+	}
+}
+
+// trip opens the circuit immediately.
+func (b *circuitBreaker) trip() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tripLocked()
+}
+
+// tripLocked opens the circuit and starts reconnecting (without locking).
+func (b *circuitBreaker) tripLocked() {
+	b.state = UNAVAILABLE
+	if b.stop == nil {
+		slog.Warn("Redis is unavailable, reconnecting in the background")
+		b.stop = make(chan struct{})
+		go b.reconnect(b.client, b.stop, b.minBackoff, b.maxBackoff)
+	}
+}
+
+// reconnect pings Redis with an exponential backoff until it answers, then closes the circuit.
+func (b *circuitBreaker) reconnect(client redis.UniversalClient, stop chan struct{}, minBackoff time.Duration, maxBackoff time.Duration) {
+	backoff := minBackoff
+	for {
+		select {
+		case <-stop:
+			return
+		case <-time.After(backoff):
+		}
+		ctx, cancel := context.WithTimeout(bypassBreaker(context.Background()), backoff+time.Second)
+		err := client.Ping(ctx).Err()
+		cancel()
+
+		b.mu.Lock()
+		select {
+		case <-stop:
+			// The client was closed while pinging.
+			b.mu.Unlock()
+			return
+		default:
+		}
+		if err == nil {
+			b.state = HEALTHY
+			b.failures = 0
+			b.stop = nil
+			b.mu.Unlock()
+			slog.Info("reconnected to Redis")
+			return
+		}
+		b.mu.Unlock()
+		backoff = min(2*backoff, maxBackoff)
+		slog.Error("failure reconnecting to Redis", "error", err, "retry_in", backoff)
+	}
+}
+
+// reset stops reconnecting and marks the client as disconnected.
+func (b *circuitBreaker) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.stop != nil {
+		close(b.stop)
+		b.stop = nil
+	}
+	b.state = DISCONNECTED
+	b.failures = 0
+	b.client = nil
+}
+
+// isConnectionFailure indicates whether err, returned by a command sent with ctx, means that
+// Redis couldn't be reached or the connection to it broke, as opposed to replies such as a
+// missing key or the caller giving up. A per-operation timeout expiring is the caller giving up,
+// even if it surfaces as a timeout of the connection whose deadline it set.
+func isConnectionFailure(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// bypassBreakerKey marks the contexts of commands that are sent even while the circuit is open.
+type bypassBreakerKey struct{}
+
+// bypassBreaker returns a context whose commands are sent even while the circuit is open, and
+// aren't counted by it.
+func bypassBreaker(ctx context.Context) context.Context {
+	return context.WithValue(ctx, bypassBreakerKey{}, true)
+}
+
+// breakerHook is a redis.Hook that fails commands fast while the circuit is open and reports the
+// outcome of the others to the circuit breaker.
+type breakerHook struct {
+	breaker *circuitBreaker
+}
+
+func (h breakerHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h breakerHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if ctx.Value(bypassBreakerKey{}) != nil {
+			return next(ctx, cmd)
+		}
+		if err := h.breaker.allow(); err != nil {
+			cmd.SetErr(err)
+			return err
+		}
+		err := next(ctx, cmd)
+		h.breaker.record(ctx, err)
+		return err
+	}
+}
+
+func (h breakerHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		if ctx.Value(bypassBreakerKey{}) != nil {
+			return next(ctx, cmds)
+		}
+		if err := h.breaker.allow(); err != nil {
+			for _, cmd := range cmds {
+				cmd.SetErr(err)
+			}
+			return err
+		}
+		err := next(ctx, cmds)
+		h.breaker.record(ctx, err)
+		return err
+	}
+}
@@ -0,0 +1,44 @@
+package redis_client_singleton
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/luizcdc/redirectory/redirector/metrics"
+	"github.com/redis/go-redis/v9"
+)
+
+// metricsHook is a redis.Hook that observes the latency and the errors of the commands sent to
+// Redis. It is added after breakerHook, so that commands failing fast aren't observed.
+type metricsHook struct{}
+
+func (metricsHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (metricsHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmd)
+		metrics.ObserveRedisCommand(cmd.Name(), time.Since(start), commandError(err))
+		return err
+	}
+}
+
+func (metricsHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmds)
+		metrics.ObserveRedisCommand("pipeline", time.Since(start), commandError(err))
+		return err
+	}
+}
+
+// commandError returns err unless it only means that the key doesn't exist.
+func commandError(err error) error {
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	return err
+}
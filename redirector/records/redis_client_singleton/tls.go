@@ -0,0 +1,61 @@
+package redis_client_singleton
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// tlsConfig returns the TLS configuration of the connection to Redis, starting from base (which
+// is nil unless TLS was enabled by REDIS_URL) and reading the environment variables:
+//   - REDIS_TLS: "true" to connect over TLS, which is implied by any of the others.
+//   - REDIS_TLS_CA_FILE: a PEM bundle of the certificate authorities trusted to sign the
+//     server's certificate, instead of the system's.
+//   - REDIS_TLS_CERT_FILE, REDIS_TLS_KEY_FILE: the PEM client certificate and key, for mutual
+//     TLS.
+//   - REDIS_TLS_SERVER_NAME: the name expected in the server's certificate, when it isn't the
+//     host being connected to.
+//   - REDIS_TLS_INSECURE_SKIP_VERIFY: "true" to accept any certificate, only for development.
+//
+// It returns nil if TLS isn't enabled.
+func tlsConfig(base *tls.Config) (*tls.Config, error) {
+	caFile := os.Getenv("REDIS_TLS_CA_FILE")
+	certFile, keyFile := os.Getenv("REDIS_TLS_CERT_FILE"), os.Getenv("REDIS_TLS_KEY_FILE")
+	serverName := os.Getenv("REDIS_TLS_SERVER_NAME")
+	insecure := os.Getenv("REDIS_TLS_INSECURE_SKIP_VERIFY") == "true"
+	enabled := os.Getenv("REDIS_TLS") == "true" || caFile != "" || certFile != "" || serverName != "" || insecure
+	if base == nil && !enabled {
+		return nil, nil
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if base != nil {
+		config = base.Clone()
+	}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failure reading REDIS_TLS_CA_FILE: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("REDIS_TLS_CA_FILE has no PEM certificates")
+		}
+		config.RootCAs = pool
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failure loading REDIS_TLS_CERT_FILE and REDIS_TLS_KEY_FILE: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if serverName != "" {
+		config.ServerName = serverName
+	}
+	// The skip_verify=true of REDIS_URL, already set in base, isn't undone by the variable.
+	config.InsecureSkipVerify = config.InsecureSkipVerify || insecure
+	return config, nil
+}
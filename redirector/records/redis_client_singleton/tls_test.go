@@ -0,0 +1,68 @@
+package redis_client_singleton
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTLSConfig(t *testing.T) {
+	if config, err := tlsConfig(nil); config != nil || err != nil {
+		t.Errorf("tlsConfig(nil) = %v, %v without TLS variables, want nil", config, err)
+	}
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	writeTestCA(t, caFile)
+	t.Setenv("REDIS_TLS_CA_FILE", caFile)
+	t.Setenv("REDIS_TLS_INSECURE_SKIP_VERIFY", "true")
+	config, err := tlsConfig(nil)
+	if err != nil {
+		t.Fatalf("tlsConfig returned error %v", err)
+	}
+	if config.RootCAs == nil || !config.InsecureSkipVerify {
+		t.Errorf("tlsConfig() = %+v, want the CA bundle and insecure-skip-verify", config)
+	}
+
+	t.Setenv("REDIS_TLS_INSECURE_SKIP_VERIFY", "")
+	if config, err := tlsConfig(&tls.Config{InsecureSkipVerify: true}); err != nil || !config.InsecureSkipVerify {
+		t.Errorf("tlsConfig() = %+v, %v, want the insecure-skip-verify of the base config kept", config, err)
+	}
+
+	t.Setenv("REDIS_TLS_CA_FILE", filepath.Join(t.TempDir(), "missing.pem"))
+	if _, err := tlsConfig(nil); err == nil {
+		t.Error("tlsConfig accepted a missing CA bundle")
+	}
+}
+
+// writeTestCA writes a self-signed certificate to path, in PEM.
+func writeTestCA(t *testing.T, path string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
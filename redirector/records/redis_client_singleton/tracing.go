@@ -0,0 +1,57 @@
+package redis_client_singleton
+
+import (
+	"context"
+
+	"github.com/luizcdc/redirectory/redirector/tracing"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracingHook is a redis.Hook that traces the commands sent to Redis, each in a span named after
+// it. It is added before breakerHook, so that commands failing fast are traced as well. The
+// arguments of the commands aren't recorded, as they may hold the target URLs of redirects.
+type tracingHook struct{}
+
+func (tracingHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (tracingHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		ctx, span := startCommandSpan(ctx, "redis."+cmd.Name())
+		defer span.End()
+		err := next(ctx, cmd)
+		endCommandSpan(span, commandError(err))
+		return err
+	}
+}
+
+func (tracingHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		ctx, span := startCommandSpan(ctx, "redis.pipeline")
+		defer span.End()
+		span.SetAttributes(attribute.Int("db.redis.commands", len(cmds)))
+		err := next(ctx, cmds)
+		endCommandSpan(span, commandError(err))
+		return err
+	}
+}
+
+// startCommandSpan starts the span of a command sent to Redis.
+func startCommandSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	return tracing.Tracer().Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("db.system", "redis")),
+	)
+}
+
+// endCommandSpan records the error of a command sent to Redis in its span, if it failed.
+func endCommandSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
@@ -0,0 +1,478 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltBucket is the name of the bucket holding every key in a BoltStore.
+var boltBucket = []byte("records")
+
+// BoltStore is a Store persisted to a single local file through BoltDB, for small deployments
+// that don't warrant running a Redis server. Expired keys are never returned and are
+// periodically swept from the file.
+type BoltStore struct {
+	db        *bolt.DB
+	stopSweep chan struct{}
+	closeOnce sync.Once
+	cursors   scanCursors
+}
+
+// NewBoltStore opens (creating if necessary) the BoltDB file at path, sweeping expired keys from
+// it every sweepInterval. A sweepInterval of 0 disables sweeping.
+func NewBoltStore(path string, sweepInterval time.Duration) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed opening '%v': %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	s := &BoltStore{db: db, stopSweep: make(chan struct{})}
+	if sweepInterval > 0 {
+		go s.sweepEvery(sweepInterval)
+	}
+	return s, nil
+}
+
+// encodeBoltValue prepends the expiration time (as big-endian unix nanoseconds, 0 meaning it
+// never expires) to the value.
+func encodeBoltValue(value string, expiresAt time.Time) []byte {
+	encoded := make([]byte, 8+len(value))
+	if !expiresAt.IsZero() {
+		binary.BigEndian.PutUint64(encoded, uint64(expiresAt.UnixNano()))
+	}
+	copy(encoded[8:], value)
+	return encoded
+}
+
+// decodeBoltValue is the inverse of encodeBoltValue.
+func decodeBoltValue(encoded []byte) (value string, expiresAt time.Time) {
+	if len(encoded) < 8 {
+		return "", time.Time{}
+	}
+	if nanos := binary.BigEndian.Uint64(encoded); nanos != 0 {
+		expiresAt = time.Unix(0, int64(nanos))
+	}
+	return string(encoded[8:]), expiresAt
+}
+
+// boltEntry returns the live value and expiration of a key within a transaction.
+func boltEntry(tx *bolt.Tx, key string) (memoryEntry, bool) {
+	encoded := tx.Bucket(boltBucket).Get([]byte(key))
+	if encoded == nil {
+		return memoryEntry{}, false
+	}
+	value, expiresAt := decodeBoltValue(encoded)
+	entry := memoryEntry{value: value, expiresAt: expiresAt}
+	if entry.expired(time.Now()) {
+		return memoryEntry{}, false
+	}
+	return entry, true
+}
+
+// Get retrieves the value of a key, returning ErrNotFound if it doesn't exist.
+func (s *BoltStore) Get(_ context.Context, key string) (string, error) {
+	var value string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		entry, ok := boltEntry(tx, key)
+		if !ok {
+			return ErrNotFound
+		}
+		value = entry.value
+		return nil
+	})
+	return value, err
+}
+
+// Set sets the value of a key, overwriting it if it already exists.
+func (s *BoltStore) Set(_ context.Context, key string, value string, ttl time.Duration) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Put([]byte(key), encodeBoltValue(value, expiresAfter(ttl)))
+	})
+}
+
+// SetNX sets the value of a key only if it doesn't exist yet, returning whether it did.
+func (s *BoltStore) SetNX(_ context.Context, key string, value string, ttl time.Duration) (bool, error) {
+	var set bool
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		if _, ok := boltEntry(tx, key); ok {
+			return nil
+		}
+		set = true
+		return tx.Bucket(boltBucket).Put([]byte(key), encodeBoltValue(value, expiresAfter(ttl)))
+	})
+	return set, err
+}
+
+// SetNXMany sets each entry whose key doesn't exist yet, returning which ones were set, in a
+// single transaction. If atomic is true, either every entry is set or, if any key exists, none
+// is.
+func (s *BoltStore) SetNXMany(_ context.Context, entries []Entry, atomic bool) ([]bool, error) {
+	set := make([]bool, len(entries))
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		if atomic {
+			for _, entry := range entries {
+				if _, ok := boltEntry(tx, entry.Key); ok {
+					return nil
+				}
+			}
+		}
+		for i, entry := range entries {
+			if _, ok := boltEntry(tx, entry.Key); ok {
+				continue
+			}
+			if err := tx.Bucket(boltBucket).Put([]byte(entry.Key), encodeBoltValue(entry.Value, expiresAfter(entry.TTL))); err != nil {
+				return err
+			}
+			set[i] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return make([]bool, len(entries)), err
+	}
+	return set, nil
+}
+
+// Update sets the value of a key only if it already exists, returning whether it did. If
+// keepTTL is true, ttl is ignored and the key's current expiration is preserved.
+func (s *BoltStore) Update(_ context.Context, key string, value string, ttl time.Duration, keepTTL bool) (bool, error) {
+	var updated bool
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		entry, ok := boltEntry(tx, key)
+		if !ok {
+			return nil
+		}
+		if !keepTTL {
+			entry.expiresAt = expiresAfter(ttl)
+		}
+		updated = true
+		return tx.Bucket(boltBucket).Put([]byte(key), encodeBoltValue(value, entry.expiresAt))
+	})
+	return updated, err
+}
+
+// CompareAndSet sets the value of a key only if its current value is old, in a single
+// transaction, returning whether it did. If keepTTL is true, ttl is ignored and the key's current
+// expiration is preserved.
+func (s *BoltStore) CompareAndSet(_ context.Context, key string, old string, value string, ttl time.Duration, keepTTL bool) (bool, error) {
+	var set bool
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		entry, ok := boltEntry(tx, key)
+		if !ok || entry.value != old {
+			return nil
+		}
+		if !keepTTL {
+			entry.expiresAt = expiresAfter(ttl)
+		}
+		set = true
+		return tx.Bucket(boltBucket).Put([]byte(key), encodeBoltValue(value, entry.expiresAt))
+	})
+	return set, err
+}
+
+// TTL returns the remaining time to live of a key, 0 if it never expires, or ErrNotFound if it
+// doesn't exist.
+func (s *BoltStore) TTL(_ context.Context, key string) (time.Duration, error) {
+	var ttl time.Duration
+	err := s.db.View(func(tx *bolt.Tx) error {
+		entry, ok := boltEntry(tx, key)
+		if !ok {
+			return ErrNotFound
+		}
+		ttl = entry.ttl(time.Now())
+		return nil
+	})
+	return ttl, err
+}
+
+// Del deletes a key, returning whether it existed.
+func (s *BoltStore) Del(_ context.Context, key string) (bool, error) {
+	var existed bool
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		_, existed = boltEntry(tx, key)
+		return tx.Bucket(boltBucket).Delete([]byte(key))
+	})
+	return existed, err
+}
+
+// DelMany deletes many keys at once, in a single transaction, returning which ones existed.
+func (s *BoltStore) DelMany(_ context.Context, keys []string) ([]bool, error) {
+	existed := make([]bool, len(keys))
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		for i, key := range keys {
+			_, existed[i] = boltEntry(tx, key)
+			if err := tx.Bucket(boltBucket).Delete([]byte(key)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return make([]bool, len(keys)), err
+	}
+	return existed, nil
+}
+
+// Keys lists all keys starting with prefix.
+func (s *BoltStore) Keys(_ context.Context, prefix string) ([]string, error) {
+	keys := []string{}
+	now := time.Now()
+	err := s.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(boltBucket).Cursor()
+		for k, v := cursor.Seek([]byte(prefix)); k != nil && bytes.HasPrefix(k, []byte(prefix)); k, v = cursor.Next() {
+			if _, expiresAt := decodeBoltValue(v); hasExpired(expiresAt, now) {
+				continue
+			}
+			keys = append(keys, string(k))
+		}
+		return nil
+	})
+	return keys, err
+}
+
+// Scan iterates over the keys matching a glob pattern, returning a batch of about count keys
+// starting at cursor and the cursor of the next batch, which is 0 after the last one. The keys
+// are iterated in lexicographical order, each cursor referring to the last key of the batch
+// before it, so a batch seeks to it instead of going over every key before it. The cursors
+// expire after SCAN_CURSOR_TTL and don't survive the store, returning ErrInvalidCursor then.
+func (s *BoltStore) Scan(_ context.Context, cursor uint64, match string, count int64) ([]string, uint64, error) {
+	if count <= 0 {
+		count = 10
+	}
+	after, err := s.cursors.after(cursor)
+	if err != nil {
+		return []string{}, 0, err
+	}
+
+	keys := []string{}
+	last := ""
+	more := false
+	now := time.Now()
+	err = s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltBucket).Cursor()
+		k, v := c.Seek([]byte(after))
+		if cursor != 0 && k != nil && string(k) == after {
+			k, v = c.Next()
+		}
+		for scanned := int64(0); k != nil; k, v = c.Next() {
+			if scanned == count {
+				more = true
+				return nil
+			}
+			scanned++
+			last = string(k)
+			if _, expiresAt := decodeBoltValue(v); hasExpired(expiresAt, now) {
+				continue
+			}
+			matched, err := path.Match(match, last)
+			if err != nil {
+				return err
+			}
+			if matched {
+				keys = append(keys, last)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return []string{}, 0, err
+	}
+	if !more {
+		return keys, 0, nil
+	}
+	return keys, s.cursors.save(last), nil
+}
+
+// IncrBy increments the integer stored at key by delta, creating it if necessary, and returns
+// the new value. The key's expiration, if any, is preserved.
+func (s *BoltStore) IncrBy(_ context.Context, key string, delta int64) (int64, error) {
+	var current int64
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		entry, _ := boltEntry(tx, key)
+		if entry.value != "" {
+			var err error
+			current, err = strconv.ParseInt(entry.value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("value at key '%v' is not an integer", key)
+			}
+		}
+		current += delta
+		return tx.Bucket(boltBucket).Put([]byte(key), encodeBoltValue(strconv.FormatInt(current, 10), entry.expiresAt))
+	})
+	return current, err
+}
+
+// HIncrBy increments each field of the hash stored at key by its delta, creating the hash and
+// the fields as necessary. Hashes are stored JSON-encoded.
+func (s *BoltStore) HIncrBy(_ context.Context, key string, deltas map[string]int64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		entry, _ := boltEntry(tx, key)
+		fields := map[string]int64{}
+		if entry.value != "" {
+			if err := json.Unmarshal([]byte(entry.value), &fields); err != nil {
+				return fmt.Errorf("value at key '%v' is not a hash", key)
+			}
+		}
+		for field, delta := range deltas {
+			fields[field] += delta
+		}
+		encoded, err := json.Marshal(fields)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(boltBucket).Put([]byte(key), encodeBoltValue(string(encoded), entry.expiresAt))
+	})
+}
+
+// HGetAll retrieves every field of the hash stored at key, which is empty if it doesn't exist.
+func (s *BoltStore) HGetAll(ctx context.Context, key string) (map[string]int64, error) {
+	fields := map[string]int64{}
+	encoded, err := s.Get(ctx, key)
+	if errors.Is(err, ErrNotFound) {
+		return fields, nil
+	} else if err != nil {
+		return fields, err
+	}
+	if err := json.Unmarshal([]byte(encoded), &fields); err != nil {
+		return map[string]int64{}, fmt.Errorf("value at key '%v' is not a hash", key)
+	}
+	return fields, nil
+}
+
+// HGetAllMany retrieves every field of the hashes stored at many keys, in the same order, each
+// being empty if it doesn't exist.
+func (s *BoltStore) HGetAllMany(ctx context.Context, keys []string) ([]map[string]int64, error) {
+	hashes := make([]map[string]int64, len(keys))
+	for i, key := range keys {
+		fields, err := s.HGetAll(ctx, key)
+		if err != nil {
+			return hashes, err
+		}
+		hashes[i] = fields
+	}
+	return hashes, nil
+}
+
+// ApplyDeltas applies every increment in deltas, then sets the expirations, returning the
+// deltas that weren't applied if it fails.
+func (s *BoltStore) ApplyDeltas(ctx context.Context, deltas Deltas) (Deltas, error) {
+	return applyDeltasOneByOne(ctx, s, deltas)
+}
+
+// TakeToken atomically takes a token from the bucket stored at key, which holds up to burst
+// tokens, starts full and is refilled at rate tokens per second.
+func (s *BoltStore) TakeToken(_ context.Context, key string, rate float64, burst int64) (TokenBucket, error) {
+	var bucket TokenBucket
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		entry, ok := boltEntry(tx, key)
+		entry, bucket = takeTokenFrom(entry, ok, rate, burst)
+		return tx.Bucket(boltBucket).Put([]byte(key), encodeBoltValue(entry.value, entry.expiresAt))
+	})
+	return bucket, err
+}
+
+// Expire sets the time to live of an existing key, returning whether it exists.
+func (s *BoltStore) Expire(_ context.Context, key string, ttl time.Duration) (bool, error) {
+	var exists bool
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		entry, ok := boltEntry(tx, key)
+		if !ok {
+			return nil
+		}
+		exists = true
+		if ttl <= 0 {
+			return tx.Bucket(boltBucket).Delete([]byte(key))
+		}
+		return tx.Bucket(boltBucket).Put([]byte(key), encodeBoltValue(entry.value, expiresAfter(ttl)))
+	})
+	return exists, err
+}
+
+// FlushAll removes every key from the store.
+func (s *BoltStore) FlushAll(_ context.Context) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(boltBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucket(boltBucket)
+		return err
+	})
+}
+
+// Ping checks that the underlying file is still open.
+func (s *BoltStore) Ping(ctx context.Context) error {
+	return s.db.View(func(tx *bolt.Tx) error { return nil })
+}
+
+// Close stops the expiry sweeper and closes the underlying file. Closing the store again does
+// nothing.
+func (s *BoltStore) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.stopSweep)
+		err = s.db.Close()
+	})
+	return err
+}
+
+// sweepEvery removes expired keys every interval until the store is closed.
+func (s *BoltStore) sweepEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopSweep:
+			return
+		case <-ticker.C:
+			removed, err := s.sweep()
+			if err != nil {
+				slog.Error("failure sweeping expired keys from the file store", "error", err)
+			} else if removed > 0 {
+				slog.Debug("swept expired keys from the file store", "removed", removed)
+			}
+		}
+	}
+}
+
+// sweep removes every expired key, returning how many were removed.
+func (s *BoltStore) sweep() (int, error) {
+	removed := 0
+	now := time.Now()
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltBucket)
+		// Deleting while iterating with a cursor skips entries, so the keys are collected first.
+		var expired [][]byte
+		bucket.ForEach(func(k, v []byte) error {
+			if _, expiresAt := decodeBoltValue(v); hasExpired(expiresAt, now) {
+				expired = append(expired, bytes.Clone(k))
+			}
+			return nil
+		})
+		for _, k := range expired {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		removed = len(expired)
+		return nil
+	})
+	return removed, err
+}
@@ -0,0 +1,135 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestBoltStore(t *testing.T, path string) *BoltStore {
+	t.Helper()
+	s, err := NewBoltStore(path, 0)
+	if err != nil {
+		t.Fatalf("NewBoltStore(%v) returned error %v", path, err)
+	}
+	return s
+}
+
+func TestBoltPersistence(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "test.db")
+	s := newTestBoltStore(t, path)
+	s.Set(ctx, "DEV:key", "val", time.Hour)
+	s.IncrBy(ctx, "DEV:count_urls_set", 3)
+	s.Close()
+
+	s = newTestBoltStore(t, path)
+	defer s.Close()
+	if got, err := s.Get(ctx, "DEV:key"); err != nil || got != "val" {
+		t.Errorf("Get(DEV:key) after reopening = %v, %v, want val, nil", got, err)
+	}
+	if got, err := s.IncrBy(ctx, "DEV:count_urls_set", 1); err != nil || got != 4 {
+		t.Errorf("IncrBy after reopening = %v, %v, want 4, nil", got, err)
+	}
+	if keys, _ := s.Keys(ctx, "DEV:"); len(keys) != 2 {
+		t.Errorf("Keys(DEV:) returned %v, want 2 keys", keys)
+	}
+	if keys, _ := s.Keys(ctx, "PROD:"); len(keys) != 0 {
+		t.Errorf("Keys(PROD:) returned %v, want no keys", keys)
+	}
+}
+
+func TestBoltTTLAndSweep(t *testing.T) {
+	ctx := context.Background()
+	s := newTestBoltStore(t, filepath.Join(t.TempDir(), "test.db"))
+	defer s.Close()
+	s.Set(ctx, "short", "val", 10*time.Millisecond)
+	s.Set(ctx, "forever", "val", 0)
+	time.Sleep(20 * time.Millisecond)
+	if _, err := s.Get(ctx, "short"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get returned error %v for an expired key, want ErrNotFound", err)
+	}
+	if deleted, _ := s.Del(ctx, "short"); deleted {
+		t.Error("Del reported an expired key as existing")
+	}
+	s.Set(ctx, "short", "val", 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	removed, err := s.sweep()
+	if err != nil || removed != 1 {
+		t.Errorf("sweep() = %v, %v, want 1, nil", removed, err)
+	}
+	if _, err := s.Get(ctx, "forever"); err != nil {
+		t.Errorf("sweep removed a key without expiration: %v", err)
+	}
+	s.FlushAll(ctx)
+	if keys, _ := s.Keys(ctx, ""); len(keys) != 0 {
+		t.Errorf("FlushAll did not remove every key, %v remain", len(keys))
+	}
+}
+
+func TestBoltSetNXMany(t *testing.T) {
+	s := newTestBoltStore(t, filepath.Join(t.TempDir(), "records.db"))
+	defer s.Close()
+	testSetNXMany(t, s)
+}
+
+func TestBoltDelMany(t *testing.T) {
+	s := newTestBoltStore(t, filepath.Join(t.TempDir(), "records.db"))
+	defer s.Close()
+	testDelMany(t, s)
+}
+
+func TestBoltCompareAndSet(t *testing.T) {
+	s := newTestBoltStore(t, filepath.Join(t.TempDir(), "records.db"))
+	defer s.Close()
+	testCompareAndSet(t, s)
+}
+
+func TestBoltScan(t *testing.T) {
+	ctx := context.Background()
+	s := newTestBoltStore(t, filepath.Join(t.TempDir(), "test.db"))
+	defer s.Close()
+	for i := 0; i < 25; i++ {
+		s.Set(ctx, fmt.Sprintf("DEV:%02d", i), "val", 0)
+		s.Set(ctx, fmt.Sprintf("PROD:%02d", i), "val", 0)
+	}
+	seen := map[string]bool{}
+	var cursor uint64
+	for batches := 0; batches == 0 || cursor != 0; batches++ {
+		if batches > 50 {
+			t.Fatal("Scan never returned a 0 cursor")
+		}
+		keys, next, err := s.Scan(ctx, cursor, "DEV:*", 10)
+		if err != nil {
+			t.Fatalf("Scan returned error %v", err)
+		}
+		for _, key := range keys {
+			seen[key] = true
+			// The keys already scanned can be deleted without skipping the following ones.
+			s.Del(ctx, key)
+		}
+		cursor = next
+	}
+	if len(seen) != 25 {
+		t.Errorf("Scan(DEV:*) iterated over %v keys, want 25", len(seen))
+	}
+	if _, _, err := s.Scan(ctx, 12345, "*", 10); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("Scan with an unknown cursor returned error %v, want ErrInvalidCursor", err)
+	}
+}
+
+func TestBoltCloseTwice(t *testing.T) {
+	s, err := NewBoltStore(filepath.Join(t.TempDir(), "test.db"), time.Hour)
+	if err != nil {
+		t.Fatalf("NewBoltStore returned error %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Errorf("Close returned error %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Errorf("closing the store again returned error %v", err)
+	}
+}
@@ -0,0 +1,461 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a Store that keeps every key in a map within the process, honouring TTLs.
+// Expired keys are never returned and are periodically swept from the map. Its contents are lost
+// when the process exits.
+type MemoryStore struct {
+	mu        sync.Mutex
+	entries   map[string]memoryEntry
+	stopSweep chan struct{}
+	closeOnce sync.Once
+	cursors   scanCursors
+}
+
+// memoryEntry is a value stored in a MemoryStore along with its expiration time.
+type memoryEntry struct {
+	value string
+	// hash holds the fields of the entry if it is a hash rather than a plain value.
+	hash map[string]int64
+	// The zero value means the entry never expires.
+	expiresAt time.Time
+}
+
+// expired indicates whether the entry has expired at the given moment.
+func (e memoryEntry) expired(now time.Time) bool {
+	return hasExpired(e.expiresAt, now)
+}
+
+// ttl returns the remaining time to live of the entry at the given moment, 0 meaning it never
+// expires.
+func (e memoryEntry) ttl(now time.Time) time.Duration {
+	if e.expiresAt.IsZero() {
+		return 0
+	}
+	return e.expiresAt.Sub(now)
+}
+
+// hasExpired indicates whether an expiration time (the zero value meaning never) has passed at
+// the given moment.
+func hasExpired(expiresAt time.Time, now time.Time) bool {
+	return !expiresAt.IsZero() && !now.Before(expiresAt)
+}
+
+// expiresAfter returns the expiration time of a key set now with the given ttl, the zero value
+// meaning it never expires.
+func expiresAfter(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
+}
+
+// NewMemoryStore constructs an empty MemoryStore, sweeping expired keys from it every
+// sweepInterval. A sweepInterval of 0 disables sweeping.
+func NewMemoryStore(sweepInterval time.Duration) *MemoryStore {
+	s := &MemoryStore{entries: make(map[string]memoryEntry), stopSweep: make(chan struct{})}
+	if sweepInterval > 0 {
+		go s.sweepEvery(sweepInterval)
+	}
+	return s
+}
+
+// get returns the live entry for a key, evicting it if it has expired (without locking).
+func (s *MemoryStore) get(key string) (memoryEntry, bool) {
+	entry, ok := s.entries[key]
+	if !ok {
+		return entry, false
+	}
+	if entry.expired(time.Now()) {
+		delete(s.entries, key)
+		return memoryEntry{}, false
+	}
+	return entry, true
+}
+
+// Get retrieves the value of a key, returning ErrNotFound if it doesn't exist.
+func (s *MemoryStore) Get(_ context.Context, key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.get(key)
+	if !ok {
+		return "", ErrNotFound
+	}
+	return entry.value, nil
+}
+
+// Set sets the value of a key, overwriting it if it already exists.
+func (s *MemoryStore) Set(_ context.Context, key string, value string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries[key] = memoryEntry{value: value, expiresAt: expiresAfter(ttl)}
+	return nil
+}
+
+// SetNX sets the value of a key only if it doesn't exist yet, returning whether it did.
+func (s *MemoryStore) SetNX(_ context.Context, key string, value string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.get(key); ok {
+		return false, nil
+	}
+	s.entries[key] = memoryEntry{value: value, expiresAt: expiresAfter(ttl)}
+	return true, nil
+}
+
+// SetNXMany sets each entry whose key doesn't exist yet, returning which ones were set. If
+// atomic is true, either every entry is set or, if any key exists, none is.
+func (s *MemoryStore) SetNXMany(_ context.Context, entries []Entry, atomic bool) ([]bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	set := make([]bool, len(entries))
+	if atomic {
+		for _, entry := range entries {
+			if _, ok := s.get(entry.Key); ok {
+				return set, nil
+			}
+		}
+	}
+	for i, entry := range entries {
+		if _, ok := s.get(entry.Key); ok {
+			continue
+		}
+		s.entries[entry.Key] = memoryEntry{value: entry.Value, expiresAt: expiresAfter(entry.TTL)}
+		set[i] = true
+	}
+	return set, nil
+}
+
+// Update sets the value of a key only if it already exists, returning whether it did. If
+// keepTTL is true, ttl is ignored and the key's current expiration is preserved.
+func (s *MemoryStore) Update(_ context.Context, key string, value string, ttl time.Duration, keepTTL bool) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.get(key)
+	if !ok {
+		return false, nil
+	}
+	entry.value = value
+	if !keepTTL {
+		entry.expiresAt = expiresAfter(ttl)
+	}
+	s.entries[key] = entry
+	return true, nil
+}
+
+// CompareAndSet sets the value of a key only if its current value is old, returning whether it
+// did. If keepTTL is true, ttl is ignored and the key's current expiration is preserved.
+func (s *MemoryStore) CompareAndSet(_ context.Context, key string, old string, value string, ttl time.Duration, keepTTL bool) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.get(key)
+	if !ok || entry.value != old {
+		return false, nil
+	}
+	entry.value = value
+	if !keepTTL {
+		entry.expiresAt = expiresAfter(ttl)
+	}
+	s.entries[key] = entry
+	return true, nil
+}
+
+// TTL returns the remaining time to live of a key, 0 if it never expires, or ErrNotFound if it
+// doesn't exist.
+func (s *MemoryStore) TTL(_ context.Context, key string) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.get(key)
+	if !ok {
+		return 0, ErrNotFound
+	}
+	return entry.ttl(time.Now()), nil
+}
+
+// Del deletes a key, returning whether it existed.
+func (s *MemoryStore) Del(_ context.Context, key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.get(key)
+	delete(s.entries, key)
+	return ok, nil
+}
+
+// DelMany deletes many keys at once, returning which ones existed.
+func (s *MemoryStore) DelMany(_ context.Context, keys []string) ([]bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existed := make([]bool, len(keys))
+	for i, key := range keys {
+		_, existed[i] = s.get(key)
+		delete(s.entries, key)
+	}
+	return existed, nil
+}
+
+// Keys lists all keys starting with prefix.
+func (s *MemoryStore) Keys(_ context.Context, prefix string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := []string{}
+	for key := range s.entries {
+		if _, ok := s.get(key); ok && strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// Scan iterates over the keys matching a glob pattern, returning a batch of about count keys
+// starting at cursor and the cursor of the next batch, which is 0 after the last one. The keys
+// are iterated in lexicographical order, each cursor referring to the last key of the batch
+// before it, so that the keys set or deleted in the meantime don't shift the following ones. The
+// cursors expire after SCAN_CURSOR_TTL, returning ErrInvalidCursor then.
+func (s *MemoryStore) Scan(_ context.Context, cursor uint64, match string, count int64) ([]string, uint64, error) {
+	if count <= 0 {
+		count = 10
+	}
+	after, err := s.cursors.after(cursor)
+	if err != nil {
+		return []string{}, 0, err
+	}
+
+	s.mu.Lock()
+	following := make([]string, 0, len(s.entries))
+	for key := range s.entries {
+		if _, ok := s.get(key); ok && (cursor == 0 || key > after) {
+			following = append(following, key)
+		}
+	}
+	s.mu.Unlock()
+
+	sort.Strings(following)
+	batch := following[:min(int64(len(following)), count)]
+	keys := []string{}
+	for _, key := range batch {
+		matched, err := path.Match(match, key)
+		if err != nil {
+			return []string{}, 0, err
+		}
+		if matched {
+			keys = append(keys, key)
+		}
+	}
+	if len(batch) == len(following) {
+		return keys, 0, nil
+	}
+	return keys, s.cursors.save(batch[len(batch)-1]), nil
+}
+
+// IncrBy increments the integer stored at key by delta, creating it if necessary, and returns
+// the new value. The key's expiration, if any, is preserved.
+func (s *MemoryStore) IncrBy(_ context.Context, key string, delta int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, _ := s.get(key)
+	var current int64
+	if entry.value != "" {
+		var err error
+		current, err = strconv.ParseInt(entry.value, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("value at key '%v' is not an integer", key)
+		}
+	}
+	current += delta
+	entry.value = strconv.FormatInt(current, 10)
+	s.entries[key] = entry
+	return current, nil
+}
+
+// HIncrBy increments each field of the hash stored at key by its delta, creating the hash and
+// the fields as necessary.
+func (s *MemoryStore) HIncrBy(_ context.Context, key string, deltas map[string]int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, _ := s.get(key)
+	if entry.hash == nil {
+		entry.hash = make(map[string]int64, len(deltas))
+	}
+	for field, delta := range deltas {
+		entry.hash[field] += delta
+	}
+	s.entries[key] = entry
+	return nil
+}
+
+// HGetAll retrieves every field of the hash stored at key, which is empty if it doesn't exist.
+func (s *MemoryStore) HGetAll(_ context.Context, key string) (map[string]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, _ := s.get(key)
+	fields := make(map[string]int64, len(entry.hash))
+	for field, value := range entry.hash {
+		fields[field] = value
+	}
+	return fields, nil
+}
+
+// HGetAllMany retrieves every field of the hashes stored at many keys, in the same order, each
+// being empty if it doesn't exist.
+func (s *MemoryStore) HGetAllMany(ctx context.Context, keys []string) ([]map[string]int64, error) {
+	hashes := make([]map[string]int64, len(keys))
+	for i, key := range keys {
+		fields, err := s.HGetAll(ctx, key)
+		if err != nil {
+			return hashes, err
+		}
+		hashes[i] = fields
+	}
+	return hashes, nil
+}
+
+// ApplyDeltas applies every increment in deltas, then sets the expirations, returning the
+// deltas that weren't applied if it fails.
+func (s *MemoryStore) ApplyDeltas(ctx context.Context, deltas Deltas) (Deltas, error) {
+	return applyDeltasOneByOne(ctx, s, deltas)
+}
+
+// applyDeltasOneByOne applies deltas through the individual operations of a store, returning
+// the deltas that weren't applied once one of them fails.
+func applyDeltasOneByOne(ctx context.Context, s Store, deltas Deltas) (Deltas, error) {
+	unapplied := NewDeltas()
+	var err error
+	for key, delta := range deltas.Counters {
+		if err == nil {
+			_, err = s.IncrBy(ctx, key, delta)
+		}
+		if err != nil {
+			unapplied.Counters[key] = delta
+		}
+	}
+	for key, fields := range deltas.Hashes {
+		if err == nil {
+			err = s.HIncrBy(ctx, key, fields)
+		}
+		if err != nil {
+			unapplied.Hashes[key] = fields
+		}
+	}
+	for key, ttl := range deltas.Expirations {
+		// The expirations are set again along with the increments of their keys.
+		_, pending := unapplied.Hashes[key]
+		if err == nil {
+			_, err = s.Expire(ctx, key, ttl)
+			pending = err != nil
+		}
+		if pending {
+			unapplied.Expirations[key] = ttl
+			if _, ok := unapplied.Hashes[key]; !ok {
+				unapplied.Hashes[key] = map[string]int64{}
+			}
+		}
+	}
+	return unapplied, err
+}
+
+// TakeToken atomically takes a token from the bucket stored at key, which holds up to burst
+// tokens, starts full and is refilled at rate tokens per second.
+func (s *MemoryStore) TakeToken(_ context.Context, key string, rate float64, burst int64) (TokenBucket, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.get(key)
+	entry, bucket := takeTokenFrom(entry, ok, rate, burst)
+	s.entries[key] = entry
+	return bucket, nil
+}
+
+// Expire sets the time to live of an existing key, returning whether it exists.
+func (s *MemoryStore) Expire(_ context.Context, key string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.get(key)
+	if !ok {
+		return false, nil
+	}
+	if ttl <= 0 {
+		delete(s.entries, key)
+		return true, nil
+	}
+	entry.expiresAt = expiresAfter(ttl)
+	s.entries[key] = entry
+	return true, nil
+}
+
+// FlushAll removes every key from the store.
+func (s *MemoryStore) FlushAll(_ context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries = make(map[string]memoryEntry)
+	return nil
+}
+
+// Ping always succeeds, as a MemoryStore is always reachable.
+func (s *MemoryStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Close stops the expiry sweeper, as a MemoryStore holds no external resources. Closing the
+// store again does nothing.
+func (s *MemoryStore) Close() error {
+	s.closeOnce.Do(func() { close(s.stopSweep) })
+	return nil
+}
+
+// sweepEvery removes expired keys every interval until the store is closed.
+func (s *MemoryStore) sweepEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopSweep:
+			return
+		case <-ticker.C:
+			if removed := s.sweep(); removed > 0 {
+				slog.Debug("swept expired keys from the memory store", "removed", removed)
+			}
+		}
+	}
+}
+
+// sweep removes every expired key, returning how many were removed.
+func (s *MemoryStore) sweep() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed := 0
+	now := time.Now()
+	for key, entry := range s.entries {
+		if entry.expired(now) {
+			delete(s.entries, key)
+			removed++
+		}
+	}
+	return removed
+}
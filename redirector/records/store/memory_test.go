@@ -0,0 +1,323 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestMemorySetGet(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore(0)
+	if _, err := s.Get(ctx, "key"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get on empty store returned error %v, want ErrNotFound", err)
+	}
+	if err := s.Set(ctx, "key", "val", 0); err != nil {
+		t.Fatalf("Set returned error %v", err)
+	}
+	got, err := s.Get(ctx, "key")
+	if err != nil || got != "val" {
+		t.Errorf("Get(key) = %v, %v, want val, nil", got, err)
+	}
+	s.Set(ctx, "key", "other", 0)
+	if got, _ := s.Get(ctx, "key"); got != "other" {
+		t.Errorf("Set did not overwrite the value. Got: %v, want: other", got)
+	}
+}
+
+func TestMemoryTTL(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore(0)
+	s.Set(ctx, "short", "val", 10*time.Millisecond)
+	s.Set(ctx, "long", "val", time.Hour)
+	time.Sleep(20 * time.Millisecond)
+	if _, err := s.Get(ctx, "short"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get returned error %v for an expired key, want ErrNotFound", err)
+	}
+	if _, err := s.Get(ctx, "long"); err != nil {
+		t.Errorf("Get returned error %v for a key that hasn't expired yet", err)
+	}
+	if keys, _ := s.Keys(ctx, ""); len(keys) != 1 {
+		t.Errorf("Keys returned %v, expired keys should not be listed", keys)
+	}
+}
+
+func TestMemoryExpireAndSweep(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore(0)
+	s.Set(ctx, "key", "val", 0)
+	if exists, err := s.Expire(ctx, "key", 0); !exists || err != nil {
+		t.Errorf("Expire(key, 0) = %v, %v, want true, nil", exists, err)
+	}
+	if _, err := s.Get(ctx, "key"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get returned error %v for a key expired with ttl 0, want ErrNotFound", err)
+	}
+
+	s.Set(ctx, "short", "val", 10*time.Millisecond)
+	s.Set(ctx, "forever", "val", 0)
+	time.Sleep(20 * time.Millisecond)
+	if removed := s.sweep(); removed != 1 {
+		t.Errorf("sweep() = %v, want 1", removed)
+	}
+	if _, err := s.Get(ctx, "forever"); err != nil {
+		t.Errorf("sweep removed a key without expiration: %v", err)
+	}
+}
+
+func TestMemorySetNX(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore(0)
+	if set, err := s.SetNX(ctx, "key", "val", 0); !set || err != nil {
+		t.Errorf("SetNX on a missing key = %v, %v, want true, nil", set, err)
+	}
+	if set, err := s.SetNX(ctx, "key", "other", 0); set || err != nil {
+		t.Errorf("SetNX on an existing key = %v, %v, want false, nil", set, err)
+	}
+	if got, _ := s.Get(ctx, "key"); got != "val" {
+		t.Errorf("SetNX overwrote an existing key. Got: %v, want: val", got)
+	}
+}
+
+func TestMemoryUpdate(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore(0)
+	if updated, err := s.Update(ctx, "key", "val", 0, false); updated || err != nil {
+		t.Errorf("Update on a missing key = %v, %v, want false, nil", updated, err)
+	}
+	s.Set(ctx, "key", "val", time.Hour)
+	if updated, err := s.Update(ctx, "key", "new", 0, true); !updated || err != nil {
+		t.Errorf("Update(keepTTL) = %v, %v, want true, nil", updated, err)
+	}
+	if ttl, _ := s.TTL(ctx, "key"); ttl <= 0 {
+		t.Errorf("Update(keepTTL) did not keep the TTL, got %v", ttl)
+	}
+	s.Update(ctx, "key", "newer", 0, false)
+	if ttl, _ := s.TTL(ctx, "key"); ttl != 0 {
+		t.Errorf("Update with ttl 0 should remove the expiration, got %v", ttl)
+	}
+	if got, _ := s.Get(ctx, "key"); got != "newer" {
+		t.Errorf("Update did not change the value. Got: %v, want: newer", got)
+	}
+}
+
+func TestMemoryDel(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore(0)
+	s.Set(ctx, "key", "val", 0)
+	if deleted, err := s.Del(ctx, "key"); !deleted || err != nil {
+		t.Errorf("Del(key) = %v, %v, want true, nil", deleted, err)
+	}
+	if deleted, err := s.Del(ctx, "key"); deleted || err != nil {
+		t.Errorf("Del(key) on a deleted key = %v, %v, want false, nil", deleted, err)
+	}
+}
+
+func TestMemoryKeys(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore(0)
+	for i := 0; i < 5; i++ {
+		s.Set(ctx, fmt.Sprintf("DEV:%v", i), "val", 0)
+		s.Set(ctx, fmt.Sprintf("PROD:%v", i), "val", 0)
+	}
+	keys, err := s.Keys(ctx, "DEV:")
+	if err != nil {
+		t.Fatalf("Keys returned error %v", err)
+	}
+	if len(keys) != 5 {
+		t.Errorf("Keys(DEV:) returned %v keys, want 5", len(keys))
+	}
+	s.FlushAll(ctx)
+	if keys, _ := s.Keys(ctx, ""); len(keys) != 0 {
+		t.Errorf("FlushAll did not remove every key, %v remain", len(keys))
+	}
+}
+
+func TestMemoryIncrBy(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore(0)
+	for i := 1; i <= 3; i++ {
+		got, err := s.IncrBy(ctx, "counter", 2)
+		if err != nil || got != int64(2*i) {
+			t.Errorf("IncrBy(counter, 2) = %v, %v, want %v, nil", got, err, 2*i)
+		}
+	}
+	s.Set(ctx, "text", "val", 0)
+	if _, err := s.IncrBy(ctx, "text", 1); err == nil {
+		t.Error("IncrBy on a non-integer value should have returned an error")
+	}
+}
+
+func TestMemoryScan(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore(0)
+	for i := 0; i < 25; i++ {
+		s.Set(ctx, fmt.Sprintf("DEV:%02d", i), "val", 0)
+		s.Set(ctx, fmt.Sprintf("PROD:%02d", i), "val", 0)
+	}
+	seen := map[string]bool{}
+	var cursor uint64
+	for batches := 0; batches == 0 || cursor != 0; batches++ {
+		if batches > 50 {
+			t.Fatal("Scan never returned a 0 cursor")
+		}
+		keys, next, err := s.Scan(ctx, cursor, "DEV:*", 10)
+		if err != nil {
+			t.Fatalf("Scan returned error %v", err)
+		}
+		for _, key := range keys {
+			if seen[key] {
+				t.Errorf("Scan returned %v twice", key)
+			}
+			seen[key] = true
+			// The keys already scanned can be deleted, and keys set before the cursor, without
+			// skipping or repeating the following ones.
+			s.Del(ctx, key)
+			s.Set(ctx, "CANARY:"+key, "val", 0)
+		}
+		cursor = next
+	}
+	for i := 0; i < 25; i++ {
+		if key := fmt.Sprintf("DEV:%02d", i); !seen[key] {
+			t.Errorf("Scan(DEV:*) skipped %v", key)
+		}
+	}
+	if _, _, err := s.Scan(ctx, 12345, "*", 10); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("Scan with an unknown cursor returned error %v, want ErrInvalidCursor", err)
+	}
+}
+
+func TestMemoryHash(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore(0)
+	if fields, err := s.HGetAll(ctx, "hash"); err != nil || len(fields) != 0 {
+		t.Errorf("HGetAll on a missing key = %v, %v, want an empty map", fields, err)
+	}
+	s.HIncrBy(ctx, "hash", map[string]int64{"a": 1, "b": 2})
+	s.HIncrBy(ctx, "hash", map[string]int64{"a": 1})
+	fields, _ := s.HGetAll(ctx, "hash")
+	if fields["a"] != 2 || fields["b"] != 2 || len(fields) != 2 {
+		t.Errorf("HGetAll after increments = %v, want map[a:2 b:2]", fields)
+	}
+	if hashes, err := s.HGetAllMany(ctx, []string{"missing", "hash"}); err != nil || len(hashes[0]) != 0 || hashes[1]["a"] != 2 {
+		t.Errorf("HGetAllMany = %v, %v, want [map[] map[a:2 b:2]]", hashes, err)
+	}
+	if exists, _ := s.Expire(ctx, "hash", 10*time.Millisecond); !exists {
+		t.Error("Expire reported an existing hash as missing")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if fields, _ := s.HGetAll(ctx, "hash"); len(fields) != 0 {
+		t.Errorf("HGetAll on an expired hash = %v, want an empty map", fields)
+	}
+}
+
+// testTakeToken checks that a bucket of s gives its burst of tokens at once, then is refilled.
+func testTakeToken(t *testing.T, s Store) {
+	t.Helper()
+	ctx := context.Background()
+	for i := range 3 {
+		bucket, err := s.TakeToken(ctx, "bucket", 50, 3)
+		if err != nil || !bucket.Taken || bucket.Remaining != int64(2-i) {
+			t.Fatalf("TakeToken #%v = %+v, %v, want a token taken with %v left", i+1, bucket, err, 2-i)
+		}
+	}
+	bucket, err := s.TakeToken(ctx, "bucket", 50, 3)
+	if err != nil || bucket.Taken || bucket.RetryAfter <= 0 || bucket.RetryAfter > 20*time.Millisecond || bucket.Reset <= bucket.RetryAfter {
+		t.Errorf("TakeToken from an empty bucket = %+v, %v", bucket, err)
+	}
+	time.Sleep(bucket.RetryAfter + 10*time.Millisecond)
+	if bucket, err := s.TakeToken(ctx, "bucket", 50, 3); err != nil || !bucket.Taken {
+		t.Errorf("TakeToken after a refill = %+v, %v, want a token taken", bucket, err)
+	}
+}
+
+func TestMemoryTakeToken(t *testing.T) {
+	testTakeToken(t, NewMemoryStore(0))
+}
+
+// testSetNXMany checks that SetNXMany of s sets only the missing keys, and none of them if it is
+// atomic.
+func testSetNXMany(t *testing.T, s Store) {
+	t.Helper()
+	ctx := context.Background()
+	s.Set(ctx, "taken", "old", 0)
+	entries := []Entry{{"free", "new", time.Hour}, {"taken", "new", 0}}
+
+	if set, err := s.SetNXMany(ctx, entries, true); err != nil || set[0] || set[1] {
+		t.Errorf("atomic SetNXMany with an existing key = %v, %v, want nothing set", set, err)
+	}
+	if _, err := s.Get(ctx, "free"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("atomic SetNXMany with an existing key set another key")
+	}
+	if set, err := s.SetNXMany(ctx, entries, false); err != nil || !set[0] || set[1] {
+		t.Errorf("SetNXMany = %v, %v, want only the missing key set", set, err)
+	}
+	if got, _ := s.Get(ctx, "taken"); got != "old" {
+		t.Errorf("SetNXMany overwrote an existing key. Got: %v, want: old", got)
+	}
+	if ttl, err := s.TTL(ctx, "free"); err != nil || ttl <= 0 || ttl > time.Hour {
+		t.Errorf("TTL(free) = %v, %v after SetNXMany, want up to an hour", ttl, err)
+	}
+	if set, err := s.SetNXMany(ctx, []Entry{{"a", "1", 0}, {"b", "2", 0}}, true); err != nil || !set[0] || !set[1] {
+		t.Errorf("atomic SetNXMany with missing keys = %v, %v, want every key set", set, err)
+	}
+}
+
+func TestMemorySetNXMany(t *testing.T) {
+	testSetNXMany(t, NewMemoryStore(0))
+}
+
+// testDelMany checks that DelMany of s deletes the keys, reporting which ones existed.
+func testDelMany(t *testing.T, s Store) {
+	t.Helper()
+	ctx := context.Background()
+	s.Set(ctx, "a", "1", 0)
+	s.Set(ctx, "b", "2", 0)
+	s.Set(ctx, "kept", "3", 0)
+
+	if deleted, err := s.DelMany(ctx, []string{"a", "missing", "b"}); err != nil || !deleted[0] || deleted[1] || !deleted[2] {
+		t.Errorf("DelMany = %v, %v, want [true false true]", deleted, err)
+	}
+	if keys, _ := s.Keys(ctx, ""); len(keys) != 1 || keys[0] != "kept" {
+		t.Errorf("Keys after DelMany = %v, want [kept]", keys)
+	}
+}
+
+func TestMemoryDelMany(t *testing.T) {
+	testDelMany(t, NewMemoryStore(0))
+}
+
+// testCompareAndSet checks that CompareAndSet of s only sets a key whose value is the expected
+// one, keeping or replacing its expiration.
+func testCompareAndSet(t *testing.T, s Store) {
+	t.Helper()
+	ctx := context.Background()
+	if set, err := s.CompareAndSet(ctx, "key", "", "val", 0, false); set || err != nil {
+		t.Errorf("CompareAndSet on a missing key = %v, %v, want false, nil", set, err)
+	}
+	s.Set(ctx, "key", "val", time.Hour)
+	if set, err := s.CompareAndSet(ctx, "key", "other", "new", 0, false); set || err != nil {
+		t.Errorf("CompareAndSet with a stale value = %v, %v, want false, nil", set, err)
+	}
+	if got, _ := s.Get(ctx, "key"); got != "val" {
+		t.Errorf("CompareAndSet with a stale value changed the key to %v", got)
+	}
+	if set, err := s.CompareAndSet(ctx, "key", "val", "new", 0, true); !set || err != nil {
+		t.Errorf("CompareAndSet(keepTTL) = %v, %v, want true, nil", set, err)
+	}
+	if ttl, _ := s.TTL(ctx, "key"); ttl <= 0 {
+		t.Errorf("CompareAndSet(keepTTL) did not keep the TTL, got %v", ttl)
+	}
+	s.CompareAndSet(ctx, "key", "new", "newer", 0, false)
+	if ttl, _ := s.TTL(ctx, "key"); ttl != 0 {
+		t.Errorf("CompareAndSet with ttl 0 should remove the expiration, got %v", ttl)
+	}
+	if got, _ := s.Get(ctx, "key"); got != "newer" {
+		t.Errorf("CompareAndSet did not change the value. Got: %v, want: newer", got)
+	}
+}
+
+func TestMemoryCompareAndSet(t *testing.T) {
+	testCompareAndSet(t, NewMemoryStore(0))
+}
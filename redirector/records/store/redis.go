@@ -0,0 +1,515 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	redis_client "github.com/luizcdc/redirectory/redirector/records/redis_client_singleton"
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisStore is a Store backed by the Redis client singleton, which may be a single node, a
+// Sentinel-managed master or a cluster.
+type RedisStore struct{}
+
+// NewRedisStore constructs a RedisStore. The Redis client itself is lazily instantiated on the
+// first operation.
+func NewRedisStore() *RedisStore {
+	return &RedisStore{}
+}
+
+// Get retrieves the value of a key, returning ErrNotFound if it doesn't exist.
+func (s *RedisStore) Get(ctx context.Context, key string) (string, error) {
+	client, err := redis_client.GetClientInstance()
+	if err != nil {
+		return "", err
+	}
+	value, err := client.Get(ctx, key).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", ErrNotFound
+	}
+	return value, err
+}
+
+// Set sets the value of a key, overwriting it if it already exists.
+func (s *RedisStore) Set(ctx context.Context, key string, value string, ttl time.Duration) error {
+	client, err := redis_client.GetClientInstance()
+	if err != nil {
+		return err
+	}
+	return client.Set(ctx, key, value, ttl).Err()
+}
+
+// SetNX sets the value of a key only if it doesn't exist yet, returning whether it did.
+func (s *RedisStore) SetNX(ctx context.Context, key string, value string, ttl time.Duration) (bool, error) {
+	client, err := redis_client.GetClientInstance()
+	if err != nil {
+		return false, err
+	}
+	return client.SetNX(ctx, key, value, ttl).Result()
+}
+
+// setNXManyScript sets every key in KEYS, with the values and times to live in milliseconds (0
+// meaning it never expires) in the matching pairs of ARGV, only if none of them exists,
+// returning whether they were set.
+var setNXManyScript = redis.NewScript(`
+for _, key in ipairs(KEYS) do
+	if redis.call('EXISTS', key) == 1 then
+		return 0
+	end
+end
+for i, key in ipairs(KEYS) do
+	local ttl = tonumber(ARGV[2 * i])
+	if ttl > 0 then
+		redis.call('SET', key, ARGV[2 * i - 1], 'PX', ttl)
+	else
+		redis.call('SET', key, ARGV[2 * i - 1])
+	end
+end
+return 1
+`)
+
+// SetNXMany sets each entry whose key doesn't exist yet, returning which ones were set, in a
+// single pipeline. If atomic is true, either every entry is set or, if any key exists, none is,
+// through a script. As a script can only write keys of the same hash slot, a cluster returns
+// ErrAtomicUnsupported for more than one entry.
+func (s *RedisStore) SetNXMany(ctx context.Context, entries []Entry, atomic bool) ([]bool, error) {
+	set := make([]bool, len(entries))
+	client, err := redis_client.GetClientInstance()
+	if err != nil || len(entries) == 0 {
+		return set, err
+	}
+	if _, ok := client.(*redis.ClusterClient); ok && atomic && len(entries) > 1 {
+		return set, ErrAtomicUnsupported
+	}
+	if atomic {
+		keys := make([]string, len(entries))
+		args := make([]interface{}, 0, 2*len(entries))
+		for i, entry := range entries {
+			keys[i] = entry.Key
+			args = append(args, entry.Value, entry.TTL.Milliseconds())
+		}
+		allSet, err := setNXManyScript.Run(ctx, client, keys, args...).Bool()
+		if err != nil {
+			return set, err
+		}
+		for i := range set {
+			set[i] = allSet
+		}
+		return set, nil
+	}
+
+	cmds := make([]*redis.BoolCmd, len(entries))
+	_, err = client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, entry := range entries {
+			cmds[i] = pipe.SetNX(ctx, entry.Key, entry.Value, entry.TTL)
+		}
+		return nil
+	})
+	// The entries set before the pipeline failed, if it did, are still reported.
+	for i, cmd := range cmds {
+		set[i] = cmd.Err() == nil && cmd.Val()
+	}
+	return set, err
+}
+
+// Update sets the value of a key only if it already exists, returning whether it did. If
+// keepTTL is true, ttl is ignored and the key's current expiration is preserved.
+func (s *RedisStore) Update(ctx context.Context, key string, value string, ttl time.Duration, keepTTL bool) (bool, error) {
+	client, err := redis_client.GetClientInstance()
+	if err != nil {
+		return false, err
+	}
+	args := redis.SetArgs{Mode: "XX", TTL: ttl, KeepTTL: keepTTL}
+	if keepTTL {
+		args.TTL = 0
+	}
+	err = client.SetArgs(ctx, key, value, args).Err()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// compareAndSetScript sets KEYS[1] to ARGV[2] only if its current value is ARGV[1], returning
+// whether it did. ARGV[3] is the time to live in milliseconds (0 meaning it never expires) and
+// ARGV[4] is "1" to preserve the key's current expiration instead.
+var compareAndSetScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+local ttl = tonumber(ARGV[3])
+if ARGV[4] == '1' then
+	redis.call('SET', KEYS[1], ARGV[2], 'KEEPTTL')
+elseif ttl > 0 then
+	redis.call('SET', KEYS[1], ARGV[2], 'PX', ttl)
+else
+	redis.call('SET', KEYS[1], ARGV[2])
+end
+return 1
+`)
+
+// CompareAndSet sets the value of a key only if its current value is old, atomically through a
+// script, returning whether it did. If keepTTL is true, ttl is ignored and the key's current
+// expiration is preserved.
+func (s *RedisStore) CompareAndSet(ctx context.Context, key string, old string, value string, ttl time.Duration, keepTTL bool) (bool, error) {
+	client, err := redis_client.GetClientInstance()
+	if err != nil {
+		return false, err
+	}
+	keep := "0"
+	if keepTTL {
+		keep = "1"
+	}
+	return compareAndSetScript.Run(ctx, client, []string{key}, old, value, ttl.Milliseconds(), keep).Bool()
+}
+
+// TTL returns the remaining time to live of a key, 0 if it never expires, or ErrNotFound if it
+// doesn't exist.
+func (s *RedisStore) TTL(ctx context.Context, key string) (time.Duration, error) {
+	client, err := redis_client.GetClientInstance()
+	if err != nil {
+		return 0, err
+	}
+	ttl, err := client.TTL(ctx, key).Result()
+	switch {
+	case err != nil:
+		return 0, err
+	// Redis replies -2 when the key doesn't exist and -1 when it has no expiration.
+	case ttl == -2:
+		return 0, ErrNotFound
+	case ttl == -1:
+		return 0, nil
+	}
+	return ttl, nil
+}
+
+// Del deletes a key, returning whether it existed.
+func (s *RedisStore) Del(ctx context.Context, key string) (bool, error) {
+	client, err := redis_client.GetClientInstance()
+	if err != nil {
+		return false, err
+	}
+	numRemoved, err := client.Del(ctx, key).Result()
+	return numRemoved > 0, err
+}
+
+// DelMany deletes many keys at once, in a single pipeline, returning which ones existed. If it
+// fails, the keys it reports as existing were deleted nonetheless.
+func (s *RedisStore) DelMany(ctx context.Context, keys []string) ([]bool, error) {
+	existed := make([]bool, len(keys))
+	client, err := redis_client.GetClientInstance()
+	if err != nil || len(keys) == 0 {
+		return existed, err
+	}
+	cmds := make([]*redis.IntCmd, len(keys))
+	_, err = client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			cmds[i] = pipe.Del(ctx, key)
+		}
+		return nil
+	})
+	for i, cmd := range cmds {
+		existed[i] = cmd.Err() == nil && cmd.Val() == 1
+	}
+	return existed, err
+}
+
+// Keys lists all keys starting with prefix, iterating over them with SCAN so that Redis isn't
+// blocked (on every master, in a cluster).
+func (s *RedisStore) Keys(ctx context.Context, prefix string) ([]string, error) {
+	keys := []string{}
+	var cursor uint64
+	for {
+		batch, next, err := s.Scan(ctx, cursor, prefix+"*", 1000)
+		if err != nil {
+			return []string{}, err
+		}
+		keys = append(keys, batch...)
+		if next == 0 {
+			return keys, nil
+		}
+		cursor = next
+	}
+}
+
+// clusterCursorShift is the position of the bits of a cluster cursor holding the index of the
+// master being scanned, the lower bits holding the cursor within it.
+const clusterCursorShift = 48
+
+// Scan iterates over the keys matching a glob pattern with Redis' SCAN, returning a batch of
+// about count keys starting at cursor and the cursor of the next batch, which is 0 after the
+// last one. In a cluster, the masters are scanned one after the other.
+func (s *RedisStore) Scan(ctx context.Context, cursor uint64, match string, count int64) ([]string, uint64, error) {
+	client, err := redis_client.GetClientInstance()
+	if err != nil {
+		return []string{}, 0, err
+	}
+	cluster, ok := client.(*redis.ClusterClient)
+	if !ok {
+		return client.Scan(ctx, cursor, match, count).Result()
+	}
+
+	masters, err := clusterMasters(ctx, cluster)
+	if err != nil {
+		return []string{}, 0, err
+	}
+	master := int(cursor >> clusterCursorShift)
+	if master >= len(masters) {
+		return []string{}, 0, nil
+	}
+	keys, next, err := masters[master].Scan(ctx, cursor&(1<<clusterCursorShift-1), match, count).Result()
+	switch {
+	case err != nil:
+		return []string{}, 0, err
+	case next >= 1<<clusterCursorShift:
+		return []string{}, 0, fmt.Errorf("the cursor of %v is too large to scan the cluster", masters[master].Options().Addr)
+	case next == 0:
+		if master+1 == len(masters) {
+			return keys, 0, nil
+		}
+		return keys, uint64(master+1) << clusterCursorShift, nil
+	}
+	return keys, uint64(master)<<clusterCursorShift | next, nil
+}
+
+// clusterMasters returns the clients of the masters of a cluster, ordered by address so that
+// cursors remain valid between calls.
+func clusterMasters(ctx context.Context, cluster *redis.ClusterClient) ([]*redis.Client, error) {
+	var mu sync.Mutex
+	masters := []*redis.Client{}
+	err := cluster.ForEachMaster(ctx, func(ctx context.Context, master *redis.Client) error {
+		mu.Lock()
+		defer mu.Unlock()
+		masters = append(masters, master)
+		return nil
+	})
+	slices.SortFunc(masters, func(a, b *redis.Client) int {
+		return strings.Compare(a.Options().Addr, b.Options().Addr)
+	})
+	return masters, err
+}
+
+// IncrBy increments the integer stored at key by delta, creating it if necessary, and returns
+// the new value.
+func (s *RedisStore) IncrBy(ctx context.Context, key string, delta int64) (int64, error) {
+	client, err := redis_client.GetClientInstance()
+	if err != nil {
+		return 0, err
+	}
+	return client.IncrBy(ctx, key, delta).Result()
+}
+
+// HIncrBy increments each field of the hash stored at key by its delta, creating the hash and
+// the fields as necessary. The increments are sent in a single pipeline.
+func (s *RedisStore) HIncrBy(ctx context.Context, key string, deltas map[string]int64) error {
+	client, err := redis_client.GetClientInstance()
+	if err != nil {
+		return err
+	}
+	_, err = client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for field, delta := range deltas {
+			pipe.HIncrBy(ctx, key, field, delta)
+		}
+		return nil
+	})
+	return err
+}
+
+// HGetAll retrieves every field of the hash stored at key, which is empty if it doesn't exist.
+func (s *RedisStore) HGetAll(ctx context.Context, key string) (map[string]int64, error) {
+	client, err := redis_client.GetClientInstance()
+	if err != nil {
+		return map[string]int64{}, err
+	}
+	values, err := client.HGetAll(ctx, key).Result()
+	if err != nil {
+		return map[string]int64{}, err
+	}
+	return parseHash(values)
+}
+
+// HGetAllMany retrieves every field of the hashes stored at many keys, in the same order, each
+// being empty if it doesn't exist, in a single pipeline.
+func (s *RedisStore) HGetAllMany(ctx context.Context, keys []string) ([]map[string]int64, error) {
+	hashes := make([]map[string]int64, len(keys))
+	client, err := redis_client.GetClientInstance()
+	if err != nil || len(keys) == 0 {
+		return hashes, err
+	}
+	cmds := make([]*redis.MapStringStringCmd, len(keys))
+	_, err = client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			cmds[i] = pipe.HGetAll(ctx, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return hashes, err
+	}
+	for i, cmd := range cmds {
+		if hashes[i], err = parseHash(cmd.Val()); err != nil {
+			return hashes, err
+		}
+	}
+	return hashes, nil
+}
+
+// parseHash converts the fields of a hash, as returned by Redis, into integers.
+func parseHash(values map[string]string) (map[string]int64, error) {
+	fields := make(map[string]int64, len(values))
+	for field, value := range values {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return map[string]int64{}, fmt.Errorf("field '%v' is not an integer", field)
+		}
+		fields[field] = parsed
+	}
+	return fields, nil
+}
+
+// ApplyDeltas applies every increment in deltas, then sets the expirations, in a single
+// transaction, split by hash slot on a cluster. If it fails, it returns the deltas of the
+// commands that failed, so that a retry doesn't count again the increments that were applied.
+func (s *RedisStore) ApplyDeltas(ctx context.Context, deltas Deltas) (Deltas, error) {
+	client, err := redis_client.GetClientInstance()
+	if err != nil {
+		return deltas, err
+	}
+	counters := make(map[string]*redis.IntCmd, len(deltas.Counters))
+	hashes := make(map[string]map[string]*redis.IntCmd, len(deltas.Hashes))
+	expirations := make(map[string]*redis.BoolCmd, len(deltas.Expirations))
+	_, err = client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for key, delta := range deltas.Counters {
+			counters[key] = pipe.IncrBy(ctx, key, delta)
+		}
+		for key, fields := range deltas.Hashes {
+			hashes[key] = make(map[string]*redis.IntCmd, len(fields))
+			for field, delta := range fields {
+				hashes[key][field] = pipe.HIncrBy(ctx, key, field, delta)
+			}
+		}
+		for key, ttl := range deltas.Expirations {
+			expirations[key] = pipe.Expire(ctx, key, ttl)
+		}
+		return nil
+	})
+	if err == nil {
+		return NewDeltas(), nil
+	}
+
+	unapplied := NewDeltas()
+	for key, cmd := range counters {
+		if cmd.Err() != nil {
+			unapplied.Counters[key] = deltas.Counters[key]
+		}
+	}
+	for key, cmds := range hashes {
+		for field, cmd := range cmds {
+			if cmd.Err() == nil {
+				continue
+			}
+			if unapplied.Hashes[key] == nil {
+				unapplied.Hashes[key] = map[string]int64{}
+			}
+			unapplied.Hashes[key][field] = deltas.Hashes[key][field]
+		}
+	}
+	for key, cmd := range expirations {
+		if cmd.Err() == nil {
+			continue
+		}
+		unapplied.Expirations[key] = deltas.Expirations[key]
+		if unapplied.Hashes[key] == nil {
+			unapplied.Hashes[key] = map[string]int64{}
+		}
+	}
+	return unapplied, err
+}
+
+// takeTokenScript takes a token from the bucket in the hash at KEYS[1], with the "tokens" it held
+// at "ts" (in seconds, by the clock of Redis, which every instance shares), refilling it at
+// ARGV[1] tokens per second up to ARGV[2]. It returns whether a token was taken and the tokens
+// left, as a string since Redis truncates numbers returned by scripts.
+var takeTokenScript = redis.NewScript(`
+redis.replicate_commands()
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local time = redis.call('TIME')
+local now = tonumber(time[1]) + tonumber(time[2]) / 1000000
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(bucket[1]) or burst
+local ts = tonumber(bucket[2]) or now
+tokens = math.min(burst, tokens + math.max(now - ts, 0) * rate)
+local taken = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	taken = 1
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', tostring(now))
+redis.call('PEXPIRE', KEYS[1], math.ceil((burst - tokens) / rate * 1000) + 1000)
+return {taken, tostring(tokens)}
+`)
+
+// TakeToken atomically takes a token from the bucket stored at key, which holds up to burst
+// tokens, starts full and is refilled at rate tokens per second, through a script.
+func (s *RedisStore) TakeToken(ctx context.Context, key string, rate float64, burst int64) (TokenBucket, error) {
+	client, err := redis_client.GetClientInstance()
+	if err != nil {
+		return TokenBucket{}, err
+	}
+	reply, err := takeTokenScript.Run(ctx, client, []string{key}, rate, burst).Slice()
+	if err != nil {
+		return TokenBucket{}, err
+	}
+	taken, _ := reply[0].(int64)
+	tokens, err := strconv.ParseFloat(fmt.Sprint(reply[1]), 64)
+	if err != nil {
+		return TokenBucket{}, fmt.Errorf("unexpected reply from the token bucket script: %v", reply)
+	}
+	return bucketState(taken == 1, tokens, rate, burst), nil
+}
+
+// Expire sets the time to live of an existing key, returning whether it exists.
+func (s *RedisStore) Expire(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	client, err := redis_client.GetClientInstance()
+	if err != nil {
+		return false, err
+	}
+	return client.Expire(ctx, key, ttl).Result()
+}
+
+// FlushAll removes every key from the Redis database (of every master, in a cluster).
+func (s *RedisStore) FlushAll(ctx context.Context) error {
+	client, err := redis_client.GetClientInstance()
+	if err != nil {
+		return err
+	}
+	if cluster, ok := client.(*redis.ClusterClient); ok {
+		return cluster.ForEachMaster(ctx, func(ctx context.Context, master *redis.Client) error {
+			return master.FlushAll(ctx).Err()
+		})
+	}
+	return client.FlushAll(ctx).Err()
+}
+
+// Ping checks that Redis answers, failing fast while the client singleton considers it
+// unavailable.
+func (s *RedisStore) Ping(ctx context.Context) error {
+	client, err := redis_client.GetClientInstance()
+	if err != nil {
+		return err
+	}
+	return client.Ping(ctx).Err()
+}
+
+// Close closes the Redis client singleton.
+func (s *RedisStore) Close() error {
+	return redis_client.CloseClient()
+}
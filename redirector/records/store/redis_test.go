@@ -0,0 +1,138 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	redis_client "github.com/luizcdc/redirectory/redirector/records/redis_client_singleton"
+)
+
+func TestRedisScan(t *testing.T) {
+	for _, mode := range []string{redis_client.SINGLE, redis_client.CLUSTER} {
+		t.Run(mode, func(t *testing.T) {
+			server := miniredis.RunT(t)
+			t.Setenv("REDIS_MODE", mode)
+			t.Setenv("REDIS_ADDRS", server.Addr())
+			t.Setenv("REDIS_DB", "0")
+			t.Cleanup(func() { redis_client.CloseClient() })
+			ctx := context.Background()
+			s := NewRedisStore()
+
+			for i := range 25 {
+				if err := s.Set(ctx, "DEV:key"+string(rune('a'+i)), "value", 0); err != nil {
+					t.Fatalf("Set returned error %v", err)
+				}
+			}
+			s.Set(ctx, "PROD:key", "value", 0)
+
+			seen := map[string]bool{}
+			var cursor uint64
+			for {
+				keys, next, err := s.Scan(ctx, cursor, "DEV:*", 10)
+				if err != nil {
+					t.Fatalf("Scan returned error %v", err)
+				}
+				for _, key := range keys {
+					seen[key] = true
+				}
+				if next == 0 {
+					break
+				}
+				cursor = next
+			}
+			if len(seen) != 25 {
+				t.Errorf("Scan(DEV:*) iterated over %v keys, want 25", len(seen))
+			}
+			if keys, err := s.Keys(ctx, "DEV:"); err != nil || len(keys) != 25 {
+				t.Errorf("Keys(DEV:) = %v keys, %v, want 25", len(keys), err)
+			}
+		})
+	}
+}
+
+func TestRedisTakeToken(t *testing.T) {
+	server := miniredis.RunT(t)
+	t.Setenv("REDIS_ADDRS", server.Addr())
+	t.Setenv("REDIS_DB", "0")
+	t.Cleanup(func() { redis_client.CloseClient() })
+	testTakeToken(t, NewRedisStore())
+}
+
+func TestRedisSetNXMany(t *testing.T) {
+	server := miniredis.RunT(t)
+	t.Setenv("REDIS_ADDRS", server.Addr())
+	t.Setenv("REDIS_DB", "0")
+	t.Cleanup(func() { redis_client.CloseClient() })
+	testSetNXMany(t, NewRedisStore())
+}
+
+func TestRedisDelMany(t *testing.T) {
+	server := miniredis.RunT(t)
+	t.Setenv("REDIS_ADDRS", server.Addr())
+	t.Setenv("REDIS_DB", "0")
+	t.Cleanup(func() { redis_client.CloseClient() })
+	testDelMany(t, NewRedisStore())
+}
+
+func TestRedisCompareAndSet(t *testing.T) {
+	server := miniredis.RunT(t)
+	t.Setenv("REDIS_ADDRS", server.Addr())
+	t.Setenv("REDIS_DB", "0")
+	t.Cleanup(func() { redis_client.CloseClient() })
+	testCompareAndSet(t, NewRedisStore())
+}
+
+func TestRedisSetNXManyAtomicOnCluster(t *testing.T) {
+	server := miniredis.RunT(t)
+	t.Setenv("REDIS_MODE", redis_client.CLUSTER)
+	t.Setenv("REDIS_ADDRS", server.Addr())
+	t.Setenv("REDIS_DB", "0")
+	t.Cleanup(func() { redis_client.CloseClient() })
+	ctx := context.Background()
+	s := NewRedisStore()
+
+	entries := []Entry{{Key: "a", Value: "1"}, {Key: "b", Value: "2"}}
+	if set, err := s.SetNXMany(ctx, entries, true); !errors.Is(err, ErrAtomicUnsupported) || set[0] || set[1] {
+		t.Errorf("SetNXMany(atomic) on a cluster = %v, %v, want ErrAtomicUnsupported", set, err)
+	}
+	if keys, _ := s.Keys(ctx, ""); len(keys) != 0 {
+		t.Errorf("SetNXMany(atomic) on a cluster set %v", keys)
+	}
+	if set, err := s.SetNXMany(ctx, entries[:1], true); err != nil || !set[0] {
+		t.Errorf("SetNXMany(atomic) of a single key on a cluster = %v, %v, want [true], nil", set, err)
+	}
+}
+
+func TestRedisApplyDeltas(t *testing.T) {
+	for _, mode := range []string{redis_client.SINGLE, redis_client.CLUSTER} {
+		t.Run(mode, func(t *testing.T) {
+			server := miniredis.RunT(t)
+			t.Setenv("REDIS_MODE", mode)
+			t.Setenv("REDIS_ADDRS", server.Addr())
+			t.Setenv("REDIS_DB", "0")
+			t.Cleanup(func() { redis_client.CloseClient() })
+			ctx := context.Background()
+			s := NewRedisStore()
+
+			deltas := NewDeltas()
+			deltas.Counters["a"] = 2
+			deltas.Hashes["h"] = map[string]int64{"x": 1, "y": 3}
+			deltas.Expirations["h"] = time.Hour
+			if unapplied, err := s.ApplyDeltas(ctx, deltas); err != nil || len(unapplied.Counters) != 0 || len(unapplied.Hashes) != 0 {
+				t.Fatalf("ApplyDeltas = %+v, %v, want nothing unapplied", unapplied, err)
+			}
+			if value, _ := s.Get(ctx, "a"); value != "2" {
+				t.Errorf("counter a = %v, want 2", value)
+			}
+			if fields, _ := s.HGetAll(ctx, "h"); fields["x"] != 1 || fields["y"] != 3 {
+				t.Errorf("hash h = %v, want x 1 and y 3", fields)
+			}
+			if ttl, _ := s.TTL(ctx, "h"); ttl <= 0 || ttl > time.Hour {
+				t.Errorf("TTL(h) = %v, want up to an hour", ttl)
+			}
+		})
+	}
+}
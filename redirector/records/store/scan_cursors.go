@@ -0,0 +1,65 @@
+package store
+
+import (
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// SCAN_CURSOR_TTL is how long the cursors returned by the Scan of the stores kept in the process
+// can be used.
+const SCAN_CURSOR_TTL = time.Hour
+
+// scanCursors are the cursors of the Scan of a store that iterates over its keys in
+// lexicographical order, each referring to the last key of the batch before it, so that a batch
+// resumes strictly after it whatever keys were set or deleted in the meantime.
+type scanCursors struct {
+	mu      sync.Mutex
+	cursors map[uint64]scanCursor
+}
+
+// scanCursor is where the next batch of a Scan starts: after the key after.
+type scanCursor struct {
+	after     string
+	expiresAt time.Time
+}
+
+// save returns a cursor for the batch of a Scan after the key after, dropping the expired
+// cursors.
+func (c *scanCursors) save(after string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if c.cursors == nil {
+		c.cursors = map[uint64]scanCursor{}
+	}
+	for cursor, saved := range c.cursors {
+		if hasExpired(saved.expiresAt, now) {
+			delete(c.cursors, cursor)
+		}
+	}
+	var cursor uint64
+	for cursor == 0 {
+		cursor = rand.Uint64()
+		if _, taken := c.cursors[cursor]; taken {
+			cursor = 0
+		}
+	}
+	c.cursors[cursor] = scanCursor{after: after, expiresAt: now.Add(SCAN_CURSOR_TTL)}
+	return cursor
+}
+
+// after returns the key after which the batch of a cursor starts, "" for the cursor 0 of the
+// first batch, or ErrInvalidCursor if the cursor is unknown or expired.
+func (c *scanCursors) after(cursor uint64) (string, error) {
+	if cursor == 0 {
+		return "", nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	saved, ok := c.cursors[cursor]
+	if !ok || hasExpired(saved.expiresAt, time.Now()) {
+		return "", ErrInvalidCursor
+	}
+	return saved.after, nil
+}
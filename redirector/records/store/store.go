@@ -0,0 +1,124 @@
+/*
+Package store defines Store, the key-value storage abstraction the records package is written
+against, along with its available backends: Redis (the production backend), an in-memory map
+(useful for tests and for running the redirector locally without a Redis server) and a BoltDB
+file (for small deployments).
+*/
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrNotFound is returned by every backend when the requested key does not exist or has expired.
+var ErrNotFound = errors.New("key not found")
+
+// ErrInvalidCursor is returned by the backends whose Scan cursors refer to state they keep, when
+// given a cursor they didn't return or that expired.
+var ErrInvalidCursor = errors.New("invalid or expired cursor")
+
+// ErrAtomicUnsupported is returned by the backends that can't set many keys atomically, such as
+// a Redis cluster, whose keys are spread across nodes.
+var ErrAtomicUnsupported = errors.New("the store can't set many keys atomically")
+
+// Deltas are increments to many counters and hash fields, applied at once by ApplyDeltas.
+type Deltas struct {
+	Counters map[string]int64
+	Hashes   map[string]map[string]int64
+	// Expirations are the times to live set on keys after they are incremented.
+	Expirations map[string]time.Duration
+}
+
+// NewDeltas constructs empty Deltas.
+func NewDeltas() Deltas {
+	return Deltas{
+		Counters:    map[string]int64{},
+		Hashes:      map[string]map[string]int64{},
+		Expirations: map[string]time.Duration{},
+	}
+}
+
+// Entry is a key to be set along with its value and time to live.
+type Entry struct {
+	Key   string
+	Value string
+	TTL   time.Duration
+}
+
+// TokenBucket is the state of a token bucket after TakeToken tried to take a token from it.
+type TokenBucket struct {
+	// Taken indicates whether there was a token to take.
+	Taken bool
+	// Remaining is the number of whole tokens left in the bucket.
+	Remaining int64
+	// RetryAfter is how long until the bucket has a token again, 0 if it has one.
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+}
+
+// Store is a string key-value store with per-key expiration and integer counters.
+// A ttl of 0 means the key never expires.
+type Store interface {
+	// Get retrieves the value of a key, returning ErrNotFound if it doesn't exist.
+	Get(ctx context.Context, key string) (string, error)
+	// Set sets the value of a key, overwriting it if it already exists.
+	Set(ctx context.Context, key string, value string, ttl time.Duration) error
+	// SetNX sets the value of a key only if it doesn't exist yet, returning whether it did.
+	SetNX(ctx context.Context, key string, value string, ttl time.Duration) (bool, error)
+	// SetNXMany sets each entry whose key doesn't exist yet, returning which ones were set. If
+	// atomic is true, either every entry is set or, if any key exists, none is, or
+	// ErrAtomicUnsupported is returned without setting any. If it fails, the entries it reports
+	// as set were set nonetheless.
+	SetNXMany(ctx context.Context, entries []Entry, atomic bool) ([]bool, error)
+	// Update sets the value of a key only if it already exists, returning whether it did. If
+	// keepTTL is true, ttl is ignored and the key's current expiration is preserved.
+	Update(ctx context.Context, key string, value string, ttl time.Duration, keepTTL bool) (bool, error)
+	// CompareAndSet sets the value of a key only if its current value is old, returning whether
+	// it did. If keepTTL is true, ttl is ignored and the key's current expiration is preserved.
+	CompareAndSet(ctx context.Context, key string, old string, value string, ttl time.Duration, keepTTL bool) (bool, error)
+	// TTL returns the remaining time to live of a key, 0 if it never expires, or ErrNotFound if
+	// it doesn't exist.
+	TTL(ctx context.Context, key string) (time.Duration, error)
+	// Del deletes a key, returning whether it existed.
+	Del(ctx context.Context, key string) (bool, error)
+	// DelMany deletes many keys at once, returning which ones existed. If it fails, the keys it
+	// reports as existing were deleted nonetheless.
+	DelMany(ctx context.Context, keys []string) ([]bool, error)
+	// Keys lists all keys starting with prefix.
+	Keys(ctx context.Context, prefix string) ([]string, error)
+	// Scan iterates over the keys matching a glob pattern, returning a batch of about count keys
+	// starting at cursor and the cursor of the next batch, which is 0 after the last one. Like
+	// Redis' SCAN, a batch may have fewer than count keys, or none, before the iteration ends.
+	Scan(ctx context.Context, cursor uint64, match string, count int64) ([]string, uint64, error)
+	// IncrBy increments the integer stored at key by delta, creating it if necessary, and
+	// returns the new value.
+	IncrBy(ctx context.Context, key string, delta int64) (int64, error)
+	// HIncrBy increments each field of the hash stored at key by its delta, creating the hash
+	// and the fields as necessary.
+	HIncrBy(ctx context.Context, key string, deltas map[string]int64) error
+	// HGetAll retrieves every field of the hash stored at key, which is empty if it doesn't
+	// exist.
+	HGetAll(ctx context.Context, key string) (map[string]int64, error)
+	// HGetAllMany retrieves every field of the hashes stored at many keys at once, in the same
+	// order, each being empty if it doesn't exist.
+	HGetAllMany(ctx context.Context, keys []string) ([]map[string]int64, error)
+	// ApplyDeltas applies every increment in deltas, then sets the expirations. If it fails, it
+	// returns the deltas that weren't applied, so that only those are retried.
+	ApplyDeltas(ctx context.Context, deltas Deltas) (Deltas, error)
+	// TakeToken atomically takes a token from the bucket stored at key, which holds up to burst
+	// tokens, starts full and is refilled at rate tokens per second. The key expires once the
+	// bucket would be full again.
+	TakeToken(ctx context.Context, key string, rate float64, burst int64) (TokenBucket, error)
+	// Expire sets the time to live of an existing key, returning whether it exists. Like Redis'
+	// EXPIRE, a ttl that isn't positive deletes the key.
+	Expire(ctx context.Context, key string, ttl time.Duration) (bool, error)
+	// FlushAll removes every key from the store.
+	FlushAll(ctx context.Context) error
+	// Ping checks that the store can be reached.
+	Ping(ctx context.Context) error
+	// Close releases the resources held by the store.
+	Close() error
+}
@@ -0,0 +1,63 @@
+package store
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// refillBucket refills a bucket holding tokens, last updated elapsed ago, then takes a token from
+// it if it has one, returning the tokens left along with the resulting TokenBucket.
+func refillBucket(tokens float64, elapsed time.Duration, rate float64, burst int64) (float64, TokenBucket) {
+	tokens = math.Min(float64(burst), tokens+math.Max(elapsed.Seconds(), 0)*rate)
+	taken := tokens >= 1
+	if taken {
+		tokens--
+	}
+	return tokens, bucketState(taken, tokens, rate, burst)
+}
+
+// bucketState describes a bucket holding tokens after trying to take one from it.
+func bucketState(taken bool, tokens float64, rate float64, burst int64) TokenBucket {
+	bucket := TokenBucket{
+		Taken:     taken,
+		Remaining: int64(math.Floor(tokens)),
+		Reset:     secondsToDuration((float64(burst) - tokens) / rate),
+	}
+	if tokens < 1 {
+		bucket.RetryAfter = secondsToDuration((1 - tokens) / rate)
+	}
+	return bucket
+}
+
+// secondsToDuration converts a number of seconds into a Duration, rounded up to the millisecond.
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(math.Ceil(seconds*1000)) * time.Millisecond
+}
+
+// encodeBucket serializes the tokens of a bucket and the time they were counted, as kept by the
+// memory and file stores.
+func encodeBucket(tokens float64, at time.Time) string {
+	return fmt.Sprintf("%v %v", tokens, at.UnixNano())
+}
+
+// decodeBucket is the inverse of encodeBucket, returning ok false if value isn't a bucket.
+func decodeBucket(value string) (tokens float64, at time.Time, ok bool) {
+	var nanos int64
+	if _, err := fmt.Sscanf(value, "%g %d", &tokens, &nanos); err != nil {
+		return 0, time.Time{}, false
+	}
+	return tokens, time.Unix(0, nanos), true
+}
+
+// takeTokenFrom takes a token from the bucket kept in entry (a full one if it doesn't exist),
+// returning the updated entry.
+func takeTokenFrom(entry memoryEntry, exists bool, rate float64, burst int64) (memoryEntry, TokenBucket) {
+	now := time.Now()
+	tokens, at, ok := decodeBucket(entry.value)
+	if !exists || !ok {
+		tokens, at = float64(burst), now
+	}
+	tokens, bucket := refillBucket(tokens, now.Sub(at), rate, burst)
+	return memoryEntry{value: encodeBucket(tokens, now), expiresAt: now.Add(bucket.Reset + time.Second)}, bucket
+}
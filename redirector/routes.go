@@ -1,12 +1,15 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"math"
 	"math/rand"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -14,35 +17,100 @@ import (
 	"time"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/luizcdc/redirectory/redirector/metrics"
 	"github.com/luizcdc/redirectory/redirector/records"
+	"github.com/luizcdc/redirectory/redirector/tracing"
 )
 
 type Auth struct {
 	handler httprouter.Router
 }
 
-// ServeHTTP is implements the http.Handler interface for the Auth struct, checking the
-// Authorization header for the API_KEY before serving the request.
+// ServeHTTP is implements the http.Handler interface for the Auth struct, authenticating the
+// API key in the Authorization header before serving the request with the key in its context.
+// Requests without an Authorization header are only served if AUTH_DISABLED is set. The requests
+// are first limited by the AUTH_RATE_LIMIT of their client's address, so that API keys can't be
+// guessed.
 func (a *Auth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if API_KEY != "" && r.Header.Get("Authorization") != fmt.Sprintf("Bearer %s", API_KEY) {
+	if !allowRequest(w, r, AUTH_RATE_LIMIT, rateLimitClient(r)) {
+		return
+	}
+	key, err := authenticate(r)
+	if errors.Is(err, records.ErrInvalidAPIKey) {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
+	} else if err != nil {
+		http.Error(w, "failure authenticating the API key", storeErrorStatus(err))
+		return
 	}
-	a.handler.ServeHTTP(w, r)
+	a.handler.ServeHTTP(w, r.WithContext(withAPIKey(r.Context(), key)))
 }
 
 // createAuthSubRouter initializes an auth-only subrouter, setting up routes and handlers.
 func CreateAuthSubRouter() *Auth {
 	requireAuthRouter := httprouter.New()
-	requireAuthRouter.POST(API_ROOT+"set/:path", SetSpecificRedirect)
-	requireAuthRouter.POST(API_ROOT+"set", SetRandomRedirect)
-	requireAuthRouter.DELETE(API_ROOT+"del/:path", DelRedirect)
-	requireAuthRouter.GET(API_ROOT+"stats/urlcount", GetTotalSetRedirects)
-	requireAuthRouter.GET(API_ROOT+"stats/redirectcount", GetTotalServedRedirects)
+	requireAuthRouter.POST(API_ROOT+"set/:path", apiRoute("SetSpecificRedirect", records.SCOPE_LINKS_WRITE, SetSpecificRedirect))
+	requireAuthRouter.POST(API_ROOT+"set", apiRoute("SetRandomRedirect", records.SCOPE_LINKS_WRITE, SetRandomRedirect))
+	requireAuthRouter.POST(API_ROOT+"bulk/set", apiRoute("BulkSetRedirects", records.SCOPE_LINKS_WRITE, BulkSetRedirects))
+	requireAuthRouter.PUT(API_ROOT+"set/:path", apiRoute("UpdateRedirect", records.SCOPE_LINKS_WRITE, UpdateRedirect))
+	requireAuthRouter.PATCH(API_ROOT+"set/:path", apiRoute("UpdateRedirect", records.SCOPE_LINKS_WRITE, UpdateRedirect))
+	requireAuthRouter.GET(API_ROOT+"get/:path", apiRoute("GetRedirect", records.SCOPE_LINKS_READ, GetRedirect))
+	requireAuthRouter.GET(API_ROOT+"list", apiRoute("ListRedirects", records.SCOPE_LINKS_READ, ListRedirects))
+	requireAuthRouter.GET(API_ROOT+"export", apiRoute("ExportRedirects", records.SCOPE_LINKS_READ, ExportRedirects))
+	requireAuthRouter.POST(API_ROOT+"import", apiRoute("ImportRedirects", records.SCOPE_LINKS_WRITE, ImportRedirects))
+	requireAuthRouter.DELETE(API_ROOT+"del/:path", apiRoute("DelRedirect", records.SCOPE_LINKS_DELETE, DelRedirect))
+	requireAuthRouter.POST(API_ROOT+"bulk/del", apiRoute("BulkDelRedirects", records.SCOPE_LINKS_DELETE, BulkDelRedirects))
+	requireAuthRouter.POST(API_ROOT+"bulk/del/filter", apiRoute("StartDeleteJob", records.SCOPE_LINKS_DELETE, StartDeleteJob))
+	requireAuthRouter.GET(API_ROOT+"jobs/:id", apiRoute("GetDeleteJob", records.SCOPE_LINKS_DELETE, GetDeleteJob))
+	requireAuthRouter.DELETE(API_ROOT+"jobs/:id", apiRoute("CancelDeleteJob", records.SCOPE_LINKS_DELETE, CancelDeleteJob))
+	// httprouter doesn't allow static routes alongside a parameter in the same segment, so
+	// GetStats serves /api/stats/urlcount, /api/stats/redirectcount and /api/stats/droppedcount too.
+	requireAuthRouter.GET(API_ROOT+"stats/:path", apiRoute("GetStats", records.SCOPE_STATS_READ, GetStats))
+	requireAuthRouter.POST(API_ROOT+"keys", apiRoute("CreateAPIKey", records.SCOPE_KEYS_ADMIN, CreateAPIKey))
+	requireAuthRouter.GET(API_ROOT+"keys", apiRoute("ListAPIKeys", records.SCOPE_KEYS_ADMIN, ListAPIKeys))
+	requireAuthRouter.DELETE(API_ROOT+"keys/:id", apiRoute("RevokeAPIKey", records.SCOPE_KEYS_ADMIN, RevokeAPIKey))
 	AuthSubRouter := &Auth{*requireAuthRouter}
 	return AuthSubRouter
 }
 
+// apiRoute wraps the handle of an API route, which requires its API key to have been granted
+// scope, is rate limited and is instrumented under the given route name.
+func apiRoute(route string, scope string, handle httprouter.Handle) httprouter.Handle {
+	return instrument(route, limitRate(route, requireScope(scope, handle)))
+}
+
+// statusRecorder is an http.ResponseWriter that remembers the status code of the reply.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap allows http.ResponseController to flush the underlying http.ResponseWriter.
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// instrument wraps the handle of a route, tracing its requests in a span named after the route
+// and observing their status codes and latency in the metrics.
+func instrument(route string, handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		start := time.Now()
+		ctx, span := tracing.StartRoute(r, route)
+		recorder := &statusRecorder{w, http.StatusOK}
+		// Deferred, so that the replies aborted with http.ErrAbortHandler are observed too.
+		defer func() {
+			tracing.EndRoute(span, recorder.status)
+			metrics.ObserveRequest(route, recorder.status, time.Since(start))
+		}()
+		handle(recorder, r.WithContext(ctx), ps)
+	}
+}
+
 func DefineRoutes(AuthSubRouter *Auth) *httprouter.Router {
 	router := httprouter.New()
 
@@ -50,11 +118,65 @@ func DefineRoutes(AuthSubRouter *Auth) *httprouter.Router {
 	router.Handler(http.MethodPost, API_ROOT+"*any", AuthSubRouter)
 	router.Handler(http.MethodDelete, API_ROOT+"*any", AuthSubRouter)
 	router.Handler(http.MethodPut, API_ROOT+"*any", AuthSubRouter)
+	router.Handler(http.MethodPatch, API_ROOT+"*any", AuthSubRouter)
 
-	router.GET("/:redirectpath", Redirect)
+	router.GET("/:redirectpath", ServeRootPath)
 	return router
 }
 
+// The paths of the endpoints served at the root, without authentication. httprouter doesn't
+// allow static routes alongside /:redirectpath, so they are served by ServeRootPath, and no
+// redirect can be set for them.
+const LIVENESS_PATH = "healthz"
+const READINESS_PATH = "readyz"
+const METRICS_PATH = "metrics"
+
+// isReservedPath indicates whether path is taken by an endpoint, so it can't have a redirect.
+func isReservedPath(path string) bool {
+	return path == LIVENESS_PATH || path == READINESS_PATH || path == METRICS_PATH
+}
+
+// instrumentedRedirect is Redirect, rate limited by client address, observed by the metrics and
+// traced, and metricsHandler serves the metrics.
+var instrumentedRedirect = instrument("Redirect", limitRate("Redirect", Redirect))
+var metricsHandler = metrics.Handler()
+
+// ServeRootPath serves the paths at the root: "healthz", "readyz" and "metrics" are served by
+// Healthz, Readyz and the metrics handler, and every other path by Redirect.
+func ServeRootPath(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	switch strings.Trim(ps.ByName("redirectpath"), "/") {
+	case LIVENESS_PATH:
+		Healthz(w, r, ps)
+	case READINESS_PATH:
+		Readyz(w, r, ps)
+	case METRICS_PATH:
+		metricsHandler.ServeHTTP(w, r)
+	default:
+		instrumentedRedirect(w, r, ps)
+	}
+}
+
+// setRedirectBody is the JSON body expected by the endpoints that set redirects.
+type setRedirectBody struct {
+	Url        string   `json:"url"`
+	Duration   uint     `json:"duration"`
+	StatusCode int      `json:"status_code"`
+	Tags       []string `json:"tags"`
+	Notes      string   `json:"notes"`
+	// Overwrite allows SetSpecificRedirect to replace an existing redirect.
+	Overwrite bool `json:"overwrite"`
+}
+
+// setRedirectReply is the JSON reply of the endpoints that set redirects.
+type setRedirectReply struct {
+	Error    interface{} `json:"error"`
+	Path     string      `json:"path"`
+	Duration uint        `json:"duration"`
+	// TTL is the remaining time to live in seconds, absent if the redirect never expires.
+	TTL    *int64          `json:"ttl,omitempty"`
+	Record *records.Record `json:"record,omitempty"`
+}
+
 // setErrorJSONReply is a higher-order function that returns a function
 // responsible for sending a JSON response with the specified status code
 // and error message in the "error" field.
@@ -75,11 +197,7 @@ func DefineRoutes(AuthSubRouter *Auth) *httprouter.Router {
 func setErrorJSONReply(w http.ResponseWriter) func(int, string) {
 	return func(status int, err string) {
 		w.WriteHeader(status)
-		resp, _ := json.Marshal(struct {
-			Error    string `json:"error"`
-			Path     string `json:"path"`
-			Duration uint   `json:"duration"`
-		}{err, "", 0})
+		resp, _ := json.Marshal(setRedirectReply{err, "", 0, nil, nil})
 		w.Write(resp)
 	}
 }
@@ -93,117 +211,236 @@ func setErrorJSONReply(w http.ResponseWriter) func(int, string) {
 //
 // Returns:
 //
-//	A function (path string, duration uint) that sends a JSON response with the specified
+//	A function (path string, record records.Record) that sends a JSON response with the
 //
-// path and duration in the "path" and "duration" fields, respectively.
+// specified path, the record's duration, its remaining time to live and the full record in the
+// "path", "duration", "ttl" and "record" fields, respectively.
 //
 // Example usage:
 //
 //	successHandler := setSuccessJSONReply(w)
-//	successHandler("path", 10)
-func setSuccessJSONReply(w http.ResponseWriter) func(string, uint) {
-	return func(path string, duration uint) {
+//	successHandler("path", records.NewRecord("https://example.com", 10))
+func setSuccessJSONReply(w http.ResponseWriter) func(string, records.Record) {
+	return func(path string, record records.Record) {
+		w.Header().Set("ETag", record.ETag())
 		w.WriteHeader(http.StatusOK)
-		resp, _ := json.Marshal(struct {
-			Error    interface{} `json:"error"`
-			Path     string      `json:"path"`
-			Duration uint        `json:"duration"`
-		}{nil, path, duration})
+		resp, _ := json.Marshal(setRedirectReply{nil, path, record.Duration, ttlSeconds(record.TTL()), &record})
 		w.Write(resp)
 	}
 }
 
+// storeErrorStatus returns the status code of the reply to a request that failed because of err,
+// an error from the store: 504 if the store didn't answer in time, 503 if the request was
+// canceled before it did (usually because the client went away) and 500 otherwise.
+func storeErrorStatus(err error) int {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return http.StatusGatewayTimeout
+	case errors.Is(err, context.Canceled):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// validateTarget checks that rawUrl is a valid absolute url and that statusCode is either 0 (the
+// default) or a redirect status code, returning the normalized url. If any check fails, it
+// replies to the request with an error and returns false.
+func validateTarget(rawUrl string, statusCode int, replyError func(int, string)) (string, bool) {
+	parsedUrl, err := url.Parse(rawUrl)
+	switch {
+	case err != nil:
+		slog.Debug("invalid target url", "url", rawUrl, "error", err)
+		replyError(http.StatusBadRequest, fmt.Sprintf("the provided url is invalid: %v", err.Error()))
+		return "", false
+	case !parsedUrl.IsAbs():
+		replyError(http.StatusBadRequest, "the provided url must be absolute")
+		return "", false
+	case statusCode != 0 && !records.IsRedirectStatusCode(statusCode):
+		replyError(http.StatusBadRequest, fmt.Sprintf("%v is not a valid redirect status code", statusCode))
+		return "", false
+	}
+	return parsedUrl.String(), true
+}
+
+// readRecordFromBody reads a setRedirectBody from the request and validates it, creating the
+// record it describes. If the body is invalid, it replies to the request with an error and
+// returns false.
+func readRecordFromBody(r *http.Request, replyError func(int, string)) (records.Record, setRedirectBody, bool) {
+	var jsonBody setRedirectBody
+	buffer, sizeRead, err := readJSONIntoBuffer(r, replyError)
+	if err != nil {
+		slog.DebugContext(r.Context(), "invalid request body", "error", err)
+		return records.Record{}, jsonBody, false
+	}
+
+	if err := json.Unmarshal(buffer[:sizeRead], &jsonBody); err != nil {
+		slog.DebugContext(r.Context(), "failure parsing the request's body", "error", err)
+		replyError(http.StatusBadRequest, fmt.Sprintf("error parsing json in the request's body: %v", err.Error()))
+		return records.Record{}, jsonBody, false
+	}
+	record, ok := recordFromBody(r.Context(), jsonBody, replyError)
+	return record, jsonBody, ok
+}
+
+// recordFromBody validates a setRedirectBody, creating the record it describes on behalf of the
+// API key of ctx. If the body is invalid, it replies with an error and returns false.
+func recordFromBody(ctx context.Context, jsonBody setRedirectBody, replyError func(int, string)) (records.Record, bool) {
+	targetUrl, ok := validateTarget(jsonBody.Url, jsonBody.StatusCode, replyError)
+	if !ok {
+		return records.Record{}, false
+	}
+
+	duration := DEFAULT_DURATION
+	if jsonBody.Duration != 0 {
+		duration = jsonBody.Duration
+	}
+
+	record := records.NewRecord(targetUrl, duration)
+	if jsonBody.StatusCode != 0 {
+		record.StatusCode = jsonBody.StatusCode
+	}
+	if jsonBody.Tags != nil {
+		record.Tags = jsonBody.Tags
+	}
+	record.Notes = jsonBody.Notes
+	record.CreatedBy = apiKeyFromContext(ctx).ID
+	return record, true
+}
+
+// validatePath checks that a redirect can be set for path, which must be at least 4 characters
+// long and not reserved. If it can't, it replies with an error and returns false.
+func validatePath(path string, replyError func(int, string)) bool {
+	if len(path) < 4 {
+		replyError(http.StatusBadRequest, "path must be at least 4 characters long")
+		return false
+	}
+	if isReservedPath(path) {
+		replyError(http.StatusBadRequest, fmt.Sprintf("'%v' is reserved and can't be redirected", path))
+		return false
+	}
+	return true
+}
+
 // SetSpecificRedirect sets a redirect for a given path.
 // It expects a JSON payload in the request body with the following structure:
 //
 //	{
 //	  "url": "https://example.com",
-//	  "duration": 10
+//	  "duration": 10,
+//	  "status_code": 301,
+//	  "tags": ["campaign"],
+//	  "notes": "free text"
 //	}
 //
 // The "url" field specifies the target URL for the redirect, and the "duration" field (optional)
-// specifies the duration of the redirect in seconds.
+// specifies the duration of the redirect in seconds. The "status_code" (optional, defaults to
+// 307), "tags" and "notes" fields (both optional) are stored along with the redirect.
+//
+// An existing redirect for the same path is never replaced, unless the body has
+// "overwrite": true or the request has an If-Match header with either "*" or the redirect's
+// current ETag (as returned by GetRedirect). Otherwise, the reply has a 409 status and the current
+// redirect in the "record" field. An If-Match header that doesn't match the current redirect
+// results in a 412 status.
 // The function returns a JSON response indicating the success or failure of setting the redirect.
 // If the redirect is set successfully, the response will be:
 //
-//		{
-//		  "error": null,
-//	   "path": "path"
-//	   "duration": 10
-//		}
+//	{
+//	  "error": null,
+//	  "path": "path",
+//	  "duration": 10,
+//	  "ttl": 10,
+//	  "record": {"url": "https://example.com", "created_at": "...", ...}
+//	}
 //
+// where "ttl" is the remaining time to live in seconds, absent if the redirect never expires.
 // If there is an error in setting the redirect, the response will be:
 //
 //	{
 //	  "error": "failure message"
 //	}
 func SetSpecificRedirect(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	type setRedirectBody struct {
-		Url      string `json:"url"`
-		Duration uint   `json:"duration"`
-	}
-
 	replyError := setErrorJSONReply(w)
 	replySuccess := setSuccessJSONReply(w)
 
 	w.Header().Add("Content-Type", APPLICATION_JSON)
 
-	if len(ps.ByName("path")) < 4 {
-		replyError(http.StatusBadRequest, "path must be at least 4 characters long")
-		return
-	}
 	from := ps.ByName("path")
-
-	buffer, sizeRead, err := readJSONIntoBuffer(r, replyError)
-	if err != nil {
-		log.Println(err.Error())
+	if !validatePath(from, replyError) {
 		return
 	}
 
-	var jsonBody setRedirectBody
-	if err := json.Unmarshal(buffer[:sizeRead], &jsonBody); err != nil {
-		log.Println(err)
-		replyError(http.StatusBadRequest, fmt.Sprintf("error parsing json in the request's body: %v", err.Error()))
-		return
-	}
-	parsedUrl, err := url.Parse(jsonBody.Url)
-	switch {
-	case err != nil:
-		log.Println(err)
-		replyError(http.StatusBadRequest, fmt.Sprintf("the provided url is invalid: %v", err.Error()))
-		return
-	case !parsedUrl.IsAbs():
-		log.Println(err)
-		replyError(http.StatusBadRequest, "the provided url must be absolute")
+	record, jsonBody, ok := readRecordFromBody(r, replyError)
+	if !ok {
 		return
 	}
 
-	duration := DEFAULT_DURATION
-	if jsonBody.Duration != 0 {
-		duration = jsonBody.Duration
+	ctx := r.Context()
+	var err error
+	switch ifMatch := r.Header.Get("If-Match"); {
+	case ifMatch != "":
+		// The redirect is only replaced if it wasn't changed since it matched.
+		err = records.ReplaceRecord(ctx, from, record, func(current records.Record) error {
+			if ifMatch != "*" && ifMatch != current.ETag() {
+				return errPreconditionFailed
+			}
+			return nil
+		})
+		if errors.Is(err, records.ErrNotFound) || errors.Is(err, errPreconditionFailed) || errors.Is(err, records.ErrConcurrentUpdate) {
+			replyError(http.StatusPreconditionFailed, fmt.Sprintf("the redirect for '%v' doesn't match '%v'", from, ifMatch))
+			return
+		}
+	case jsonBody.Overwrite:
+		err = records.SetRecord(ctx, from, record)
+	default:
+		var created bool
+		created, err = records.CreateRecord(ctx, from, record)
+		if err == nil && !created {
+			replyConflict(ctx, w, from)
+			return
+		}
 	}
 
-	if records.SetKey(from, parsedUrl.String(), time.Duration(duration)*time.Second) {
-		log.Printf("Success setting '%v' to '%v', for '%v' seconds\n", from, parsedUrl.String(), duration)
-		replySuccess(from, duration)
+	if err == nil {
+		slog.InfoContext(ctx, "set redirect", "path", from, "url", record.URL, "duration", record.Duration)
+		replySuccess(from, record)
 		return
 	}
 
-	replyError(http.StatusInternalServerError, fmt.Sprintf("failure setting '%v' to '%v'", from, parsedUrl.String()))
-	log.Printf("Failure setting '%v' to '%v'\n", from, parsedUrl.String())
+	replyError(storeErrorStatus(err), fmt.Sprintf("failure setting '%v' to '%v'", from, record.URL))
+}
 
+// replyConflict replies that the path already has a redirect, which is returned in the "record"
+// field.
+func replyConflict(ctx context.Context, w http.ResponseWriter, path string) {
+	current, err := records.GetRecord(ctx, path)
+	if err != nil {
+		// The redirect was removed in the meantime.
+		setErrorJSONReply(w)(http.StatusConflict, fmt.Sprintf("'%v' was just set by another request", path))
+		return
+	}
+	w.Header().Set("ETag", current.ETag())
+	w.WriteHeader(http.StatusConflict)
+	resp, _ := json.Marshal(setRedirectReply{
+		fmt.Sprintf("'%v' already redirects to '%v'", path, current.URL), path, current.Duration, ttlSeconds(current.TTL()), &current,
+	})
+	w.Write(resp)
 }
 
 // SetRandomRedirect sets a random redirect URL with a specified duration.
 // The function reads a JSON body from the request, parses the URL, and generates a random string
 // which will be the path that will redirect to the specified URL.
-// The duration of the redirect can be specified in the JSON body, otherwise it follows the default.
+// The body has the same structure as the one expected by SetSpecificRedirect, so the duration
+// of the redirect can be specified in the JSON body, otherwise it follows the default.
 // The function returns a JSON response with the generated string as the path of the redirect.
 // If the redirect is set successfully, the response will be:
 //
 //	{
 //	  "error": null,
-//	  "path": "generated_path"
+//	  "path": "generated_path",
+//	  "duration": 10,
+//	  "ttl": 10,
+//	  "record": {"url": "https://example.com", "created_at": "...", ...}
 //	}
 //
 // If any errors occur during the process, an appropriate error response is returned:
@@ -213,68 +450,183 @@ func SetSpecificRedirect(w http.ResponseWriter, r *http.Request, ps httprouter.P
 //	  "path": ""
 //	}
 func SetRandomRedirect(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	type setRandomRedirectBody struct {
-		Url      string `json:"url"`
-		Duration uint   `json:"duration"`
-	}
-
 	replyError := setErrorJSONReply(w)
 	replySuccess := setSuccessJSONReply(w)
 
 	w.Header().Add("Content-Type", APPLICATION_JSON)
-	buffer, sizeRead, err := readJSONIntoBuffer(r, replyError)
-	if err != nil {
-		log.Println(err)
-		return
-	}
 
-	var jsonBody setRandomRedirectBody
-	if err := json.Unmarshal(buffer[:sizeRead], &jsonBody); err != nil {
-		replyError(http.StatusBadRequest, err.Error())
-		log.Println(err)
+	record, _, ok := readRecordFromBody(r, replyError)
+	if !ok {
 		return
 	}
 
-	parsedUrl, err := url.Parse(jsonBody.Url)
-	switch {
-	case err != nil:
-		replyError(http.StatusBadRequest, err.Error())
-		log.Println(err)
-		return
-	case !parsedUrl.IsAbs():
-		replyError(http.StatusBadRequest, "the provided url must be absolute")
+	chosen, err := createRandomRedirect(r.Context(), record)
+	if err != nil {
+		replyError(storeErrorStatus(err), fmt.Sprintf("failure setting a random path to '%v'", record.URL))
 		return
 	}
+	replySuccess(chosen, record)
+	slog.InfoContext(r.Context(), "set redirect", "path", chosen, "url", record.URL, "duration", record.Duration)
+}
 
+// randomFreePath chooses a random path that isn't reserved, doesn't have a redirect and for
+// which skip, if not nil, returns false.
+func randomFreePath(ctx context.Context, skip func(string) bool) (string, error) {
 	nPossibilities := int32(math.Pow(float64(len(ALLOWED_CHARS)), float64(RANDOM_SIZE)))
-
-	var chosen string
 	for {
-		var err error
-		chosen, err = intToString.IntegerToString(uint32(rand.Int31n(nPossibilities)))
+		chosen, err := intToString.IntegerToString(uint32(rand.Int31n(nPossibilities)))
 		if err != nil {
-			replyError(http.StatusInternalServerError, err.Error())
-			return
+			return "", err
+		}
+		if isReservedPath(chosen) || skip != nil && skip(chosen) {
+			metrics.IncrRandomPathCollisions()
+			continue
 		}
-		if _, err := records.GetString(chosen); err != nil {
-			break
+		if _, err := records.GetRecord(ctx, chosen); err == nil {
+			metrics.IncrRandomPathCollisions()
+			continue
 		}
+		return chosen, nil
 	}
+}
 
-	duration := DEFAULT_DURATION
-	if jsonBody.Duration != 0 {
-		duration = jsonBody.Duration
+// createRandomRedirect creates the redirect of record at a random path, which it returns.
+func createRandomRedirect(ctx context.Context, record records.Record) (string, error) {
+	// The path is only taken when the record is created, so that concurrent requests that chose
+	// the same path don't overwrite each other.
+	for {
+		chosen, err := randomFreePath(ctx, nil)
+		if err != nil {
+			return "", err
+		}
+		created, err := records.CreateRecord(ctx, chosen, record)
+		if err != nil || created {
+			return chosen, err
+		}
+		metrics.IncrRandomPathCollisions()
 	}
+}
 
-	if records.SetKey(chosen, parsedUrl.String(), time.Duration(duration)*time.Second) {
-		replySuccess(chosen, duration)
-		log.Printf("Success setting '%v' to '%v'\n", chosen, parsedUrl.String())
+// errPreconditionFailed and errInvalidUpdate stop the changes of SetSpecificRedirect and
+// UpdateRedirect to a redirect that doesn't match the If-Match header or would become invalid.
+var errPreconditionFailed = errors.New("the redirect doesn't match the precondition")
+var errInvalidUpdate = errors.New("the updated redirect is invalid")
+
+// updateRedirectBody is the JSON body expected by UpdateRedirect. Fields that are null or
+// absent are left unchanged by PATCH requests and reset to their defaults by PUT requests.
+type updateRedirectBody struct {
+	Url      *string `json:"url"`
+	Duration *uint   `json:"duration"`
+	// KeepTTL preserves the remaining time to live of the redirect, and can't be used along
+	// with Duration.
+	KeepTTL    bool      `json:"keep_ttl"`
+	StatusCode *int      `json:"status_code"`
+	Tags       *[]string `json:"tags"`
+	Notes      *string   `json:"notes"`
+}
+
+// UpdateRedirect changes the redirect set for a given path in place, preserving its counters.
+// It expects a JSON payload in the request body with the following structure:
+//
+//	{
+//	  "url": "https://example.com",
+//	  "duration": 10,
+//	  "keep_ttl": false,
+//	  "status_code": 301,
+//	  "tags": ["campaign"],
+//	  "notes": "free text"
+//	}
+//
+// On PUT requests, the redirect is replaced: "url" is required and the other fields follow the
+// same defaults as in SetSpecificRedirect, except that "keep_ttl": true preserves the remaining
+// time to live instead of resetting it to "duration".
+// On PATCH requests, every field is optional and only the given ones are changed, the remaining
+// time to live being preserved unless a new "duration" is given.
+// It replies in the same format as SetSpecificRedirect, with a 404 status if the path has no
+// redirect.
+func UpdateRedirect(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	replyError := setErrorJSONReply(w)
+	replySuccess := setSuccessJSONReply(w)
+
+	w.Header().Add("Content-Type", APPLICATION_JSON)
+
+	path := ps.ByName("path")
+	buffer, sizeRead, err := readJSONIntoBuffer(r, replyError)
+	if err != nil {
+		slog.DebugContext(r.Context(), "invalid request body", "error", err)
+		return
+	}
+	var jsonBody updateRedirectBody
+	if err := json.Unmarshal(buffer[:sizeRead], &jsonBody); err != nil {
+		slog.DebugContext(r.Context(), "failure parsing the request's body", "error", err)
+		replyError(http.StatusBadRequest, fmt.Sprintf("error parsing json in the request's body: %v", err.Error()))
+		return
+	}
+	isPatch := r.Method == http.MethodPatch
+	switch {
+	case jsonBody.KeepTTL && jsonBody.Duration != nil:
+		replyError(http.StatusBadRequest, "'duration' and 'keep_ttl' can't be used together")
+		return
+	case !isPatch && jsonBody.Url == nil:
+		replyError(http.StatusBadRequest, "the url is required")
 		return
 	}
 
-	replyError(http.StatusInternalServerError, fmt.Sprintf("failure setting '%v' to '%v'", chosen, parsedUrl.String()))
-	log.Printf("Failure setting '%v' to '%v'\n", chosen, parsedUrl.String())
+	ctx := r.Context()
+	keepTTL := jsonBody.KeepTTL || isPatch && jsonBody.Duration == nil
+	invalid := ""
+	record, err := records.ModifyRecord(ctx, path, keepTTL, func(record records.Record) (records.Record, error) {
+		if !isPatch {
+			record.StatusCode = records.DEFAULT_STATUS_CODE
+			record.Tags = []string{}
+			record.Notes = ""
+		}
+		if jsonBody.Url != nil {
+			record.URL = *jsonBody.Url
+		}
+		if jsonBody.StatusCode != nil {
+			record.StatusCode = *jsonBody.StatusCode
+		}
+		if jsonBody.Tags != nil {
+			record.Tags = *jsonBody.Tags
+		}
+		if jsonBody.Notes != nil {
+			record.Notes = *jsonBody.Notes
+		}
+		targetUrl, ok := validateTarget(record.URL, record.StatusCode, func(_ int, err string) { invalid = err })
+		if !ok {
+			return records.Record{}, errInvalidUpdate
+		}
+		record.URL = targetUrl
 
+		if keepTTL {
+			ttl, err := records.GetRecordTTL(ctx, path)
+			if err == nil && ttl > 0 {
+				record.ExpiresAt = time.Now().UTC().Add(ttl)
+			}
+		} else {
+			duration := DEFAULT_DURATION
+			if jsonBody.Duration != nil && *jsonBody.Duration != 0 {
+				duration = *jsonBody.Duration
+			}
+			record.Duration = duration
+			record.ExpiresAt = time.Now().UTC().Add(time.Duration(duration) * time.Second)
+		}
+		return record, nil
+	})
+	switch {
+	case errors.Is(err, records.ErrNotFound):
+		replyError(http.StatusNotFound, fmt.Sprintf("no redirect found for path '%v'", path))
+	case errors.Is(err, errInvalidUpdate):
+		replyError(http.StatusBadRequest, invalid)
+	case errors.Is(err, records.ErrConcurrentUpdate):
+		replyError(http.StatusConflict, fmt.Sprintf("the redirect for '%v' kept being changed by other requests", path))
+	case err != nil:
+		replyError(storeErrorStatus(err), fmt.Sprintf("failure updating '%v'", path))
+	default:
+		slog.InfoContext(ctx, "updated redirect", "path", path, "url", record.URL, "duration", record.Duration)
+		replySuccess(path, record)
+	}
 }
 
 // readJSONIntoBuffer reads JSON data from the request body into a buffer (prior to unmarshalling it).
@@ -292,7 +644,7 @@ func readJSONIntoBuffer(r *http.Request, replyError func(int, string)) ([]byte,
 
 	length, err := strconv.Atoi(r.Header.Get("content-length"))
 	if err != nil {
-		log.Println(err.Error())
+		slog.DebugContext(r.Context(), "invalid Content-Length header", "error", err)
 		err := fmt.Errorf("Content-Length header is required and must be valid")
 		replyError(http.StatusBadRequest, err.Error())
 		return nil, 0, err
@@ -304,7 +656,7 @@ func readJSONIntoBuffer(r *http.Request, replyError func(int, string)) ([]byte,
 		err = nil
 	}
 	if err != nil {
-		log.Println(err.Error())
+		slog.ErrorContext(r.Context(), "failure reading the request's body", "error", err)
 		err := fmt.Errorf("error reading the request's body: %v", err.Error())
 		replyError(http.StatusInternalServerError, err.Error())
 		return nil, 0, err
@@ -316,16 +668,205 @@ func readJSONIntoBuffer(r *http.Request, replyError func(int, string)) ([]byte,
 func Redirect(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	key := ps.ByName("redirectpath")
 	key = strings.Trim(key, "/")
-	redirectTo, err := records.GetString(key)
-	if err != nil {
-		log.Printf("Error: no redirect for key '%v'\n", key)
+	record, err := records.GetRecord(r.Context(), key)
+	if errors.Is(err, records.ErrNotFound) {
+		slog.InfoContext(r.Context(), "no redirect for path", "path", key)
 		w.WriteHeader(http.StatusNotFound)
 		w.Write([]byte(fmt.Sprintf("<h1>Error %v: URL not found!</h1>", http.StatusNotFound)))
 		return
+	} else if err != nil {
+		w.Header().Add("Content-Type", APPLICATION_JSON)
+		setErrorJSONReply(w)(storeErrorStatus(err), fmt.Sprintf("error getting redirect for path '%v'", key))
+		return
 	}
-	go records.IncrCountServedRedirects()
-	w.Header().Set("Location", redirectTo)
-	w.WriteHeader(http.StatusTemporaryRedirect)
+	records.RecordHit(records.Hit{
+		Key:       key,
+		Time:      time.Now(),
+		Referrer:  r.Referer(),
+		UserAgent: r.UserAgent(),
+		Country:   r.Header.Get(COUNTRY_HEADER),
+	})
+	w.Header().Set("Location", record.URL)
+	w.WriteHeader(record.StatusCode)
+}
+
+// getRedirectReply is the JSON reply of GetRedirect.
+type getRedirectReply struct {
+	Error interface{} `json:"error"`
+	Path  string      `json:"path"`
+	Url   string      `json:"url"`
+	// TTL is the remaining time to live in seconds, or null if the redirect never expires.
+	TTL    *int64         `json:"ttl"`
+	Hits   int64          `json:"hits"`
+	Record records.Record `json:"record"`
+}
+
+// GetRedirect returns the redirect set for a given path without following it, so it
+// doesn't count as a served redirect.
+// If the redirect exists, the response will be:
+//
+//	{
+//	  "error": null,
+//	  "path": "path",
+//	  "url": "https://example.com",
+//	  "ttl": 3600,
+//	  "hits": 42,
+//	  "record": {"url": "https://example.com", "created_at": "...", ...}
+//	}
+//
+// where "ttl" is the remaining time to live in seconds (null if it never expires) and "hits" is
+// the number of times the redirect was served. The ETag header identifies the current version
+// of the redirect, to be used in If-Match preconditions.
+// Otherwise, an error response is returned in the same format as SetSpecificRedirect's.
+func GetRedirect(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	replyError := setErrorJSONReply(w)
+	w.Header().Add("Content-Type", APPLICATION_JSON)
+
+	path := ps.ByName("path")
+	replyLookupError := func(err error) {
+		if errors.Is(err, records.ErrNotFound) {
+			replyError(http.StatusNotFound, fmt.Sprintf("no redirect found for path '%v'", path))
+			return
+		}
+		replyError(storeErrorStatus(err), fmt.Sprintf("error getting redirect for path '%v': %v", path, err.Error()))
+	}
+
+	ctx := r.Context()
+	record, err := records.GetRecord(ctx, path)
+	if err != nil {
+		replyLookupError(err)
+		return
+	}
+	ttl, err := records.GetRecordTTL(ctx, path)
+	if err != nil {
+		replyLookupError(err)
+		return
+	}
+	hits, err := records.GetCountHits(ctx, path)
+	if err != nil {
+		replyLookupError(err)
+		return
+	}
+
+	w.Header().Set("ETag", record.ETag())
+	reply := getRedirectReply{nil, path, record.URL, ttlSeconds(ttl), hits, record}
+	resp, _ := json.Marshal(reply)
+	w.Write(resp)
+}
+
+// ttlSeconds converts a remaining time to live into whole seconds, returning nil if it is 0
+// (never expires).
+func ttlSeconds(ttl time.Duration) *int64 {
+	if ttl <= 0 {
+		return nil
+	}
+	seconds := int64(ttl.Seconds())
+	return &seconds
+}
+
+// listedRedirect is an entry in the reply of ListRedirects.
+type listedRedirect struct {
+	Path string `json:"path"`
+	Url  string `json:"url,omitempty"`
+	// TTL is the remaining time to live in seconds, absent if the redirect never expires.
+	TTL *int64 `json:"ttl,omitempty"`
+}
+
+// listRedirectsReply is the JSON reply of ListRedirects.
+type listRedirectsReply struct {
+	Error  interface{}      `json:"error"`
+	Cursor string           `json:"cursor"`
+	Items  []listedRedirect `json:"items"`
+}
+
+const DEFAULT_LIST_COUNT = 100
+const MAX_LIST_COUNT = 1000
+
+// ListRedirects lists the paths that have redirects, a page at a time, without blocking the
+// store. It accepts the following query parameters, all optional:
+//   - cursor: the cursor returned by the previous page, starting at "0".
+//   - count: how many paths to examine, up to 1000 (100 by default). Pages may have fewer
+//     entries than count, or none, before the listing ends.
+//   - prefix: lists only the paths that start with it.
+//   - match: lists only the paths that match this glob pattern after the prefix.
+//   - details: if "true", includes the target url and the remaining time to live (in seconds)
+//     of each redirect.
+//
+// The response will be:
+//
+//	{
+//	  "error": null,
+//	  "cursor": "1234",
+//	  "items": [{"path": "abcd", "url": "https://example.com", "ttl": 3600}]
+//	}
+//
+// where "cursor" must be passed to get the next page, and is "0" on the last page.
+// If there is an error, the response will be:
+//
+//	{
+//	  "error": "failure message"
+//	}
+func ListRedirects(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	replyError := func(status int, err string) {
+		w.WriteHeader(status)
+		resp, _ := json.Marshal(listRedirectsReply{err, "0", []listedRedirect{}})
+		w.Write(resp)
+	}
+	w.Header().Add("Content-Type", APPLICATION_JSON)
+
+	query := r.URL.Query()
+	var cursor uint64
+	if query.Get("cursor") != "" {
+		var err error
+		cursor, err = strconv.ParseUint(query.Get("cursor"), 10, 64)
+		if err != nil {
+			replyError(http.StatusBadRequest, "cursor must be a non-negative integer")
+			return
+		}
+	}
+	count := int64(DEFAULT_LIST_COUNT)
+	if query.Get("count") != "" {
+		var err error
+		count, err = strconv.ParseInt(query.Get("count"), 10, 64)
+		if err != nil || count <= 0 || count > MAX_LIST_COUNT {
+			replyError(http.StatusBadRequest, fmt.Sprintf("count must be an integer between 1 and %v", MAX_LIST_COUNT))
+			return
+		}
+	}
+
+	ctx := r.Context()
+	keys, next, err := records.ListKeys(ctx, cursor, query.Get("prefix"), query.Get("match"), count)
+	if errors.Is(err, records.ErrInvalidCursor) {
+		replyError(http.StatusBadRequest, "the cursor is invalid or expired, the listing must start again from \"0\"")
+		return
+	} else if err != nil {
+		replyError(storeErrorStatus(err), fmt.Sprintf("error listing redirects: %v", err.Error()))
+		return
+	}
+
+	items := make([]listedRedirect, 0, len(keys))
+	for _, key := range keys {
+		item := listedRedirect{Path: key}
+		if query.Get("details") == "true" {
+			record, err := records.GetRecord(ctx, key)
+			var ttl time.Duration
+			if err == nil {
+				ttl, err = records.GetRecordTTL(ctx, key)
+			}
+			if errors.Is(err, records.ErrNotFound) {
+				// The redirect expired or was deleted since it was listed.
+				continue
+			} else if err != nil {
+				replyError(storeErrorStatus(err), fmt.Sprintf("error getting redirect for path '%v': %v", key, err.Error()))
+				return
+			}
+			item.Url = record.URL
+			item.TTL = ttlSeconds(ttl)
+		}
+		items = append(items, item)
+	}
+	resp, _ := json.Marshal(listRedirectsReply{nil, strconv.FormatUint(next, 10), items})
+	w.Write(resp)
 }
 
 // DelRedirect deletes the redirect for a given path.
@@ -343,7 +884,7 @@ func DelRedirect(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		return
 	}
 	path := ps.ByName("path")
-	deleted, err := records.DelKey(path)
+	deleted, err := records.DelKey(r.Context(), path)
 	if deleted {
 		w.WriteHeader(http.StatusOK)
 		resp, _ := json.Marshal(struct {
@@ -353,32 +894,168 @@ func DelRedirect(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	} else if err == nil {
 		delErrorJSONReply(http.StatusNotFound, fmt.Sprintf("no redirect found for path '%v'", path))
 	} else {
-		delErrorJSONReply(http.StatusInternalServerError, fmt.Sprintf("error deleting redirect for path '%v': %v", path, err.Error()))
+		delErrorJSONReply(storeErrorStatus(err), fmt.Sprintf("error deleting redirect for path '%v': %v", path, err.Error()))
+	}
+
+}
+
+// hitStatsReply is the JSON reply of GetStats for a redirect.
+type hitStatsReply struct {
+	Error       interface{}         `json:"error"`
+	Path        string              `json:"path"`
+	Granularity records.Granularity `json:"granularity"`
+	From        time.Time           `json:"from"`
+	To          time.Time           `json:"to"`
+	records.HitStats
+}
+
+// MAX_STATS_DAYS is the longest period GetStats accepts.
+const MAX_STATS_DAYS = 366
+
+// GetStats returns the analytics of the redirect of a given path. The paths "urlcount",
+// "redirectcount" and "droppedcount" are reserved for GetTotalSetRedirects,
+// GetTotalServedRedirects and GetTotalDroppedIncrements.
+// It accepts the following query parameters, all optional:
+//   - from, to: the period of the analytics, as RFC 3339 timestamps or dates (YYYY-MM-DD),
+//     defaulting to the last 7 days (or 24 hours with hourly granularity).
+//   - granularity: "day" (the default) or "hour", the size of the buckets of the series.
+//
+// The response will be:
+//
+//	{
+//	  "error": null,
+//	  "path": "abcd",
+//	  "granularity": "day",
+//	  "from": "2024-06-01T00:00:00Z",
+//	  "to": "2024-06-07T23:59:59Z",
+//	  "total": 12,
+//	  "series": [{"time": "2024-06-01T00:00:00Z", "hits": 2}, ...],
+//	  "referrers": {"direct": 10, "example.com": 2},
+//	  "agents": {"chrome": 8, "bot": 4},
+//	  "countries": {"BR": 12}
+//	}
+//
+// where the breakdowns by referrer host, browser family and country are counted over the whole
+// days within the period.
+// If there is an error, the response will be:
+//
+//	{
+//	  "error": "failure message"
+//	}
+func GetStats(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	switch ps.ByName("path") {
+	case "urlcount":
+		GetTotalSetRedirects(w, r, ps)
+		return
+	case "redirectcount":
+		GetTotalServedRedirects(w, r, ps)
+		return
+	case "droppedcount":
+		GetTotalDroppedIncrements(w, r, ps)
+		return
+	}
+
+	replyError := func(status int, err string) {
+		w.WriteHeader(status)
+		resp, _ := json.Marshal(struct {
+			Error interface{} `json:"error"`
+		}{err})
+		w.Write(resp)
+	}
+	w.Header().Add("Content-Type", APPLICATION_JSON)
+
+	query := r.URL.Query()
+	granularity := records.Granularity(query.Get("granularity"))
+	switch granularity {
+	case "":
+		granularity = records.DAILY
+	case records.DAILY, records.HOURLY:
+	default:
+		replyError(http.StatusBadRequest, "granularity must be 'day' or 'hour'")
+		return
+	}
+
+	to := time.Now().UTC()
+	if query.Get("to") != "" {
+		var err error
+		if to, err = parseStatsTime(query.Get("to"), true); err != nil {
+			replyError(http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	from := to.Add(-7 * 24 * time.Hour)
+	if granularity == records.HOURLY {
+		from = to.Add(-24 * time.Hour)
+	}
+	if query.Get("from") != "" {
+		var err error
+		if from, err = parseStatsTime(query.Get("from"), false); err != nil {
+			replyError(http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	switch {
+	case from.After(to):
+		replyError(http.StatusBadRequest, "'from' must not be after 'to'")
+		return
+	case to.Sub(from) > MAX_STATS_DAYS*24*time.Hour:
+		replyError(http.StatusBadRequest, fmt.Sprintf("the period must be at most %v days long", MAX_STATS_DAYS))
+		return
+	}
+
+	path := ps.ByName("path")
+	stats, err := records.GetHitStats(r.Context(), path, from, to, granularity)
+	if err != nil {
+		replyError(storeErrorStatus(err), fmt.Sprintf("error getting stats for path '%v': %v", path, err.Error()))
+		return
 	}
+	resp, _ := json.Marshal(hitStatsReply{nil, path, granularity, from, to, stats})
+	w.Write(resp)
+}
 
+// parseStatsTime parses a timestamp in RFC 3339 or a date, which is taken as its start or, if
+// endOfDay is true, its last second.
+func parseStatsTime(value string, endOfDay bool) (time.Time, error) {
+	if parsed, err := time.Parse(time.RFC3339, value); err == nil {
+		return parsed.UTC(), nil
+	}
+	parsed, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("'%v' is neither an RFC 3339 timestamp nor a date (YYYY-MM-DD)", value)
+	}
+	if endOfDay {
+		parsed = parsed.Add(24*time.Hour - time.Second)
+	}
+	return parsed, nil
 }
 
 // GetTotalServedRedirects returns the total number of served redirects.
 // The function returns a JSON response, where the body is the total number of served redirects.
 // If there is an error in retrieving the count, the response will be 'null'.
 func GetTotalServedRedirects(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	returnCount(w, records.GetCountServedRedirects)
+	returnCount(w, r, records.GetCountServedRedirects)
 }
 
 // GetTotalSetRedirects returns the total number of set redirects.
 // The function returns a JSON response, where the body is the total number of set redirects.
 // If there is an error in retrieving the count, the response will be 'null'.
 func GetTotalSetRedirects(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	returnCount(w, records.GetCountURLsSet)
+	returnCount(w, r, records.GetCountURLsSet)
+}
+
+// GetTotalDroppedIncrements returns the number of counter increments dropped since the server
+// started, because too many were waiting to be written to the store.
+// The function returns a JSON response, where the body is the number of dropped increments.
+func GetTotalDroppedIncrements(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	returnCount(w, r, func(context.Context) (int64, error) { return records.DroppedIncrements(), nil })
 }
 
 // returnCount is a helper function that returns the value of a specified counter.
-func returnCount(w http.ResponseWriter, getCount func() (int64, error)) {
+func returnCount(w http.ResponseWriter, r *http.Request, getCount func(context.Context) (int64, error)) {
 	w.Header().Add("Content-Type", APPLICATION_JSON)
-	totalURLs, err := getCount()
+	totalURLs, err := getCount(r.Context())
 	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(storeErrorStatus(err))
 		w.Write([]byte("null"))
 		return
 	}
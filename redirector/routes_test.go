@@ -0,0 +1,499 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/luizcdc/redirectory/redirector/records"
+	"github.com/luizcdc/redirectory/redirector/records/store"
+	"github.com/luizcdc/redirectory/redirector/uint_to_any_base"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// newTestRouter configures the global constants and an in-memory store, returning the router
+// used by the server.
+func newTestRouter(t *testing.T) *httprouter.Router {
+	t.Helper()
+	t.Setenv("RUNNING_ENV", "TEST")
+	records.UseStore(store.NewMemoryStore(0))
+	ALLOWED_CHARS = "abcdefghijklmnopqrstuvwxyz0123456789"
+	RANDOM_SIZE = 4
+	DEFAULT_DURATION = 60
+	API_KEY = "secret"
+	AUTH_DISABLED = false
+	var err error
+	intToString, err = uint_to_any_base.NewNumeralSystem(uint32(len(ALLOWED_CHARS)), ALLOWED_CHARS, uint32(RANDOM_SIZE))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return DefineRoutes(CreateAuthSubRouter())
+}
+
+// newTestRequest creates a request authenticated with the test API key.
+func newTestRequest(method string, target string, body string) *http.Request {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+API_KEY)
+	if body != "" {
+		req.Header.Set("Content-Type", APPLICATION_JSON)
+		req.Header.Set("Content-Length", fmt.Sprint(len(body)))
+	}
+	return req
+}
+
+// serve serves a request through the router.
+func serve(router http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+// doRequest serves a request through the router, authenticating it with the test API key.
+func doRequest(router http.Handler, method string, target string, body string) *httptest.ResponseRecorder {
+	return serve(router, newTestRequest(method, target, body))
+}
+
+func TestSetSpecificRedirectAndRedirect(t *testing.T) {
+	router := newTestRouter(t)
+
+	rec := doRequest(router, http.MethodPost, "/api/set/docs", `{"url": "https://example.com/docs"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST /api/set/docs returned status %v: %v", rec.Code, rec.Body.String())
+	}
+
+	rec = doRequest(router, http.MethodGet, "/docs", "")
+	if rec.Code != http.StatusTemporaryRedirect {
+		t.Errorf("GET /docs returned status %v, want %v", rec.Code, http.StatusTemporaryRedirect)
+	}
+	if location := rec.Header().Get("Location"); location != "https://example.com/docs" {
+		t.Errorf("GET /docs redirected to '%v', want 'https://example.com/docs'", location)
+	}
+
+	rec = doRequest(router, http.MethodGet, "/nope", "")
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("GET /nope returned status %v, want %v", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestSetRandomRedirect(t *testing.T) {
+	router := newTestRouter(t)
+
+	rec := doRequest(router, http.MethodPost, "/api/set", `{"url": "https://example.com", "duration": 10}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST /api/set returned status %v: %v", rec.Code, rec.Body.String())
+	}
+	var reply setRedirectReply
+	if err := json.Unmarshal(rec.Body.Bytes(), &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Error != nil || len(reply.Path) != RANDOM_SIZE || reply.Duration != 10 || reply.TTL == nil || *reply.TTL > 10 {
+		t.Errorf("POST /api/set replied %+v", reply)
+	}
+	if reply.Record == nil || reply.Record.URL != "https://example.com" || reply.Record.CreatedAt.IsZero() {
+		t.Errorf("POST /api/set replied with record %+v", reply.Record)
+	}
+
+	rec = doRequest(router, http.MethodGet, "/"+reply.Path, "")
+	if rec.Code != http.StatusTemporaryRedirect {
+		t.Errorf("GET /%v returned status %v, want %v", reply.Path, rec.Code, http.StatusTemporaryRedirect)
+	}
+}
+
+func TestRedirectStatusCode(t *testing.T) {
+	router := newTestRouter(t)
+
+	rec := doRequest(router, http.MethodPost, "/api/set/perm", `{"url": "https://example.com", "status_code": 301}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST /api/set/perm returned status %v: %v", rec.Code, rec.Body.String())
+	}
+	rec = doRequest(router, http.MethodGet, "/perm", "")
+	if rec.Code != http.StatusMovedPermanently {
+		t.Errorf("GET /perm returned status %v, want %v", rec.Code, http.StatusMovedPermanently)
+	}
+
+	rec = doRequest(router, http.MethodPost, "/api/set/bad1", `{"url": "https://example.com", "status_code": 200}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("POST with status_code 200 returned status %v, want %v", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestDelRedirect(t *testing.T) {
+	router := newTestRouter(t)
+
+	doRequest(router, http.MethodPost, "/api/set/docs", `{"url": "https://example.com/docs"}`)
+	rec := doRequest(router, http.MethodDelete, "/api/del/docs", "")
+	if rec.Code != http.StatusOK {
+		t.Errorf("DELETE /api/del/docs returned status %v, want %v", rec.Code, http.StatusOK)
+	}
+	rec = doRequest(router, http.MethodDelete, "/api/del/docs", "")
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("second DELETE /api/del/docs returned status %v, want %v", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestAnonymousAuth(t *testing.T) {
+	router := newTestRouter(t)
+	API_KEY = ""
+	if _, _, err := records.CreateAPIKey(context.Background(), "ci", records.SCOPES); err != nil {
+		t.Fatal(err)
+	}
+	anonymous := func(method string, target string, body string) *httptest.ResponseRecorder {
+		req := newTestRequest(method, target, body)
+		req.Header.Del("Authorization")
+		return serve(router, req)
+	}
+
+	for _, target := range []string{"/api/keys", "/api/list"} {
+		if rec := anonymous(http.MethodGet, target, ""); rec.Code != http.StatusUnauthorized {
+			t.Errorf("GET %v without an API key returned status %v, want %v", target, rec.Code, http.StatusUnauthorized)
+		}
+	}
+
+	AUTH_DISABLED = true
+	if rec := anonymous(http.MethodGet, "/api/list", ""); rec.Code != http.StatusOK {
+		t.Errorf("GET /api/list without an API key and with AUTH_DISABLED returned status %v, want %v", rec.Code, http.StatusOK)
+	}
+	if rec := anonymous(http.MethodPost, "/api/keys", `{"name": "mine", "scopes": ["keys:admin"]}`); rec.Code != http.StatusForbidden {
+		t.Errorf("POST /api/keys without an API key and with AUTH_DISABLED returned status %v, want %v", rec.Code, http.StatusForbidden)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	router := newTestRouter(t)
+
+	req := newTestRequest(http.MethodDelete, "/api/del/docs", "")
+	req.Header.Set("Authorization", "Bearer wrong")
+	rec := serve(router, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("request with a wrong API key returned status %v, want %v", rec.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestGetRedirect(t *testing.T) {
+	router := newTestRouter(t)
+
+	doRequest(router, http.MethodPost, "/api/set/info", `{"url": "https://example.com/info", "duration": 100}`)
+	doRequest(router, http.MethodGet, "/info", "")
+
+	// The hit is only counted once the counters are flushed.
+	if err := records.FlushCounters(); err != nil {
+		t.Fatal(err)
+	}
+	rec := doRequest(router, http.MethodGet, "/api/get/info", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /api/get/info returned status %v: %v", rec.Code, rec.Body.String())
+	}
+	var reply getRedirectReply
+	if err := json.Unmarshal(rec.Body.Bytes(), &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Url != "https://example.com/info" || reply.Hits != 1 || reply.Record.Duration != 100 {
+		t.Errorf("GET /api/get/info replied %+v", reply)
+	}
+	if reply.TTL == nil || *reply.TTL <= 0 || *reply.TTL > 100 {
+		t.Errorf("GET /api/get/info replied with ttl %v, want up to 100", reply.TTL)
+	}
+
+	rec = doRequest(router, http.MethodGet, "/api/get/nope", "")
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("GET /api/get/nope returned status %v, want %v", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestUpdateRedirect(t *testing.T) {
+	router := newTestRouter(t)
+
+	doRequest(router, http.MethodPost, "/api/set/edit", `{"url": "https://example.com/old", "duration": 100, "tags": ["a"]}`)
+
+	rec := doRequest(router, http.MethodPatch, "/api/set/edit", `{"url": "https://example.com/new"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PATCH /api/set/edit returned status %v: %v", rec.Code, rec.Body.String())
+	}
+	record, _ := records.GetRecord(context.Background(), "edit")
+	if record.URL != "https://example.com/new" || len(record.Tags) != 1 || record.Duration != 100 {
+		t.Errorf("PATCH /api/set/edit left the record as %+v", record)
+	}
+
+	rec = doRequest(router, http.MethodPut, "/api/set/edit", `{"url": "https://example.com/put", "duration": 1000}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PUT /api/set/edit returned status %v: %v", rec.Code, rec.Body.String())
+	}
+	record, _ = records.GetRecord(context.Background(), "edit")
+	if ttl, _ := records.GetRecordTTL(context.Background(), "edit"); ttl <= 100*time.Second || len(record.Tags) != 0 {
+		t.Errorf("PUT /api/set/edit left the record as %+v with ttl %v", record, ttl)
+	}
+
+	rec = doRequest(router, http.MethodPut, "/api/set/edit", `{"url": "https://example.com/put", "duration": 10, "keep_ttl": true}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("PUT with both duration and keep_ttl returned status %v, want %v", rec.Code, http.StatusBadRequest)
+	}
+	rec = doRequest(router, http.MethodPut, "/api/set/none", `{"url": "https://example.com/put"}`)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("PUT /api/set/none returned status %v, want %v", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestSetSpecificRedirectConflict(t *testing.T) {
+	router := newTestRouter(t)
+
+	doRequest(router, http.MethodPost, "/api/set/taken", `{"url": "https://example.com/first"}`)
+	rec := doRequest(router, http.MethodPost, "/api/set/taken", `{"url": "https://example.com/second"}`)
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("POST on a taken path returned status %v, want %v", rec.Code, http.StatusConflict)
+	}
+	var reply setRedirectReply
+	json.Unmarshal(rec.Body.Bytes(), &reply)
+	if reply.Record == nil || reply.Record.URL != "https://example.com/first" {
+		t.Errorf("409 reply did not include the current redirect: %v", rec.Body.String())
+	}
+	etag := rec.Header().Get("ETag")
+
+	rec = doRequest(router, http.MethodPost, "/api/set/taken", `{"url": "https://example.com/second", "overwrite": true}`)
+	if rec.Code != http.StatusOK {
+		t.Errorf("POST with overwrite returned status %v, want %v", rec.Code, http.StatusOK)
+	}
+
+	req := newTestRequest(http.MethodPost, "/api/set/taken", `{"url": "https://example.com/third"}`)
+	req.Header.Set("If-Match", etag)
+	rec = serve(router, req)
+	if rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("POST with a stale If-Match returned status %v, want %v", rec.Code, http.StatusPreconditionFailed)
+	}
+
+	record, _ := records.GetRecord(context.Background(), "taken")
+	if record.URL != "https://example.com/second" {
+		t.Errorf("the redirect is now %v, want https://example.com/second", record.URL)
+	}
+}
+
+func TestListRedirects(t *testing.T) {
+	router := newTestRouter(t)
+
+	for _, path := range []string{"list1", "list2", "list3", "other"} {
+		doRequest(router, http.MethodPost, "/api/set/"+path, `{"url": "https://example.com/`+path+`"}`)
+	}
+
+	var items []listedRedirect
+	cursor := "0"
+	for pages := 0; pages == 0 || cursor != "0"; pages++ {
+		if pages > 10 {
+			t.Fatal("GET /api/list never returned the last page")
+		}
+		rec := doRequest(router, http.MethodGet, "/api/list?details=true&count=2&prefix=list&cursor="+cursor, "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET /api/list returned status %v: %v", rec.Code, rec.Body.String())
+		}
+		var reply listRedirectsReply
+		if err := json.Unmarshal(rec.Body.Bytes(), &reply); err != nil {
+			t.Fatal(err)
+		}
+		items = append(items, reply.Items...)
+		cursor = reply.Cursor
+	}
+	if len(items) != 3 {
+		t.Fatalf("GET /api/list?prefix=list listed %+v, want 3 items", items)
+	}
+	for _, item := range items {
+		if item.Url != "https://example.com/"+item.Path || item.TTL == nil {
+			t.Errorf("GET /api/list?details=true listed %+v", item)
+		}
+	}
+
+	rec := doRequest(router, http.MethodGet, "/api/list?count=0", "")
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("GET /api/list?count=0 returned status %v, want %v", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestGetStats(t *testing.T) {
+	router := newTestRouter(t)
+
+	doRequest(router, http.MethodPost, "/api/set/stat", `{"url": "https://example.com/stats"}`)
+	req := newTestRequest(http.MethodGet, "/stat", "")
+	req.Header.Set("Referer", "https://news.example.org/post")
+	req.Header.Set(COUNTRY_HEADER, "br")
+	serve(router, req)
+
+	// The hit is only recorded once the counters are flushed.
+	if err := records.FlushCounters(); err != nil {
+		t.Fatal(err)
+	}
+	rec := doRequest(router, http.MethodGet, "/api/stats/stat?granularity=hour", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /api/stats/stat returned status %v: %v", rec.Code, rec.Body.String())
+	}
+	var reply hitStatsReply
+	if err := json.Unmarshal(rec.Body.Bytes(), &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Total != 1 || reply.Referrers["news.example.org"] != 1 || reply.Countries["BR"] != 1 {
+		t.Errorf("GET /api/stats/stat replied %+v", reply)
+	}
+	if len(reply.Series) < 24 || len(reply.Series) > 25 {
+		t.Errorf("GET /api/stats/stat?granularity=hour replied with %v buckets, want 24 or 25", len(reply.Series))
+	}
+
+	rec = doRequest(router, http.MethodGet, "/api/stats/stat?granularity=week", "")
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("GET /api/stats/stat?granularity=week returned status %v, want %v", rec.Code, http.StatusBadRequest)
+	}
+	rec = doRequest(router, http.MethodGet, "/api/stats/urlcount", "")
+	if rec.Code != http.StatusOK || rec.Body.String() == "null" {
+		t.Errorf("GET /api/stats/urlcount returned status %v: %v", rec.Code, rec.Body.String())
+	}
+	rec = doRequest(router, http.MethodGet, "/api/stats/droppedcount", "")
+	if rec.Code != http.StatusOK || rec.Body.String() != "0" {
+		t.Errorf("GET /api/stats/droppedcount returned status %v: %v", rec.Code, rec.Body.String())
+	}
+}
+
+// slowStore is a Store whose reads only return once their context is done.
+type slowStore struct {
+	*store.MemoryStore
+}
+
+func (s slowStore) Get(ctx context.Context, key string) (string, error) {
+	<-ctx.Done()
+	return "", ctx.Err()
+}
+
+func TestStoreTimeout(t *testing.T) {
+	router := newTestRouter(t)
+	records.UseStore(slowStore{store.NewMemoryStore(0)})
+	records.SetTimeouts(10*time.Millisecond, 0)
+	t.Cleanup(func() { records.SetTimeouts(0, 0) })
+
+	rec := doRequest(router, http.MethodGet, "/slow", "")
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Errorf("GET /slow returned status %v, want %v", rec.Code, http.StatusGatewayTimeout)
+	}
+	var reply setRedirectReply
+	if err := json.Unmarshal(rec.Body.Bytes(), &reply); err != nil || reply.Error == nil {
+		t.Errorf("GET /slow replied %v, want a JSON error", rec.Body.String())
+	}
+
+	rec = doRequest(router, http.MethodGet, "/api/get/slow", "")
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Errorf("GET /api/get/slow returned status %v, want %v", rec.Code, http.StatusGatewayTimeout)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	router := newTestRouter(t)
+
+	doRequest(router, http.MethodPost, "/api/set/metr", `{"url": "https://example.com"}`)
+	doRequest(router, http.MethodGet, "/metr", "")
+	rec := doRequest(router, http.MethodPost, "/api/set/metrics", `{"url": "https://example.com"}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("POST /api/set/metrics returned status %v, want %v", rec.Code, http.StatusBadRequest)
+	}
+
+	// The metrics don't require authentication.
+	rec = serve(router, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /metrics returned status %v: %v", rec.Code, rec.Body.String())
+	}
+	for _, want := range []string{
+		`redirectory_http_requests_total{code="307",route="Redirect"}`,
+		`redirectory_http_requests_total{code="200",route="SetSpecificRedirect"}`,
+		`redirectory_http_request_duration_seconds_count{route="Redirect"}`,
+		`redirectory_random_path_collisions_total`,
+	} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("GET /metrics didn't return %v", want)
+		}
+	}
+}
+
+func TestTracing(t *testing.T) {
+	router := newTestRouter(t)
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTracerProvider(sdktrace.NewTracerProvider()) })
+
+	doRequest(router, http.MethodPost, "/api/set/trac", `{"url": "https://example.com"}`)
+	req := newTestRequest(http.MethodGet, "/trac", "")
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	serve(router, req)
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	redirect, getRecord := spans["Redirect"], spans["records.GetRecord"]
+	if redirect == nil || getRecord == nil {
+		t.Fatalf("GET /trac didn't record the Redirect and records.GetRecord spans")
+	}
+	if redirect.SpanContext().TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("the Redirect span didn't continue the trace of the request")
+	}
+	if getRecord.Parent().SpanID() != redirect.SpanContext().SpanID() {
+		t.Errorf("the records.GetRecord span isn't a child of the Redirect span")
+	}
+	for _, attr := range getRecord.Attributes() {
+		if attr.Key == "cache.hit" && attr.Value.AsBool() {
+			t.Errorf("the records.GetRecord span reported a cache hit without a cache")
+		}
+	}
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	router := newTestRouter(t)
+
+	rec := doRequest(router, http.MethodPost, "/api/keys", `{"name": "writer", "scopes": ["links:write"]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST /api/keys returned status %v: %v", rec.Code, rec.Body.String())
+	}
+	var created apiKeyReply
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	if created.Key == "" || created.APIKey == nil || created.APIKey.Hash != "" {
+		t.Fatalf("POST /api/keys replied %+v", created)
+	}
+	asWriter := func(method string, target string, body string) *httptest.ResponseRecorder {
+		req := newTestRequest(method, target, body)
+		req.Header.Set("Authorization", "Bearer "+created.Key)
+		return serve(router, req)
+	}
+
+	if rec := asWriter(http.MethodPost, "/api/set/docs", `{"url": "https://example.com"}`); rec.Code != http.StatusOK {
+		t.Fatalf("POST /api/set/docs returned status %v: %v", rec.Code, rec.Body.String())
+	}
+	record, err := records.GetRecord(context.Background(), "docs")
+	if err != nil || record.CreatedBy != created.APIKey.ID {
+		t.Errorf("the redirect was created by '%v' (%v), want '%v'", record.CreatedBy, err, created.APIKey.ID)
+	}
+	for _, target := range []string{"/api/get/docs", "/api/stats/docs", "/api/keys"} {
+		if rec := asWriter(http.MethodGet, target, ""); rec.Code != http.StatusForbidden {
+			t.Errorf("GET %v returned status %v, want %v", target, rec.Code, http.StatusForbidden)
+		}
+	}
+	if rec := asWriter(http.MethodDelete, "/api/del/docs", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("DELETE /api/del/docs returned status %v, want %v", rec.Code, http.StatusForbidden)
+	}
+
+	rec = doRequest(router, http.MethodGet, "/api/keys", "")
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), `"hash"`) || !strings.Contains(rec.Body.String(), created.APIKey.ID) {
+		t.Errorf("GET /api/keys returned status %v: %v", rec.Code, rec.Body.String())
+	}
+	if rec := doRequest(router, http.MethodDelete, "/api/keys/"+created.APIKey.ID, ""); rec.Code != http.StatusOK {
+		t.Fatalf("DELETE /api/keys/%v returned status %v: %v", created.APIKey.ID, rec.Code, rec.Body.String())
+	}
+	if rec := asWriter(http.MethodPost, "/api/set/docs2", `{"url": "https://example.com"}`); rec.Code != http.StatusUnauthorized {
+		t.Errorf("POST /api/set/docs2 with a revoked key returned status %v, want %v", rec.Code, http.StatusUnauthorized)
+	}
+	if rec := doRequest(router, http.MethodPost, "/api/keys", `{"name": "root", "scopes": ["everything"]}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("POST /api/keys with an unknown scope returned status %v, want %v", rec.Code, http.StatusBadRequest)
+	}
+}
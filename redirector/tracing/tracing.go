@@ -0,0 +1,89 @@
+// Package tracing sets up the OpenTelemetry tracing of the server, exporting the spans through
+// OTLP, and provides helpers to trace requests.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TRACER_NAME identifies the spans created by the server.
+const TRACER_NAME = "github.com/luizcdc/redirectory/redirector"
+
+// DEFAULT_SERVICE_NAME is the name of the service in the spans, unless OTEL_SERVICE_NAME is set.
+const DEFAULT_SERVICE_NAME = "redirector"
+
+// Enabled indicates whether the spans are exported, which requires OTEL_EXPORTER_OTLP_ENDPOINT
+// or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT to be set.
+func Enabled() bool {
+	return os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""
+}
+
+// Setup exports the spans through OTLP over HTTP to the collector configured by the standard
+// OTEL_EXPORTER_OTLP_* environment variables, sampling them according to OTEL_TRACES_SAMPLER
+// (every span by default), and propagates the W3C trace context of incoming requests. If tracing
+// isn't enabled, spans are still created, so that the logs carry trace IDs, but they aren't
+// exported.
+// It returns the function that flushes the pending spans and stops exporting them.
+func Setup(ctx context.Context) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", DEFAULT_SERVICE_NAME)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failure describing the traced resource: %w", err)
+	}
+	options := []sdktrace.TracerProviderOption{sdktrace.WithResource(res)}
+	if Enabled() {
+		exporter, err := otlptracehttp.New(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failure creating the OTLP exporter: %w", err)
+		}
+		options = append(options, sdktrace.WithBatcher(exporter))
+		slog.Info("exporting traces through OTLP")
+	}
+	provider := sdktrace.NewTracerProvider(options...)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Tracer returns the tracer of the server, from the global tracer provider.
+func Tracer() trace.Tracer {
+	return otel.Tracer(TRACER_NAME)
+}
+
+// StartRoute starts the span of a request to the given route, continuing the trace of the client
+// if the request carries one.
+func StartRoute(r *http.Request, route string) (context.Context, trace.Span) {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	return Tracer().Start(ctx, route,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.request.method", r.Method),
+			attribute.String("url.path", r.URL.Path),
+		),
+	)
+}
+
+// EndRoute ends the span of a request, which failed if its status code is a server error.
+func EndRoute(span trace.Span, status int) {
+	span.SetAttributes(attribute.Int("http.response.status_code", status))
+	if status >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+	span.End()
+}